@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	accesstrace "github.com/project/ObliSql/pkg/accessTrace"
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
+)
+
+func printStats(name string, s accesstrace.Stats) {
+	fmt.Printf("%s,%d,%.2f,%.2f,%.2f,%.2f\n", name, s.Count, s.Mean, s.Variance, s.Min, s.Max)
+}
+
+func main() {
+	accessTracePtr := flag.String("a", "", "Comma-separated access traces logged by the ORAM executors with -at")
+	queryTracePtr := flag.String("qt", "", "Query trace logged by the resolver with -qt during the same run, to estimate leakage of query types")
+	windowPtr := flag.Duration("w", 500*time.Millisecond, "Traffic observed this long after a query is attributed to it, about the batcher's -Z")
+	outputPtr := flag.String("o", "", "File to write the analysis to as JSON")
+
+	flag.Parse()
+
+	if *accessTracePtr == "" {
+		log.Fatal("An access trace is required (-a)")
+	}
+	var batches []accesstrace.Batch
+	for _, path := range strings.Split(*accessTracePtr, ",") {
+		trace, err := accesstrace.Read(path)
+		if err != nil {
+			log.Fatalf("Failed to read the access trace: %v", err)
+		}
+		batches = append(batches, trace...)
+	}
+	var queries []querytrace.Entry
+	if *queryTracePtr != "" {
+		var err error
+		queries, err = querytrace.Read(*queryTracePtr)
+		if err != nil {
+			log.Fatalf("Failed to read the query trace: %v", err)
+		}
+		if queries == nil {
+			queries = []querytrace.Entry{}
+		}
+	}
+
+	a := accesstrace.Analyze(batches, queries, *windowPtr)
+	fmt.Printf("Batches: %d over %s\n", a.Batches, a.End.Sub(a.Start))
+	fmt.Println("Statistic,Count,Mean,Variance,Min,Max")
+	printStats("BatchSize", a.BatchSize)
+	executors := make([]int, 0, len(a.BatchSizeByExec))
+	for executor := range a.BatchSizeByExec {
+		executors = append(executors, executor)
+	}
+	sort.Ints(executors)
+	for _, executor := range executors {
+		printStats(fmt.Sprintf("BatchSize[%d]", executor), a.BatchSizeByExec[executor])
+	}
+	printStats("IntervalMs", a.IntervalMs)
+	printStats("DurationMs", a.DurationMs)
+	if a.Storage != nil {
+		printStats("StorageReads", a.Storage.Reads)
+		printStats("StorageWrites", a.Storage.Writes)
+	}
+	fmt.Printf("Real: %d, Fake: %d, Fake to Real: %.3f\n", a.Real, a.Fake, a.FakeToReal)
+	s := a.KeySkew
+	fmt.Printf("Keys: %d accesses to %d keys, Max to Mean: %.2f, Top Key Share: %.4f, Normalized Entropy: %.4f\n",
+		s.Accesses, s.DistinctKeys, s.MaxToMean, s.TopKeyShare, s.NormalizedEntropy)
+	if st := a.Storage; st != nil {
+		fmt.Printf("Storage Keys: %d reads of %d keys, Max to Mean: %.2f, Top Key Share: %.4f, Normalized Entropy: %.4f\n",
+			st.ReadSkew.Accesses, st.ReadSkew.DistinctKeys, st.ReadSkew.MaxToMean, st.ReadSkew.TopKeyShare, st.ReadSkew.NormalizedEntropy)
+	}
+	if l := a.Leakage; l != nil {
+		fmt.Printf("Queries: %d, Query Type Entropy: %.4f bits, Mutual Information with Traffic: %.4f bits\n",
+			l.Queries, l.QueryTypeEntropy, l.MutualInformation)
+	}
+
+	if *outputPtr != "" {
+		data, err := json.MarshalIndent(a, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to encode the analysis: %v", err)
+		}
+		if err := os.WriteFile(*outputPtr, data, 0644); err != nil {
+			log.Fatalf("Failed to write the analysis: %v", err)
+		}
+		fmt.Println("Analysis written to", *outputPtr)
+	}
+}
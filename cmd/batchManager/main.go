@@ -7,30 +7,49 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	loadBalancer "github.com/project/ObliSql/api/loadbalancer"
 	batcher "github.com/project/ObliSql/pkg/batchManager"
+	_ "github.com/project/ObliSql/pkg/memoryExecutor"
 	"github.com/project/ObliSql/pkg/tracing"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
 )
 
+func printRSchedule(schedule []batcher.RChange) {
+	if len(schedule) == 0 {
+		return
+	}
+	fmt.Println("R Schedule (Seconds,R,Queue Depth,Latency ms):")
+	for _, change := range schedule {
+		fmt.Printf("%.1f,%d,%d,%.1f\n", change.Time.Sub(schedule[0].Time).Seconds(), change.Size, change.Depth, change.LatencyMs)
+	}
+}
+
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	addrPort := flag.String("p", "9500", "Batcher Port")
 	rPtr := flag.Int("R", 800, "Real Number of Requests")
 	timeOutPtr := flag.Int("Z", 500, "Queue Wait time in Milliseconds")
 	nPtr := flag.Int("num", 1, "Number of Executors")
-	tPtr := flag.String("T", "Waffle", "Executor Type")
+	tPtr := flag.String("T", "Waffle", "Executor Type, one of: "+strings.Join(batcher.RegisteredExecutors(), ", "))
+	optionsPtr := flag.String("eo", "", "Executor specific options as key=value,key=value")
 	numCPtr := flag.Int("X", 1, "Number of clients to Waffle")
 	hostsPtr := flag.String("hosts", "localhost", "Comma-separated list of host addresses")
 	portsPtr := flag.String("ports", "9090", "Comma-separated list of port numbers")
 	fakeReqPtr := flag.Bool("fr", false, "Turn off Fake Requests")
+	dedupOffPtr := flag.Bool("dd", false, "Turn off merging repeated GETs of a key within a batch")
 	tracingBool := flag.Bool("t", false, "Tracing Boolean") //Default no tracing is on.
 	configPtr := flag.String("c", "./tracefiles/table_config.json", "Table configuration file path")
+	adaptiveRPtr := flag.String("Ra", "", "Comma-separated batch sizes to adapt R between based on load (default a fixed R)")
+	latencyTargetPtr := flag.Int("Rl", 0, "Mean batcher latency target in Milliseconds for the adaptive R, 0 to adapt on queue depth only")
+	constantRatePtr := flag.Bool("cr", false, "Constant-rate mode: send one padded batch of R to every executor every -Z milliseconds, whatever the load")
+	queueBoundPtr := flag.Int("cq", 0, "Keys each executor queue may hold in constant-rate mode before resolvers are pushed back (default 8 times the largest R)")
+	adaptIntervalPtr := flag.Int("Ri", 1000, "Time between adaptive R decisions in Milliseconds")
 
 	flag.Parse()
 
@@ -65,8 +84,31 @@ func main() {
 		grpc.MaxSendMsgSize(600*1024*1024), // 600 MB
 	)
 
+	executorOptions, err := batcher.ParseExecutorOptions(*optionsPtr)
+	if err != nil {
+		log.Fatal().Msgf("Invalid executor options: %v", err)
+	}
+
+	var adaptiveR *batcher.AdaptiveRConfig
+	if *adaptiveRPtr != "" {
+		sizes, err := batcher.ParseBatchSizes(*adaptiveRPtr)
+		if err != nil {
+			log.Fatal().Msgf("Invalid adaptive R sizes: %v", err)
+		}
+		adaptiveR = &batcher.AdaptiveRConfig{
+			Sizes:         sizes,
+			LatencyTarget: time.Duration(*latencyTargetPtr) * time.Millisecond,
+			Interval:      time.Duration(*adaptIntervalPtr) * time.Millisecond,
+		}
+	}
+
+	var constantRate *batcher.ConstantRateConfig
+	if *constantRatePtr {
+		constantRate = &batcher.ConstantRateConfig{QueueBound: *queueBoundPtr}
+	}
+
 	// Initialize the batcher service with Redis connection and tracingProvider
-	batchService := batcher.NewBatcher(ctx, *rPtr, *nPtr, *timeOutPtr, *tPtr, *hostsPtr, *portsPtr, *numCPtr, *fakeReqPtr, tracer, *configPtr)
+	batchService := batcher.NewBatcher(ctx, *rPtr, *nPtr, *timeOutPtr, *tPtr, executorOptions, *hostsPtr, *portsPtr, *numCPtr, *fakeReqPtr, *dedupOffPtr, adaptiveR, constantRate, tracer, *configPtr)
 
 	// Register the service with the gRPC server
 	loadBalancer.RegisterLoadBalancerServer(grpcServer, batchService)
@@ -102,6 +144,11 @@ func main() {
 			fmt.Println()
 			fmt.Printf("Total Fake Keys Added: %v", batchService.TotalFakeAdded.Load())
 			fmt.Println()
+			fmt.Printf("Total Coalesced GETs: %v", batchService.TotalCoalesced.Load())
+			fmt.Println()
+			fmt.Printf("Total Requests Rejected on Full Queues: %v", batchService.TotalRejected.Load())
+			fmt.Println()
+			printRSchedule(batchService.RSchedule())
 			fmt.Printf("Received signal: %v. Shutting down server...\n", sig)
 
 		case <-timer.C:
@@ -109,6 +156,11 @@ func main() {
 			fmt.Println()
 			fmt.Printf("Total Fake Keys Added: %v", batchService.TotalFakeAdded.Load())
 			fmt.Println()
+			fmt.Printf("Total Coalesced GETs: %v", batchService.TotalCoalesced.Load())
+			fmt.Println()
+			fmt.Printf("Total Requests Rejected on Full Queues: %v", batchService.TotalRejected.Load())
+			fmt.Println()
+			printRSchedule(batchService.RSchedule())
 			fmt.Println("Timeout reached. Shutting down server...")
 		}
 		// Gracefully stop the gRPC server
@@ -5,11 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/project/ObliSql/api/resolver"
 	"github.com/project/ObliSql/pkg/benchmark"
+	"github.com/project/ObliSql/pkg/oracle"
 	"golang.org/x/exp/rand"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -26,11 +28,47 @@ func main() {
 	qTypePtr := flag.String("q", "default", "Query Type (Scaling,BDB,Epinions,Default)")
 	joinRangePtr := flag.Int("jr", 0, "Join Bloom Check --> 1, Range Bloom --> 2")
 	rangeSize := flag.Int("rs", 5, "Range size if jr is 2")
+	workloadPtr := flag.String("w", "", "Workload definition file, replaces -q")
+	qpsPtr := flag.Float64("qps", 0, "Open-loop target arrival rate in queries per second (0 runs closed-loop with -s in-flight)")
+	arrivalPtr := flag.String("arrival", benchmark.ArrivalPoisson, "Open-loop interarrival times, poisson or constant")
+	sweepPtr := flag.String("sweep", "", "Open-loop QPS sweep as start,step,max until the SLO is violated")
+	sloPtr := flag.Duration("slo", 100*time.Millisecond, "Latency SLO of the sweep")
+	sloPercentilePtr := flag.Float64("slop", 99, "Percentile the sweep SLO applies to")
+	replayPtr := flag.String("replay", "", "Query trace logged by the resolver with -qt to replay, replaces -q and -w")
+	speedPtr := flag.Float64("speed", 1, "Replay speed factor, 2 halves the time between queries")
+	verifyPtr := flag.String("verify", "", "Tracefile the executors were loaded from, checks every answer against a plaintext copy of it")
+	metaDataPtr := flag.String("md", "", "Table metadata for -verify (default the resolver's metadata for -q)")
+	outputPtr := flag.String("o", "", "Latency report file, CSV if it ends in .csv and JSON otherwise (default benchmark_<timestamp>.json)")
 
 	flag.Parse()
 
 	rand.Seed(uint64(time.Now().UnixNano()))
 
+	if *arrivalPtr != benchmark.ArrivalPoisson && *arrivalPtr != benchmark.ArrivalConstant {
+		log.Fatalf("Unknown arrival process %s", *arrivalPtr)
+	}
+	openLoop := benchmark.OpenLoop{
+		QPS:           *qpsPtr,
+		Arrival:       *arrivalPtr,
+		SLO:           *sloPtr,
+		SLOPercentile: *sloPercentilePtr,
+	}
+	if *sweepPtr != "" {
+		sweep := splitCommaSeparated(*sweepPtr)
+		if len(sweep) != 3 {
+			log.Fatalf("Sweep %q is not start,step,max", *sweepPtr)
+		}
+		values := make([]float64, 3)
+		for i, v := range sweep {
+			value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || value <= 0 {
+				log.Fatalf("Sweep %q is not start,step,max", *sweepPtr)
+			}
+			values[i] = value
+		}
+		openLoop.SweepStart, openLoop.SweepStep, openLoop.SweepMax = values[0], values[1], values[2]
+	}
+
 	hosts := *hPtr
 	ports := *pPtr
 	resolverAddrs := []string{}
@@ -68,7 +106,31 @@ func main() {
 	if len(clients) == 0 {
 		log.Fatal("No resolvers available to connect.")
 	}
-	fmt.Println("Query Type:", *qTypePtr)
+	var verifier *benchmark.Verifier
+	if *verifyPtr != "" {
+		metaDataLoc := *metaDataPtr
+		if metaDataLoc == "" {
+			metaDataLoc = "../../metaData/metadata.txt"
+			if *qTypePtr == "bdb" {
+				metaDataLoc = "../../metaData/metadataBDB.txt"
+			}
+		}
+		o, err := oracle.Load(metaDataLoc, *verifyPtr)
+		if err != nil {
+			log.Fatalf("Failed to load the oracle: %v", err)
+		}
+		verifier = benchmark.NewVerifier(o)
+		verifier.Wrap(&clients)
+		fmt.Println("Verifying answers against", *verifyPtr)
+	}
+
+	if *replayPtr != "" {
+		benchmark.StartReplay(&clients, *replayPtr, *speedPtr, *outputPtr, verifier)
+		return
+	}
+	if *workloadPtr == "" {
+		fmt.Println("Query Type:", *qTypePtr)
+	}
 
-	benchmark.StartBench(&clients, *sPtr, *tPtr, *qTypePtr, *joinRangePtr, *rangeSize)
+	benchmark.StartBench(&clients, *sPtr, *tPtr, *qTypePtr, *joinRangePtr, *rangeSize, *workloadPtr, openLoop, *outputPtr, verifier)
 }
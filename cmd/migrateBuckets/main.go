@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	oramexecutor "github.com/project/ObliSql/pkg/oramExecutor"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Rewrites the JSON encoded ORAM buckets of a Redis instance, e.g. one started from an old
+// dump.rdb snapshot, into the binary bucket encoding, re-encrypts values encrypted with an
+// older key version, and optionally saves a new snapshot.
+func main() {
+	redisHost := flag.String("rh", "127.0.0.1", "Redis Host")
+	redisPort := flag.String("rp", "6379", "Redis Port")
+	keyFile := flag.String("kf", "", "File with the encryption keys, same as the executor's -kf")
+	keyEnv := flag.String("ke", "", "Environment variable with the encryption keys, same as the executor's -ke")
+	kmsDir := flag.String("kms", "", "Local key directory, same as the executor's -kms")
+	devKey := flag.Bool("devkey", false, "Allow the built-in development key")
+	blockSize := flag.Int("bs", 0, "Bytes every block is padded to, same as the executor's -bs. 0 disables padding")
+	scanCount := flag.Int("n", 1000, "Keys fetched per SCAN iteration")
+	dryRun := flag.Bool("dry", false, "Only report sizes, write nothing")
+	save := flag.Bool("save", false, "Run SAVE after migrating so dump.rdb holds the new encoding")
+	flag.Parse()
+
+	store, err := oramexecutor.NewRedisStore(*redisHost + ":" + *redisPort)
+	if err != nil {
+		log.Fatal().Msgf("Failed to connect to Redis: %v", err)
+	}
+	defer store.Close()
+
+	keys, err := oramexecutor.LoadKeyRing(*keyFile, *keyEnv, *kmsDir, *devKey)
+	if err != nil {
+		log.Fatal().Msgf("Failed to load encryption keys: %v", err)
+	}
+
+	stats, err := oramexecutor.MigrateBuckets(store, keys, *blockSize, *scanCount, *dryRun)
+	fmt.Printf("Values: %d, Migrated: %d, Largest Block: %d bytes\n", stats.Values, stats.Migrated, stats.LargestBlock)
+	fmt.Printf("Bytes Before: %d, Bytes After: %d\n", stats.BytesBefore, stats.BytesAfter)
+	if err != nil {
+		log.Fatal().Msgf("Migration failed: %v", err)
+	}
+	if *blockSize > 0 && stats.LargestBlock > *blockSize {
+		fmt.Printf("Block size %d is too small, use at least %d\n", *blockSize, stats.LargestBlock)
+	}
+
+	if *save && !*dryRun {
+		if err := store.Client.Save(store.Ctx).Err(); err != nil {
+			log.Fatal().Msgf("SAVE failed: %v", err)
+		}
+		fmt.Println("Snapshot saved.")
+	}
+}
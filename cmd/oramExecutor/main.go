@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"flag"
 	"fmt"
 	"net"
@@ -12,6 +11,7 @@ import (
 	"time"
 
 	"github.com/project/ObliSql/api/oramExecutor"
+	accesstrace "github.com/project/ObliSql/pkg/accessTrace"
 	oramexecutor "github.com/project/ObliSql/pkg/oramExecutor"
 
 	"github.com/rs/zerolog/log"
@@ -22,16 +22,35 @@ func main() {
 	_, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	storeType := flag.String("store", "redis", "Bucket storage: redis, memory or file")
+	storeFile := flag.String("sf", "oram-buckets.log", "Log file for the file bucket store")
 	redisHost := flag.String("rh", "127.0.0.1", "Redis Host")
 	redisPort := flag.String("rp", "6379", "Redis Host")
 	addrPort := flag.String("p", "9090", "Executor Port")
+	backend := flag.String("oram", "path", "ORAM construction: path or ring")
+	ringDummies := flag.Int("rs", 12, "Ring ORAM: dummy slots per bucket")
+	ringEvictRate := flag.Int("ra", 8, "Ring ORAM: accesses between evictions")
+	integrity := flag.Bool("integrity", false, "Verify every path read against a Merkle tree over the buckets (path backend only)")
+	blockSize := flag.Int("bs", oramexecutor.AutoBlockSize, "Bytes every stored block is padded to, must fit the largest key and value plus 10 bytes. -1 fits the largest block of the tracefile or checkpoint, 0 disables padding")
 	logCap := flag.Int("l", 22, "Logarithm base 2 of capacity")
 	zVal := flag.Int("z", 5, "Number of blocks per bucket")
 	stashSize := flag.Int("s", 8000000, "Maximum number of blocks in Stash")
+	positionCutoff := flag.Int("pc", 0, "Largest position map kept in memory, larger ones are stored recursively in smaller ORAM trees. 0 keeps it in memory")
 	snapLocation := flag.String("sl", "", "Location for snapshot.json")
 	traceLocation := flag.String("tl", "../../tracefiles/serverInputTEST.txt", "Location to tracefile for initializing DB")
 	useSnapshot := flag.Bool("snapshot", false, "Use database snapshot") // use flag like -snapshot
 	batchSize := flag.Int("br", 10, "Batch size for ORAM")
+	checkpointDir := flag.String("cd", "", "Directory for encrypted position map and stash checkpoints. Recovers from it on start")
+	checkpointInterval := flag.Int("ci", 300, "Seconds between checkpoints, 0 only checkpoints on shutdown")
+	batchTimeout := flag.Int("bt", 5, "Time in milliseconds before a partial batch is padded and executed")
+	pipelineDepth := flag.Int("pd", 1, "Store writes in flight while the next batches run, 0 writes back every batch before starting the next")
+	keyFile := flag.String("kf", "", "File with the encryption keys, one \"<version>:<hex key>\" per line")
+	keyEnv := flag.String("ke", "", "Environment variable with the encryption keys, in the format of -kf")
+	kmsDir := flag.String("kms", "", "Local key directory holding one <version>.key file per key version")
+	rotateKey := flag.Bool("rotatekey", false, "Generate a new key version in the -kms directory before starting")
+	devKey := flag.Bool("devkey", false, "Allow the built-in development key, which every deployment shares")
+	accessTracePtr := flag.String("at", "", "File to log every batch and the bucket store accesses it makes to as a JSONL access trace for accessAnalyzer")
+	executorID := flag.Int("ei", 0, "Number of this executor in the access trace")
 
 	flag.Parse()
 
@@ -49,18 +68,73 @@ func main() {
 	// Create a new gRPC server
 	grpcServer := grpc.NewServer()
 
-	// Use a standard key for encryption
-	key_input := "oblisqloram"
-	// Generate the SHA-256 hash of the input string
-	hash := sha256.New()
-	hash.Write([]byte(key_input))
-
-	// Return the 256-bit (32-byte) hash as a byte slice
-	key := hash.Sum(nil)
+	if *rotateKey {
+		if *kmsDir == "" {
+			log.Fatal().Msgf("-rotatekey needs a -kms directory")
+		}
+		version, err := oramexecutor.LocalKMS{Dir: *kmsDir}.Rotate()
+		if err != nil {
+			log.Fatal().Msgf("Failed to rotate key: %v", err)
+		}
+		log.Info().Msgf("Created key version %d", version)
+	}
+	keys, err := oramexecutor.LoadKeyRing(*keyFile, *keyEnv, *kmsDir, *devKey)
+	if err != nil {
+		log.Fatal().Msgf("Failed to load encryption keys: %v", err)
+	}
+	log.Info().Msgf("Encrypting with key version %d", keys.CurrentVersion())
+
+	var store oramexecutor.BucketStore
+	switch *storeType {
+	case "redis":
+		store, err = oramexecutor.NewRedisStore(redisAddress)
+	case "memory":
+		store = oramexecutor.NewMemoryStore()
+	case "file":
+		store, err = oramexecutor.NewFileStore(*storeFile)
+	default:
+		err = fmt.Errorf("unknown store %q", *storeType)
+	}
+	if err != nil {
+		log.Fatal().Msgf("Failed to open bucket store! %s \n", err)
+	}
+	defer store.Close()
 
-	// Initialize the executor service with Redis connection and tracingProvider
+	var accessTrace *accesstrace.Writer
+	if *accessTracePtr != "" {
+		accessTrace, err = accesstrace.Create(*accessTracePtr)
+		if err != nil {
+			log.Fatal().Msgf("Failed to create access trace: %v", err)
+		}
+		defer accessTrace.Close()
+		log.Info().Msgf("Logging ORAM batches to %s", *accessTracePtr)
+	}
 
-	executor, err := oramexecutor.NewORAM(*logCap, *zVal, *stashSize, redisAddress, *traceLocation, *snapLocation, *useSnapshot, *batchSize, key)
+	// Initialize the executor service with the bucket store and tracingProvider
+
+	executor, err := oramexecutor.NewORAM(oramexecutor.Config{
+		BackendConfig: oramexecutor.BackendConfig{
+			Backend:        *backend,
+			LogCapacity:    *logCap,
+			Z:              *zVal,
+			StashSize:      *stashSize,
+			PositionCutoff: *positionCutoff,
+			RingDummies:    *ringDummies,
+			RingEvictRate:  *ringEvictRate,
+		},
+		BlockSize:          *blockSize,
+		Integrity:          *integrity,
+		Tracefile:          *traceLocation,
+		SnapLocation:       *snapLocation,
+		UseSnapshot:        *useSnapshot,
+		BatchSize:          *batchSize,
+		BatchTimeout:       time.Duration(*batchTimeout) * time.Millisecond,
+		PipelineDepth:      *pipelineDepth,
+		CheckpointDir:      *checkpointDir,
+		CheckpointInterval: time.Duration(*checkpointInterval) * time.Second,
+		AccessTrace:        accessTrace,
+		TraceExecutor:      *executorID,
+	}, store, keys)
 
 	if err != nil {
 		log.Fatal().Msgf("Failed to initialize ORAM! %s \n", err)
@@ -70,7 +144,9 @@ func main() {
 	oramExecutor.RegisterExecutorServer(grpcServer, executor)
 
 	// Handle graceful shutdown
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		// Wait for an interrupt signal or timeout
 
 		sigCh := make(chan os.Signal, 1)
@@ -86,8 +162,20 @@ func main() {
 			fmt.Println("Timeout reached. Shutting down server...")
 		}
 
+		stats := executor.StashStats()
+		fmt.Printf("Stash Occupancy: %d, Peak: %d, Limit: %d\n", stats.Occupancy, stats.Peak, stats.Limit)
+		fmt.Printf("Stash Eviction Rounds: %d, Overflows: %d\n", stats.EvictionRounds, stats.Overflows)
+		io := executor.IOStats()
+		fmt.Printf("Redis Reads: %d values, %d bytes; Writes: %d values, %d bytes\n", io.ValuesRead, io.BytesRead, io.ValuesWritten, io.BytesWritten)
+		fmt.Printf("Values Read With Older Key Versions: %d\n", io.StaleKeyReads)
+
 		// Gracefully stop the gRPC server
 		grpcServer.GracefulStop()
+
+		// No batches are in flight anymore, write the final snapshot
+		if err := executor.Checkpoint(); err != nil {
+			fmt.Printf("Failed to write shutdown checkpoint: %v\n", err)
+		}
 		cancel()
 	}()
 
@@ -95,4 +183,6 @@ func main() {
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatal().Msgf("Failed to serve gRPC: %v", err)
 	}
+	// Serve returns as soon as GracefulStop is called, wait for the shutdown checkpoint
+	<-shutdownDone
 }
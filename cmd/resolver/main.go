@@ -15,6 +15,7 @@ import (
 	"google.golang.org/grpc"
 
 	resolverAPI "github.com/project/ObliSql/api/resolver"
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
 	"github.com/project/ObliSql/pkg/resolver"
 	"github.com/project/ObliSql/pkg/tracing"
 	"go.opentelemetry.io/otel"
@@ -31,6 +32,9 @@ func main() {
 	bloomBool := flag.Bool("bf", false, "Use Bloom Filter for ranges")
 	optiBoolJoin := flag.Bool("jo", false, "Optimized Bloom Filter")
 	bdbSelect := flag.Bool("bdb", false, "Run BigDataBench Metadata")
+	volumePadPtr := flag.String("vh", "none", "Volume hiding mode for index and row fetches (none, pow2, bound)")
+	volumeBoundPtr := flag.Int("vb", 0, "Padding bound used when volume hiding mode is bound")
+	queryTracePtr := flag.String("qt", "", "File to log every incoming query to as a JSONL trace for benchmark replay")
 
 	flag.Parse()
 
@@ -79,7 +83,16 @@ func main() {
 	bHostList := strings.Split(*bHostPtr, ",")
 	pHostList := strings.Split(*bPortPtr, ",")
 
-	resolverService := resolver.NewResolver(ctx, bHostList, pHostList, traceLoc, metaDataLoc, joinMapLoc, tracer, *bloomBool, *optiBoolJoin)
+	resolverService := resolver.NewResolver(ctx, bHostList, pHostList, traceLoc, metaDataLoc, joinMapLoc, tracer, *bloomBool, *optiBoolJoin, *volumePadPtr, *volumeBoundPtr)
+	if *queryTracePtr != "" {
+		queryTrace, err := querytrace.Create(*queryTracePtr)
+		if err != nil {
+			log.Fatal().Msgf("Failed to create query trace: %v", err)
+		}
+		defer queryTrace.Close()
+		resolverService.QueryTrace = queryTrace
+		log.Info().Msgf("Logging queries to %s", *queryTracePtr)
+	}
 	resolverAPI.RegisterResolverServer(grpcServer, resolverService)
 
 	// Handle graceful shutdown
@@ -100,6 +113,9 @@ func main() {
 			fmt.Println("Total Range Index Keys Created:", resolverService.Created.Load())
 			fmt.Println("Total Range Index Keys Inserted after bloom:", resolverService.Inserted.Load())
 			fmt.Println("Total Keys fetched after filtering:", resolverService.SelectFetchKeys.Load())
+			fmt.Println("Total Padding Keys added for volume hiding:", resolverService.PaddingKeys.Load())
+			fmt.Println("Total Requests padded for volume hiding:", resolverService.PaddedRequests.Load())
+			fmt.Println("Total Batcher requests retried on full queues:", resolverService.BatcherRetries.Load())
 			fmt.Printf("Received signal: %v. Shutting down server...\n", sig)
 			grpcServer.GracefulStop()
 			cancel()
@@ -111,6 +127,9 @@ func main() {
 			fmt.Println("Total Range Index Keys Created:", resolverService.Created.Load())
 			fmt.Println("Total Range Index Keys Inserted after bloom:", resolverService.Inserted.Load())
 			fmt.Println("Total Keys fetched after filtering:", resolverService.SelectFetchKeys.Load())
+			fmt.Println("Total Padding Keys added for volume hiding:", resolverService.PaddingKeys.Load())
+			fmt.Println("Total Requests padded for volume hiding:", resolverService.PaddedRequests.Load())
+			fmt.Println("Total Batcher requests retried on full queues:", resolverService.BatcherRetries.Load())
 			fmt.Println("Timeout reached. Shutting down server...")
 			grpcServer.GracefulStop()
 			cancel()
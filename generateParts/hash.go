@@ -9,6 +9,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/project/ObliSql/pkg/partition"
+	"github.com/project/ObliSql/pkg/resolver"
 	"github.com/rs/zerolog/log"
 )
 
@@ -26,6 +28,7 @@ type Config struct {
 	Tables             []TableConfig `json:"tables"`
 	TotalPartitions    uint32        `json:"total_partitions"`
 	DefaultPartitioning string       `json:"default_partitioning"`
+	ring               *partition.Ring // Places keys with partition.StrategyKey
 }
 
 func hashString(s string, N uint32) uint32 {
@@ -47,11 +50,16 @@ func loadConfig(configPath string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.DefaultPartitioning == partition.StrategyKey {
+		config.ring = partition.NewRing(config.TotalPartitions)
+	}
 
 	return &config, nil
 }
 
-func assignTableToFile(tableName string, config *Config) uint32 {
+// assignKeyToFile places key the same way the batcher routes it.
+func assignKeyToFile(key string, config *Config) uint32 {
+	tableName := extractTableName(key)
 	// First check if table is explicitly configured
 	for _, table := range config.Tables {
 		if table.Name == tableName {
@@ -60,7 +68,10 @@ func assignTableToFile(tableName string, config *Config) uint32 {
 	}
 
 	// For unknown tables, use the default partitioning strategy
-	if config.DefaultPartitioning == "hash" {
+	if config.DefaultPartitioning == partition.StrategyKey {
+		return config.ring.Locate(key)
+	}
+	if config.DefaultPartitioning == partition.StrategyHash {
 		h := fnv.New32a()
 		h.Write([]byte(tableName))
 		return h.Sum32() % config.TotalPartitions
@@ -113,6 +124,21 @@ func readTrace(filePath string) []KVpair {
 	return data
 }
 
+// readMetaData loads the resolver metadata so values can be padded to their declared column sizes.
+func readMetaData(filePath string) map[string]resolver.MetaData {
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Fatal().Msgf("Error opening metadata file: %s", err)
+	}
+	defer file.Close()
+
+	var data map[string]resolver.MetaData
+	if err := json.NewDecoder(file).Decode(&data); err != nil {
+		log.Fatal().Msgf("Error decoding metadata file: %s", err)
+	}
+	return data
+}
+
 func writeToFile(filename string, kv KVpair) {
 	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -131,6 +157,7 @@ func writeToFile(filename string, kv KVpair) {
 func main() {
 	traceLoc := flag.String("t", "./serverInput.txt", "Trace file location")
 	configLoc := flag.String("c", "./table_config.json", "Table configuration file")
+	metaLoc := flag.String("m", "", "Metadata file with colSizes. Values are padded to the declared size when set")
 	flag.Parse()
 
 	// Load configuration
@@ -141,6 +168,18 @@ func main() {
 
 	data := readTrace(*traceLoc)
 	fmt.Println("Read: ", len(data))
+
+	if *metaLoc != "" {
+		metaData := readMetaData(*metaLoc)
+		for i, kv := range data {
+			padded, err := resolver.PadValue(kv.Value, resolver.ColumnSize(metaData, kv.Key))
+			if err != nil {
+				log.Fatal().Msgf("Cannot pad %s: %s", kv.Key, err)
+			}
+			data[i].Value = padded
+		}
+		fmt.Println("Padded values to declared column sizes")
+	}
 	N := config.TotalPartitions
 
 	// Create Output directory if it doesn't exist
@@ -152,30 +191,34 @@ func main() {
 	// Create file writers for each bucket
 	fileWriters := make(map[uint32]string)
 	for i := uint32(0); i < N; i++ {
-		fileWriters[i] = outputDir + "/serverInput_" + string(rune('0'+i)) + ".txt"
+		fileWriters[i] = fmt.Sprintf("%s/serverInput_%d.txt", outputDir, i)
 	}
 
-	// Track which tables go to which files for debugging
-	tableToFile := make(map[string]uint32)
+	// Track how many keys of each table go to each file for debugging
+	tableToFiles := make(map[string]map[uint32]int)
 
-	// Distribute data into files based on table name
+	// Distribute data into files based on table name, or on the whole key with "key" partitioning
 	for _, kv := range data {
 		tableName := extractTableName(kv.Key)
-		hashValue := assignTableToFile(tableName, config)
+		hashValue := assignKeyToFile(kv.Key, config)
 		filename := fileWriters[hashValue]
 
 		// Track table distribution for debugging
-		if _, exists := tableToFile[tableName]; !exists {
-			tableToFile[tableName] = hashValue
-			fmt.Printf("Table '%s' -> File %d (%s)\n", tableName, hashValue, filename)
+		if _, exists := tableToFiles[tableName]; !exists {
+			tableToFiles[tableName] = make(map[uint32]int)
 		}
+		tableToFiles[tableName][hashValue]++
 
 		writeToFile(filename, kv)
 	}
 
 	// Print summary
 	fmt.Printf("\nDistribution summary:\n")
-	for table, fileIdx := range tableToFile {
-		fmt.Printf("Table '%s' -> Output/serverInput_%d.txt\n", table, fileIdx)
+	for table, files := range tableToFiles {
+		for fileIdx := uint32(0); fileIdx < N; fileIdx++ {
+			if count, ok := files[fileIdx]; ok {
+				fmt.Printf("Table '%s' -> Output/serverInput_%d.txt (%d keys)\n", table, fileIdx, count)
+			}
+		}
 	}
 }
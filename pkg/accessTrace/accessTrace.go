@@ -0,0 +1,107 @@
+// Package accesstrace records the batches an executor receives and the storage accesses it
+// makes for them to a JSONL trace, one batch per line, and analyzes what that traffic leaks
+// about the workload.
+package accesstrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FakeKey is the key of the fake requests the batcher pads its batches with.
+const FakeKey = "Fake"
+
+// Batch is one line of a trace: a batch as an executor executed it.
+type Batch struct {
+	Time     time.Time     `json:"time"` // When the batch started
+	Executor int           `json:"executor"`
+	Worker   int           `json:"worker"`
+	ID       int64         `json:"id"`
+	Keys     []string      `json:"keys"` // KeyID of every key in order, "" for fake requests and padding
+	Fake     int           `json:"fake"`
+	Duration time.Duration `json:"duration"`         // Until the batch was executed
+	Reads    []string      `json:"reads,omitempty"`  // Storage keys read, in order
+	Writes   []string      `json:"writes,omitempty"` // Storage keys written, in order
+}
+
+// Real returns the number of real requests in b.
+func (b *Batch) Real() int {
+	return len(b.Keys) - b.Fake
+}
+
+// KeyID returns the identifier a key is recorded under. Keys are hashed so traces can be
+// shared without the index values they contain, while repeated accesses stay recognizable.
+func KeyID(key string) string {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// Writer appends batches to a trace file. It is safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// Create creates or truncates the trace file at path.
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating access trace: %w", err)
+	}
+	buf := bufio.NewWriter(file)
+	return &Writer{file: file, buf: buf, encoder: json.NewEncoder(buf)}, nil
+}
+
+// Record appends b.
+func (w *Writer) Record(b *Batch) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.encoder.Encode(b)
+}
+
+// Close flushes the buffered batches and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Read returns the batches of the trace at path in file order. Blank lines are skipped.
+func Read(path string) ([]Batch, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening access trace: %w", err)
+	}
+	defer file.Close()
+
+	var batches []Batch
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024) // A batch holds R keys or more
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var b Batch
+		if err := json.Unmarshal(scanner.Bytes(), &b); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		batches = append(batches, b)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading access trace: %w", err)
+	}
+	return batches, nil
+}
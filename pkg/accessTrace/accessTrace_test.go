@@ -0,0 +1,121 @@
+package accesstrace
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
+)
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.jsonl")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		w.Record(&Batch{Time: time.Now(), ID: int64(i), Keys: []string{KeyID("a"), ""}, Fake: 1})
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	batches, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(batches) != 10 {
+		t.Fatalf("read %d batches, want 10", len(batches))
+	}
+	for i, b := range batches {
+		if b.ID != int64(i) || b.Real() != 1 || b.Keys[0] != KeyID("a") {
+			t.Fatalf("batch = %+v", b)
+		}
+	}
+
+	os.WriteFile(path, []byte(`{"time": `+"\n"), 0644)
+	if _, err := Read(path); err == nil {
+		t.Error("Read() of a malformed trace did not fail")
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestAnalyze(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	batch := func(offset time.Duration, executor int, keys ...string) Batch {
+		b := Batch{Time: start.Add(offset), Executor: executor}
+		for _, key := range keys {
+			if key == "" {
+				b.Fake++
+				b.Keys = append(b.Keys, "")
+			} else {
+				b.Keys = append(b.Keys, KeyID(key))
+			}
+		}
+		return b
+	}
+	// Selects are followed by one key of traffic and joins by four
+	batches := []Batch{
+		batch(0, 0, "a"),
+		batch(time.Second, 0, "a", "a", "b", ""),
+		batch(2*time.Second, 1, "a"),
+		batch(3*time.Second, 1, "c", "a", "", ""),
+	}
+	query := func(offset time.Duration, queryType string) querytrace.Entry {
+		return querytrace.Entry{Time: start.Add(offset), Query: &resolver.ParsedQuery{QueryType: queryType}}
+	}
+	queries := []querytrace.Entry{query(0, "select"), query(time.Second, "join"), query(2*time.Second, "select"), query(3*time.Second, "join")}
+
+	a := Analyze(batches, queries, 500*time.Millisecond)
+	if a.Batches != 4 || a.BatchSize.Mean != 2.5 || a.BatchSize.Variance != 2.25 || a.BatchSize.Min != 1 || a.BatchSize.Max != 4 {
+		t.Errorf("batch sizes = %+v", a.BatchSize)
+	}
+	if s := a.BatchSizeByExec[1]; s.Count != 2 || s.Mean != 2.5 {
+		t.Errorf("batch sizes of executor 1 = %+v", s)
+	}
+	if a.IntervalMs.Count != 2 || a.IntervalMs.Mean != 1000 {
+		t.Errorf("intervals = %+v", a.IntervalMs)
+	}
+	if a.Real != 7 || a.Fake != 3 || !near(a.FakeToReal, 3.0/7) {
+		t.Errorf("real %d, fake %d, ratio %f", a.Real, a.Fake, a.FakeToReal)
+	}
+	// a is accessed 5 times, b and c once
+	if s := a.KeySkew; s.Accesses != 7 || s.DistinctKeys != 3 || !near(s.MaxToMean, 15.0/7) || !near(s.TopKeyShare, 5.0/7) {
+		t.Errorf("key skew = %+v", s)
+	}
+	if a.Storage != nil {
+		t.Errorf("storage analyzed without recorded accesses: %+v", a.Storage)
+	}
+	if l := a.Leakage; l.Queries != 4 || !near(l.QueryTypeEntropy, 1) || !near(l.MutualInformation, 1) {
+		t.Errorf("traffic that tells the query type apart leaks %+v", l)
+	}
+
+	// Padded to a constant size, the traffic reveals nothing
+	for i := range batches {
+		for len(batches[i].Keys) < 4 {
+			batches[i].Keys = append(batches[i].Keys, "")
+			batches[i].Fake++
+		}
+	}
+	if l := Analyze(batches, queries, 500*time.Millisecond).Leakage; !near(l.MutualInformation, 0) {
+		t.Errorf("padded traffic leaks %f bits", l.MutualInformation)
+	}
+	if a := Analyze(batches, nil, time.Second); a.Leakage != nil {
+		t.Error("leakage estimated without a query trace")
+	}
+
+	batches[0].Reads = []string{"bucket:1", "bucket:3"}
+	batches[1].Reads = []string{"bucket:1", "bucket:2"}
+	batches[1].Writes = []string{"bucket:1", "bucket:2"}
+	s := Analyze(batches, nil, time.Second).Storage
+	if s == nil || s.Reads.Count != 4 || s.Reads.Mean != 1 || s.Writes.Max != 2 || s.ReadSkew.Accesses != 4 || s.ReadSkew.DistinctKeys != 3 {
+		t.Errorf("storage = %+v", s)
+	}
+}
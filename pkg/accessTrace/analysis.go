@@ -0,0 +1,228 @@
+package accesstrace
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
+)
+
+// Stats summarizes a sample.
+type Stats struct {
+	Count    int     `json:"count"`
+	Mean     float64 `json:"mean"`
+	Variance float64 `json:"variance"`
+	Min      float64 `json:"min"`
+	Max      float64 `json:"max"`
+}
+
+func summarize(sample []float64) Stats {
+	s := Stats{Count: len(sample)}
+	if len(sample) == 0 {
+		return s
+	}
+	s.Min, s.Max = sample[0], sample[0]
+	for _, x := range sample {
+		s.Mean += x
+		s.Min = math.Min(s.Min, x)
+		s.Max = math.Max(s.Max, x)
+	}
+	s.Mean /= float64(len(sample))
+	for _, x := range sample {
+		s.Variance += (x - s.Mean) * (x - s.Mean)
+	}
+	s.Variance /= float64(len(sample))
+	return s
+}
+
+// KeySkew describes how unevenly accesses spread over keys. A uniform access pattern has
+// MaxToMean 1 and NormalizedEntropy 1.
+type KeySkew struct {
+	Accesses          int     `json:"accesses"`
+	DistinctKeys      int     `json:"distinctKeys"`
+	MaxToMean         float64 `json:"maxToMean"`         // Accesses of the hottest key over the mean per key
+	TopKeyShare       float64 `json:"topKeyShare"`       // Fraction of accesses to the hottest key
+	NormalizedEntropy float64 `json:"normalizedEntropy"` // Entropy of the key distribution over log2(DistinctKeys)
+}
+
+// keySkew computes the skew of the keys that keys returns for the batches, skipping "".
+func keySkew(batches []Batch, keys func(b *Batch) []string) KeySkew {
+	freq := make(map[string]int)
+	var s KeySkew
+	for i := range batches {
+		for _, key := range keys(&batches[i]) {
+			if key != "" {
+				freq[key]++
+				s.Accesses++
+			}
+		}
+	}
+	s.DistinctKeys = len(freq)
+	if s.Accesses == 0 {
+		return s
+	}
+	hottest := 0
+	entropy := 0.0
+	for _, n := range freq {
+		hottest = max(hottest, n)
+		p := float64(n) / float64(s.Accesses)
+		entropy -= p * math.Log2(p)
+	}
+	s.MaxToMean = float64(hottest) * float64(s.DistinctKeys) / float64(s.Accesses)
+	s.TopKeyShare = float64(hottest) / float64(s.Accesses)
+	s.NormalizedEntropy = 1
+	if s.DistinctKeys > 1 {
+		s.NormalizedEntropy = entropy / math.Log2(float64(s.DistinctKeys))
+	}
+	return s
+}
+
+// Leakage is the mutual information between the type of a query and the traffic the
+// executors observe right after it arrives: the number of keys in the batches sent within
+// the window, in power-of-two buckets. It is a plug-in estimate, biased upwards when there
+// are few queries per type and bucket.
+type Leakage struct {
+	Window            time.Duration  `json:"window"`
+	Queries           int            `json:"queries"`
+	QueryTypes        map[string]int `json:"queryTypes"`
+	QueryTypeEntropy  float64        `json:"queryTypeEntropy"`  // Bits, the most that can leak
+	MutualInformation float64        `json:"mutualInformation"` // Bits
+}
+
+// volumeBucket groups observed key counts by powers of two.
+func volumeBucket(keys int) int {
+	return bits.Len(uint(keys))
+}
+
+func leakage(batches []Batch, queries []querytrace.Entry, window time.Duration) *Leakage {
+	l := &Leakage{Window: window, QueryTypes: make(map[string]int)}
+	times := make([]time.Time, len(batches))
+	for i := range batches {
+		times[i] = batches[i].Time
+	}
+	sent := make([]int, len(batches)+1) // Keys sent before each batch
+	for i := range batches {
+		sent[i+1] = sent[i] + len(batches[i].Keys)
+	}
+	joint := make(map[string]map[int]int)
+	buckets := make(map[int]int)
+	for _, q := range queries {
+		first := sort.Search(len(times), func(i int) bool { return !times[i].Before(q.Time) })
+		end := sort.Search(len(times), func(i int) bool { return !times[i].Before(q.Time.Add(window)) })
+		bucket := volumeBucket(sent[end] - sent[first])
+		queryType := q.Query.QueryType
+		if joint[queryType] == nil {
+			joint[queryType] = make(map[int]int)
+		}
+		joint[queryType][bucket]++
+		buckets[bucket]++
+		l.QueryTypes[queryType]++
+		l.Queries++
+	}
+	if l.Queries == 0 {
+		return l
+	}
+	n := float64(l.Queries)
+	for queryType, count := range l.QueryTypes {
+		p := float64(count) / n
+		l.QueryTypeEntropy -= p * math.Log2(p)
+		for bucket, c := range joint[queryType] {
+			pJoint := float64(c) / n
+			l.MutualInformation += pJoint * math.Log2(pJoint/(p*float64(buckets[bucket])/n))
+		}
+	}
+	// Rounding can leave a tiny negative value when nothing leaks
+	l.MutualInformation = math.Max(l.MutualInformation, 0)
+	return l
+}
+
+// Storage is what the store behind an executor observes: the storage keys of every access,
+// which Path and Ring ORAM spread uniformly over the buckets whatever the requested keys.
+// With pipelined writes a batch's writes may be recorded with a later batch.
+type Storage struct {
+	Reads    Stats   `json:"reads"`  // Storage keys read per batch
+	Writes   Stats   `json:"writes"` // Storage keys written per batch
+	ReadSkew KeySkew `json:"readSkew"`
+}
+
+func storage(batches []Batch) *Storage {
+	var reads, writes []float64
+	for i := range batches {
+		reads = append(reads, float64(len(batches[i].Reads)))
+		writes = append(writes, float64(len(batches[i].Writes)))
+	}
+	return &Storage{
+		Reads:    summarize(reads),
+		Writes:   summarize(writes),
+		ReadSkew: keySkew(batches, func(b *Batch) []string { return b.Reads }),
+	}
+}
+
+// Analysis is what a trace reveals about the workload.
+type Analysis struct {
+	Batches         int           `json:"batches"`
+	Start           time.Time     `json:"start"`
+	End             time.Time     `json:"end"`
+	BatchSize       Stats         `json:"batchSize"`
+	BatchSizeByExec map[int]Stats `json:"batchSizeByExecutor"`
+	IntervalMs      Stats         `json:"intervalMs"` // Between consecutive batches to the same executor
+	DurationMs      Stats         `json:"durationMs"`
+	Real            int           `json:"real"`
+	Fake            int           `json:"fake"`
+	FakeToReal      float64       `json:"fakeToReal"`
+	KeySkew         KeySkew       `json:"keySkew"`           // Over the real requests
+	Storage         *Storage      `json:"storage,omitempty"` // Only when storage accesses were recorded
+	Leakage         *Leakage      `json:"leakage,omitempty"` // Only with a query trace
+}
+
+// Analyze computes the statistics of batches. With queries, the query trace logged by the
+// resolvers during the same run, it also estimates how much the traffic reveals about the
+// query types; the two traces are matched by wall-clock time, so the machines' clocks
+// should be synchronized to well within window.
+func Analyze(batches []Batch, queries []querytrace.Entry, window time.Duration) *Analysis {
+	batches = append([]Batch(nil), batches...)
+	sort.SliceStable(batches, func(i, j int) bool { return batches[i].Time.Before(batches[j].Time) })
+
+	a := &Analysis{Batches: len(batches), BatchSizeByExec: make(map[int]Stats)}
+	var sizes, intervals, durations []float64
+	sizesByExec := make(map[int][]float64)
+	last := make(map[int]time.Time)
+	for i := range batches {
+		b := &batches[i]
+		size := float64(len(b.Keys))
+		sizes = append(sizes, size)
+		sizesByExec[b.Executor] = append(sizesByExec[b.Executor], size)
+		if prev, ok := last[b.Executor]; ok {
+			intervals = append(intervals, float64(b.Time.Sub(prev))/float64(time.Millisecond))
+		}
+		last[b.Executor] = b.Time
+		durations = append(durations, float64(b.Duration)/float64(time.Millisecond))
+		a.Real += b.Real()
+		a.Fake += b.Fake
+	}
+	if len(batches) > 0 {
+		a.Start, a.End = batches[0].Time, batches[len(batches)-1].Time
+	}
+	a.BatchSize = summarize(sizes)
+	for executor, sample := range sizesByExec {
+		a.BatchSizeByExec[executor] = summarize(sample)
+	}
+	a.IntervalMs = summarize(intervals)
+	a.DurationMs = summarize(durations)
+	if a.Real > 0 {
+		a.FakeToReal = float64(a.Fake) / float64(a.Real)
+	}
+	a.KeySkew = keySkew(batches, func(b *Batch) []string { return b.Keys })
+	for i := range batches {
+		if len(batches[i].Reads) > 0 || len(batches[i].Writes) > 0 {
+			a.Storage = storage(batches)
+			break
+		}
+	}
+	if queries != nil {
+		a.Leakage = leakage(batches, queries, window)
+	}
+	return a
+}
@@ -0,0 +1,133 @@
+package batcher
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveRConfig configures the controller that picks the batch size R at runtime.
+type AdaptiveRConfig struct {
+	Sizes         []int         // Allowed batch sizes, R only ever takes one of them
+	LatencyTarget time.Duration // Mean time a request may spend in the batcher, 0 to only watch the queues
+	Interval      time.Duration // Time between decisions
+}
+
+// ParseBatchSizes parses allowed batch sizes given as "200,400,800" and returns them sorted.
+func ParseBatchSizes(text string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(text, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("batch size %q is not a positive integer", field)
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		return nil, fmt.Errorf("no batch sizes in %q", text)
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+// RChange is one entry of the batch size schedule: R was set to Size at Time, after the
+// controller observed Depth keys in the fullest executor queue and a mean latency of LatencyMs.
+type RChange struct {
+	Time      time.Time `json:"time"`
+	Size      int       `json:"size"`
+	Depth     int       `json:"depth"`
+	LatencyMs float64   `json:"latencyMs"`
+}
+
+// rController steps R up and down the allowed sizes. R is raised when the queues hold more
+// than a batch or the latency misses the target, and lowered when the queues would fill
+// less than half of the next smaller size and the latency is well within the target. The
+// gap between the two keeps R from flapping.
+type rController struct {
+	sizes         []int
+	level         int
+	latencyTarget time.Duration
+	interval      time.Duration
+	lastDecision  time.Time
+
+	latencySum   atomic.Int64 // Nanoseconds, since the last decision
+	latencyCount atomic.Int64
+
+	mu       sync.Mutex
+	schedule []RChange
+}
+
+func newRController(config *AdaptiveRConfig, R int, now time.Time) *rController {
+	c := &rController{
+		sizes:         config.Sizes,
+		latencyTarget: config.LatencyTarget,
+		interval:      config.Interval,
+		lastDecision:  now,
+	}
+	// Start from the largest allowed size not above R
+	for c.level+1 < len(c.sizes) && c.sizes[c.level+1] <= R {
+		c.level++
+	}
+	c.schedule = []RChange{{Time: now, Size: c.size()}}
+	return c
+}
+
+func (c *rController) size() int {
+	return c.sizes[c.level]
+}
+
+// observe records the time a request spent in the batcher.
+func (c *rController) observe(latency time.Duration) {
+	c.latencySum.Add(int64(latency))
+	c.latencyCount.Add(1)
+}
+
+// decide returns the batch size to use from now on, given the depth of the fullest executor
+// queue. It only reconsiders R once per interval.
+func (c *rController) decide(depth int, now time.Time) int {
+	if now.Sub(c.lastDecision) < c.interval {
+		return c.size()
+	}
+	c.lastDecision = now
+	count := c.latencyCount.Swap(0)
+	sum := c.latencySum.Swap(0)
+	var latency time.Duration
+	if count > 0 {
+		latency = time.Duration(sum / count)
+	}
+	overTarget := c.latencyTarget > 0 && latency > c.latencyTarget
+	withinTarget := c.latencyTarget == 0 || latency < c.latencyTarget/2
+
+	level := c.level
+	if (depth > c.size() || overTarget) && level+1 < len(c.sizes) {
+		level++
+	} else if level > 0 && depth < c.sizes[level-1]/2 && withinTarget {
+		level--
+	}
+	if level != c.level {
+		c.level = level
+		c.mu.Lock()
+		c.schedule = append(c.schedule, RChange{Time: now, Size: c.size(), Depth: depth, LatencyMs: float64(latency) / float64(time.Millisecond)})
+		c.mu.Unlock()
+	}
+	return c.size()
+}
+
+// RSchedule returns every batch size the batcher used, starting with the initial one.
+// With a fixed R it is empty.
+func (lb *myBatcher) RSchedule() []RChange {
+	if lb.rController == nil {
+		return nil
+	}
+	lb.rController.mu.Lock()
+	defer lb.rController.mu.Unlock()
+	return append([]RChange(nil), lb.rController.schedule...)
+}
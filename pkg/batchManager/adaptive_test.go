@@ -0,0 +1,111 @@
+package batcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestParseBatchSizes(t *testing.T) {
+	sizes, err := ParseBatchSizes("800, 200,400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sizes, []int{200, 400, 800}) {
+		t.Errorf("sizes = %v", sizes)
+	}
+	for _, text := range []string{"", "200,x", "0"} {
+		if _, err := ParseBatchSizes(text); err == nil {
+			t.Errorf("ParseBatchSizes(%q) did not fail", text)
+		}
+	}
+}
+
+func TestRController(t *testing.T) {
+	start := time.Now()
+	c := newRController(&AdaptiveRConfig{Sizes: []int{100, 200, 400}, LatencyTarget: 100 * time.Millisecond, Interval: time.Second}, 250, start)
+	if c.size() != 200 {
+		t.Fatalf("initial size = %d, want 200", c.size())
+	}
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	// Decisions wait for the interval
+	if R := c.decide(1000, start.Add(time.Millisecond)); R != 200 {
+		t.Errorf("R changed to %d before the interval", R)
+	}
+	// A backlog raises R, up to the largest size
+	if R := c.decide(1000, at(1)); R != 400 {
+		t.Errorf("R = %d with a backlog, want 400", R)
+	}
+	if R := c.decide(1000, at(2)); R != 400 {
+		t.Errorf("R = %d beyond the largest size", R)
+	}
+	// Short queues lower R only while the latency is well within the target
+	c.observe(80 * time.Millisecond)
+	if R := c.decide(10, at(3)); R != 400 {
+		t.Errorf("R = %d with latency close to the target, want 400", R)
+	}
+	c.observe(10 * time.Millisecond)
+	if R := c.decide(10, at(4)); R != 200 {
+		t.Errorf("R = %d with short queues, want 200", R)
+	}
+	// Between the thresholds R stays
+	if R := c.decide(150, at(5)); R != 200 {
+		t.Errorf("R = %d between the thresholds, want 200", R)
+	}
+	// Missing the latency target raises R even with short queues
+	c.observe(300 * time.Millisecond)
+	if R := c.decide(10, at(6)); R != 400 {
+		t.Errorf("R = %d over the latency target, want 400", R)
+	}
+
+	var sizes []int
+	for _, change := range c.schedule {
+		sizes = append(sizes, change.Size)
+	}
+	if !reflect.DeepEqual(sizes, []int{200, 400, 200, 400}) {
+		t.Errorf("schedule = %v", c.schedule)
+	}
+	if last := c.schedule[len(c.schedule)-1]; last.Depth != 10 || last.LatencyMs != 300 || !last.Time.Equal(at(6)) {
+		t.Errorf("last change = %+v", last)
+	}
+}
+
+func TestAdaptRTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	start := time.Now().Add(-2 * time.Hour)
+	lb := &myBatcher{
+		R:                100,
+		executorNumber:   1,
+		executorChannels: map[int]chan *KVPair{0: make(chan *KVPair, 300)},
+		rController:      newRController(&AdaptiveRConfig{Sizes: []int{100, 200}, Interval: time.Hour}, 100, start),
+		tracer:           provider.Tracer(""),
+	}
+	lb.adaptR()
+	for i := 0; i < 300; i++ {
+		lb.executorChannels[0] <- &KVPair{}
+	}
+	lb.rController.lastDecision = start
+	lb.adaptR()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans for 2 rounds", len(spans))
+	}
+	for i, want := range []struct {
+		r, depth int64
+		events   int
+	}{{100, 0, 0}, {200, 300, 1}} {
+		attrs := make(map[string]int64)
+		for _, attr := range spans[i].Attributes() {
+			attrs[string(attr.Key)] = attr.Value.AsInt64()
+		}
+		if attrs["r"] != want.r || attrs["queue_depth"] != want.depth || len(spans[i].Events()) != want.events {
+			t.Errorf("round %d traced %v with %d events, want R %d, depth %d and %d events", i, attrs, len(spans[i].Events()), want.r, want.depth, want.events)
+		}
+	}
+}
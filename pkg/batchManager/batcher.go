@@ -14,6 +14,8 @@ import (
 	"time"
 
 	loadBalancer "github.com/project/ObliSql/api/loadbalancer"
+	accesstrace "github.com/project/ObliSql/pkg/accessTrace"
+	"github.com/project/ObliSql/pkg/partition"
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -25,6 +27,7 @@ type KVPair struct {
 	Value      string
 	sortingKey int
 	RequestID  int
+	duplicates []*KVPair // Identical GETs coalesced into this one, they get its result
 }
 
 type Batch struct {
@@ -51,6 +54,7 @@ type Config struct {
 	Tables             []TableConfig `json:"tables"`
 	TotalPartitions    uint32        `json:"total_partitions"`
 	DefaultPartitioning string       `json:"default_partitioning"`
+	ring               *partition.Ring // Places keys with partition.StrategyKey
 }
 
 type myBatcher struct {
@@ -59,11 +63,21 @@ type myBatcher struct {
 	executorNumber    int
 	waitTime          int
 	executorType      string
+	executorOptions   map[string]string // Backend specific options for the executor clients
 	executorNum       int
 	executors         map[int][]ExecutorClient
 	numClients        int
 	tracer            trace.Tracer
 	fakeRequestsOff   bool
+	dedupOff          bool // Send repeated GETs of a key in a batch to the executor unmerged
+	rController       *rController        // Adapts R to the load when set
+	constantRate      bool                // Dispatch one batch per executor every tick
+	queueBound        int                 // Keys an executor queue may hold in constant-rate mode
+	admitLock         sync.Mutex          // Makes admitting a request to the bounded queues atomic
+	chunkLock         sync.Mutex          // Admits one request larger than the queue bound at a time
+	chunking          bool                // A request larger than the bound is being admitted, guarded by admitLock
+	roomFreed         chan struct{}       // Wakes a chunked admission when the coordinator takes keys
+	keysQueued        chan struct{}       // Wakes the coordinator when keys are queued
 	executorChannels  map[int]chan *KVPair // Per-executor channels
 	channelMap        map[string]responseChannel
 	channelLock       sync.RWMutex
@@ -73,6 +87,8 @@ type myBatcher struct {
 	TotalKeysSeen     atomic.Int64
 	aggBatchIds       atomic.Int64
 	TotalFakeAdded    atomic.Int64
+	TotalCoalesced    atomic.Int64 // GETs answered by an identical GET in the same batch
+	TotalRejected     atomic.Int64 // Requests turned away because an executor queue was full
 	config            *Config
 }
 
@@ -97,6 +113,9 @@ func loadConfig(configPath string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	if config.DefaultPartitioning == partition.StrategyKey {
+		config.ring = partition.NewRing(config.TotalPartitions)
+	}
 
 	return &config, nil
 }
@@ -112,7 +131,11 @@ func assignTableToExecutor(key string, config *Config) uint32 {
 	}
 
 	// For unknown tables, use the default partitioning strategy
-	if config.DefaultPartitioning == "hash" {
+	if config.DefaultPartitioning == partition.StrategyKey {
+		// Large tables spread over all executors, generateParts splits tracefiles the same way
+		return config.ring.Locate(key)
+	}
+	if config.DefaultPartitioning == partition.StrategyHash {
 		h := fnv.New32a()
 		h.Write([]byte(tableName))
 		return h.Sum32() % config.TotalPartitions
@@ -188,13 +211,13 @@ func (lb *myBatcher) connectToExecutors(ctx context.Context, hosts []string, por
 		}
 		span.AddEvent(fmt.Sprintf("Launching Clients for: %d", i))
 		for j := 0; j < numClient; j++ {
-			client, err := NewExecutorClient(lb.executorType, v, port, lb.tracer)
+			client, err := NewExecutorClient(lb.executorType, v, port, lb.executorOptions, lb.tracer)
 			if err != nil {
 				log.Fatal().Msgf("Couldn't Connect to Executor Proxy %d-%d. Error: %s", i, j, err)
 			}
 
 			lb.executors[i][j] = client
-			go lb.batchWorker(client, i, workerId)
+			go lb.batchWorker(ctx, client, i, workerId)
 			workerId++
 		}
 		span.AddEvent(fmt.Sprintf("Finished Launching Clients for: %d", i))
@@ -229,6 +252,9 @@ func (lb *myBatcher) AddKeys(ctx context.Context, req *loadBalancer.LoadBalanceR
 	span.AddEvent("Added Channel to Global Map")
 
 	span.AddEvent("Adding Keys to Channels")
+	enqueueTime := time.Now()
+	kvs := make([]*KVPair, 0, len(req.Keys))
+	executorIDs := make([]int, 0, len(req.Keys))
 	sent := 0
 	for i, key := range req.Keys {
 		// if !lb.bloomFilter.TestString(key) {
@@ -251,10 +277,24 @@ func (lb *myBatcher) AddKeys(ctx context.Context, req *loadBalancer.LoadBalanceR
 			sortingKey: i,
 			RequestID:  int(req.RequestId),
 		}
-		// Block if the channel is full
 		sent++
-		lb.executorChannels[hashVal] <- kv
-
+		kvs = append(kvs, kv)
+		executorIDs = append(executorIDs, hashVal)
+	}
+	if lb.constantRate {
+		if err := lb.enqueueBounded(kvs, executorIDs); err != nil {
+			lb.channelLock.Lock()
+			delete(lb.channelMap, channelId)
+			lb.channelLock.Unlock()
+			span.RecordError(err)
+			return nil, err
+		}
+	} else {
+		for i, kv := range kvs {
+			// Block if the channel is full
+			lb.executorChannels[executorIDs[i]] <- kv
+		}
+		lb.signalKeysQueued()
 	}
 	span.AddEvent("Finished Adding Keys to Channels")
 	span.SetAttributes(
@@ -267,6 +307,9 @@ func (lb *myBatcher) AddKeys(ctx context.Context, req *loadBalancer.LoadBalanceR
 		recv_resp = append(recv_resp, item)
 	}
 	span.AddEvent("Got all Responses")
+	if lb.rController != nil {
+		lb.rController.observe(time.Since(enqueueTime))
+	}
 
 	close(localRespChannel)
 
@@ -295,89 +338,142 @@ func (lb *myBatcher) AddKeys(ctx context.Context, req *loadBalancer.LoadBalanceR
 	}, nil
 }
 
-func (lb *myBatcher) centralCoordinator() {
+// adaptR lets the controller pick R for the next round when R is adaptive. Every round is
+// traced with the R it uses, and a change of R is added to it as an event, so a run can be
+// plotted while it goes on.
+func (lb *myBatcher) adaptR() {
+	if lb.rController == nil {
+		return
+	}
+	_, span := lb.tracer.Start(context.Background(), "Adapt R")
+	defer span.End()
+	depth := 0
+	for i := 0; i < lb.executorNumber; i++ {
+		depth = max(depth, len(lb.executorChannels[i]))
+	}
+	// Every executor gets batches of the new size from this round on
+	if R := lb.rController.decide(depth, time.Now()); R != lb.R {
+		log.Info().Msgf("Changing R from %d to %d. Queue depth: %d", lb.R, R, depth)
+		span.AddEvent("R changed", trace.WithAttributes(
+			attribute.Int("previous_r", lb.R),
+			attribute.Int("r", R),
+		))
+		lb.R = R
+	}
+	span.SetAttributes(
+		attribute.Int("r", lb.R),
+		attribute.Int("queue_depth", depth),
+	)
+}
+
+// collectBatches takes up to R keys from every executor queue and pads the batches to R with
+// fake requests. allZero reports whether every queue was empty.
+func (lb *myBatcher) collectBatches() (batches map[int][]*KVPair, fakeCount int, allZero bool) {
+	allZero = true
+	batches = make(map[int][]*KVPair)
+	for i := 0; i < lb.executorNumber; i++ {
+		n := len(lb.executorChannels[i])
+		if n != 0 {
+			allZero = false
+		}
+
+		if n > lb.R {
+			n = lb.R //Label this better
+		}
+		batch := make([]*KVPair, 0, n)
+		for j := 0; j < n; j++ {
+			kv := <-lb.executorChannels[i]
+			batch = append(batch, kv)
+		}
+		// Repeated GETs take a single access, the freed slots are padded below
+		if !lb.dedupOff {
+			var merged int
+			batch, merged = coalesceGets(batch)
+			lb.TotalCoalesced.Add(int64(merged))
+		}
+		// Add fake requests to make up to R if n < lb.R
+		if !lb.fakeRequestsOff {
+			// log.Info().Msgf("Adding Fake Requests", len(batch))
+			for len(batch) < lb.R {
+				fakeCount += 1
+				temp := &KVPair{Key: accesstrace.FakeKey, Value: "", channelId: "noChannel"}
+				batch = append(batch, temp)
+			}
+		}
+		batches[i] = batch
+	}
+	return batches, fakeCount, allZero
+}
+
+// dispatchBatches sends the batches of one round to their executors together.
+func (lb *myBatcher) dispatchBatches(batches map[int][]*KVPair, fakeCount int) {
+	for i := 0; i < lb.executorNumber; i++ {
+		batch := batches[i]
+		if len(batch) == 0 {
+			if lb.fakeRequestsOff {
+				log.Info().Msg("Skipping over a batch, Happens when fake requests are turned off")
+				continue
+			} else {
+				log.Fatal().Msg("Should never have an empty batch!")
+			}
+		}
+		lb.TotalKeysSeen.Add(int64(len(batch)))
+		lb.TotalFakeAdded.Add(int64(fakeCount))
+		go func(executorID int, batch []*KVPair) {
+			lb.executeBatch(executorID, batch)
+		}(i, batch)
+	}
+}
+
+// queuesFull reports whether every executor queue holds a full batch.
+func (lb *myBatcher) queuesFull() bool {
+	for i := 0; i < lb.executorNumber; i++ {
+		if len(lb.executorChannels[i]) < lb.R {
+			return false
+		}
+	}
+	return true
+}
+
+// signalKeysQueued wakes the coordinator to check the queues. It never blocks: a pending
+// signal already makes the coordinator look at the queues again.
+func (lb *myBatcher) signalKeysQueued() {
+	select {
+	case lb.keysQueued <- struct{}{}:
+	default:
+	}
+}
+
+func (lb *myBatcher) centralCoordinator(ctx context.Context) {
 	log.Info().Msgf("Launching Central Coordinator with timeOut: %d", lb.waitTime)
 	waitDuration := time.Duration(lb.waitTime) * time.Millisecond
 	timer := time.NewTicker(waitDuration)
 	defer timer.Stop()
 
 	for {
-		ready := false
-
+		lb.adaptR()
+		// Sleep until every queue holds R keys or the timer fires, AddKeys wakes us up
+		// whenever it queues keys
+		ready := lb.queuesFull()
 		for !ready {
-			ready = true
-			for i := 0; i < lb.executorNumber; i++ {
-				count := len(lb.executorChannels[i])
-				if count < lb.R {
-					ready = false
-					break
-				}
-			}
-			if ready {
-				break
-			}
-
 			select {
+			case <-ctx.Done():
+				return
 			case <-timer.C:
 				// Time to send whatever we have
 				ready = true
-			default:
-				// Do nothing, just continue the loop
-				// time.Sleep(time.Millisecond * 10)
+			case <-lb.keysQueued:
+				ready = lb.queuesFull()
 			}
 		}
 		timer.Reset(waitDuration)
 		//Do we want all of them to Reach R or do we want to have at-least one has reached R.
 
-		// Collect batches
-		allZero := true
-		batches := make(map[int][]*KVPair)
-		fakeCount := 0
-		for i := 0; i < lb.executorNumber; i++ {
-			n := len(lb.executorChannels[i])
-			if n != 0 {
-				allZero = false
-			}
-
-			if n > lb.R {
-				n = lb.R //Label this better
-			}
-			batch := make([]*KVPair, 0, n)
-			for j := 0; j < n; j++ {
-				kv := <-lb.executorChannels[i]
-				batch = append(batch, kv)
-			}
-			// Add fake requests to make up to R if n < lb.R
-			if !lb.fakeRequestsOff {
-				// log.Info().Msgf("Adding Fake Requests", len(batch))
-				for len(batch) < lb.R {
-					fakeCount += 1
-					temp := &KVPair{Key: "Fake", Value: "", channelId: "noChannel"}
-					batch = append(batch, temp)
-				}
-			}
-			batches[i] = batch
-		}
+		batches, fakeCount, allZero := lb.collectBatches()
 		if allZero {
 			continue //Skip sending fake requests only after timeout
 		}
-		// Dispatch batches together
-		for i := 0; i < lb.executorNumber; i++ {
-			batch := batches[i]
-			if len(batch) == 0 {
-				if lb.fakeRequestsOff {
-					log.Info().Msg("Skipping over a batch, Happens when fake requests are turned off")
-					continue
-				} else {
-					log.Fatal().Msg("Should never have an empty batch!")
-				}
-			}
-			lb.TotalKeysSeen.Add(int64(len(batch)))
-			lb.TotalFakeAdded.Add(int64(fakeCount))
-			go func(executorID int, batch []*KVPair) {
-				lb.executeBatch(executorID, batch)
-			}(i, batch)
-		}
+		lb.dispatchBatches(batches, fakeCount)
 		timer.Reset(waitDuration)
 	}
 }
@@ -439,6 +535,9 @@ func (lb *myBatcher) executeBatch(executorNumber int, batch []*KVPair) {
 		if v.channelId != "noChannel" {
 			channelCache[v.channelId] = lb.channelMap[v.channelId].channel
 		}
+		for _, dup := range v.duplicates {
+			channelCache[dup.channelId] = lb.channelMap[dup.channelId].channel
+		}
 	}
 	lb.channelLock.RUnlock()
 	span.AddEvent("Collected Channel Ids, Unlocked")
@@ -457,20 +556,36 @@ func (lb *myBatcher) executeBatch(executorNumber int, batch []*KVPair) {
 		}
 		responseChannel := channelCache[v.channelId]
 		responseChannel <- newKVPair
+		for _, dup := range v.duplicates {
+			channelCache[dup.channelId] <- KVPair{
+				Key:        Keys[i],
+				Value:      Values[i],
+				sortingKey: dup.sortingKey,
+			}
+		}
 	}
 	span.AddEvent("Sent responses to their channels")
 
 }
 
-func (lb *myBatcher) batchWorker(client ExecutorClient, idx int, workerId int) {
+func (lb *myBatcher) batchWorker(ctx context.Context, client ExecutorClient, idx int, workerId int) {
 	log.Info().Msgf("Batch Worker for executor: %d is up. WorkerID: %d ", idx, workerId)
 
 	for {
 		var allBatches []*Batch
 
-		// Drain the channel and collect all the batches
+		// Wait for a batch, then drain the channel and collect the batches queued behind it.
+		// In constant-rate mode every batch is sent alone, so a slow executor still only sees
+		// batches of R.
+		select {
+		case <-ctx.Done():
+			return
+		case batch := <-lb.batchChannel[workerId]:
+			batch.dequeueTime = time.Now()
+			allBatches = append(allBatches, batch)
+		}
 	DrainLoop:
-		for {
+		for !lb.constantRate {
 			select {
 			case batch := <-lb.batchChannel[workerId]:
 				batch.dequeueTime = time.Now()
@@ -481,10 +596,6 @@ func (lb *myBatcher) batchWorker(client ExecutorClient, idx int, workerId int) {
 			}
 		}
 
-		if len(allBatches) == 0 {
-			continue
-		}
-
 		// log.Info().Msgf("Worker %d Drained %d batches from batchChannel[%d]", workerId, len(allBatches), idx)
 
 		// Aggregate keys and values from all collected batches
@@ -544,7 +655,7 @@ func (lb *myBatcher) batchWorker(client ExecutorClient, idx int, workerId int) {
 	}
 }
 
-func NewBatcher(ctx context.Context, R int, executorNumber int, waitTime int, executorType string, executorHosts string, executorPorts string, numClients int, fakeReqPtr bool, tracer trace.Tracer, configPath string) *myBatcher {
+func NewBatcher(ctx context.Context, R int, executorNumber int, waitTime int, executorType string, executorOptions map[string]string, executorHosts string, executorPorts string, numClients int, fakeReqPtr bool, dedupOff bool, adaptiveR *AdaptiveRConfig, constantRate *ConstantRateConfig, tracer trace.Tracer, configPath string) *myBatcher {
 	// Load configuration
 	config, err := loadConfig(configPath)
 	if err != nil {
@@ -563,6 +674,7 @@ func NewBatcher(ctx context.Context, R int, executorNumber int, waitTime int, ex
 		executorNumber:    executorNumber,
 		waitTime:          waitTime,
 		executorType:      executorType,
+		executorOptions:   executorOptions,
 		numClients:        numClients,
 		tracer:            tracer,
 		channelMap:        make(map[string]responseChannel),
@@ -573,8 +685,15 @@ func NewBatcher(ctx context.Context, R int, executorNumber int, waitTime int, ex
 		aggBatchIds:       atomic.Int64{},
 		executorWorkerIds: make(map[int][]int),
 		fakeRequestsOff:   fakeReqPtr,
+		dedupOff:          dedupOff,
 		TotalFakeAdded:    atomic.Int64{},
 		config:            config,
+		keysQueued:        make(chan struct{}, 1),
+	}
+	if adaptiveR != nil {
+		service.rController = newRController(adaptiveR, R, time.Now())
+		service.R = service.rController.size()
+		log.Info().Msgf("Adapting R between sizes %v, starting at %d", adaptiveR.Sizes, service.R)
 	}
 
 	// // Initialize batchChannel before any goroutines start
@@ -582,14 +701,38 @@ func NewBatcher(ctx context.Context, R int, executorNumber int, waitTime int, ex
 	// 	service.batchChannel[i] = make(chan *Batch, 3*R) //Some Factor of R. Experiment and see.
 	// }
 
+	queueCapacity := 1000000
+	if constantRate != nil {
+		if fakeReqPtr {
+			log.Fatal().Msg("Constant-rate dispatch needs fake requests to pad every batch to R")
+		}
+		service.constantRate = true
+		service.queueBound = constantRate.QueueBound
+		if service.queueBound <= 0 {
+			largest := R
+			if adaptiveR != nil {
+				largest = adaptiveR.Sizes[len(adaptiveR.Sizes)-1]
+			}
+			service.queueBound = 8 * largest
+		}
+		// Admission keeps the queues within the bound, so sends never block
+		queueCapacity = service.queueBound
+		service.roomFreed = make(chan struct{}, 1)
+	}
+
 	service.connectToExecutors(ctx, hosts, ports, numClients)
 	log.Info().Msgf("Fake Requests Off?: %t", service.fakeRequestsOff)
+	log.Info().Msgf("GET Deduplication Off?: %t", service.dedupOff)
 
 	log.Info().Msgf("Number of Executors: %d", executorNumber)
 	for i := 0; i < executorNumber; i++ {
-		service.executorChannels[i] = make(chan *KVPair, 1000000)
+		service.executorChannels[i] = make(chan *KVPair, queueCapacity)
+	}
+	if service.constantRate {
+		go service.constantRateCoordinator(ctx)
+	} else {
+		go service.centralCoordinator(ctx)
 	}
-	go service.centralCoordinator()
 
 	return &service
 }
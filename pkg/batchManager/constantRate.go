@@ -0,0 +1,117 @@
+package batcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConstantRateConfig configures constant-rate dispatch: every wait time, each executor gets
+// exactly one batch of R, padded with fake requests, whatever the load. Timing and sizes
+// then reveal nothing, but the throughput is capped at R keys per executor per tick.
+type ConstantRateConfig struct {
+	QueueBound int // Keys an executor queue may hold, 0 for eight of the largest batches
+}
+
+// constantRateCoordinator dispatches a round of batches on every tick.
+func (lb *myBatcher) constantRateCoordinator(ctx context.Context) {
+	log.Info().Msgf("Launching Constant-Rate Coordinator with tick: %d ms, queue bound: %d", lb.waitTime, lb.queueBound)
+	ticker := time.NewTicker(time.Duration(lb.waitTime) * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		lb.adaptR()
+		batches, fakeCount, _ := lb.collectBatches()
+		// Wake a request waiting for room, a pending wakeup already makes it look again
+		select {
+		case lb.roomFreed <- struct{}{}:
+		default:
+		}
+		lb.dispatchBatches(batches, fakeCount)
+	}
+}
+
+// enqueueBounded adds the keys of a request to the queues of their executors. A request that
+// fits under the bound is admitted all at once or not at all: when a queue would exceed its
+// bound, the request is rejected with ResourceExhausted so the resolver backs off and retries.
+// A request with more keys for an executor than the bound never fits, so it is admitted in
+// chunks instead, see enqueueChunked.
+func (lb *myBatcher) enqueueBounded(kvs []*KVPair, executorIDs []int) error {
+	need := make(map[int]int)
+	for _, id := range executorIDs {
+		need[id]++
+	}
+	for _, n := range need {
+		if n > lb.queueBound {
+			lb.enqueueChunked(kvs, executorIDs)
+			return nil
+		}
+	}
+	lb.admitLock.Lock()
+	defer lb.admitLock.Unlock()
+	// A request admitted in chunks gets the room the coordinator frees, or it could starve
+	if lb.chunking {
+		lb.TotalRejected.Add(1)
+		return status.Errorf(codes.ResourceExhausted, "a large request is being admitted")
+	}
+	// Only the coordinator takes keys from the queues meanwhile, so room found here stays
+	for id, n := range need {
+		if len(lb.executorChannels[id])+n > lb.queueBound {
+			lb.TotalRejected.Add(1)
+			return status.Errorf(codes.ResourceExhausted, "queue of executor %d is full", id)
+		}
+	}
+	for i, kv := range kvs {
+		lb.executorChannels[executorIDs[i]] <- kv
+	}
+	return nil
+}
+
+// enqueueChunked admits a request larger than the queue bound, filling every queue up to its
+// bound and waiting for the coordinator to free room until all keys are queued. One such
+// request is admitted at a time, and the others are rejected meanwhile.
+func (lb *myBatcher) enqueueChunked(kvs []*KVPair, executorIDs []int) {
+	lb.chunkLock.Lock()
+	defer lb.chunkLock.Unlock()
+	lb.admitLock.Lock()
+	lb.chunking = true
+	lb.admitLock.Unlock()
+	defer func() {
+		lb.admitLock.Lock()
+		lb.chunking = false
+		lb.admitLock.Unlock()
+	}()
+
+	// Keys keep their request order within every queue
+	pending := make(map[int][]*KVPair)
+	for i, kv := range kvs {
+		pending[executorIDs[i]] = append(pending[executorIDs[i]], kv)
+	}
+	for {
+		lb.admitLock.Lock()
+		for id, keys := range pending {
+			n := min(lb.queueBound-len(lb.executorChannels[id]), len(keys))
+			for _, kv := range keys[:n] {
+				lb.executorChannels[id] <- kv
+			}
+			if n == len(keys) {
+				delete(pending, id)
+			} else {
+				pending[id] = keys[n:]
+			}
+		}
+		lb.admitLock.Unlock()
+		if len(pending) == 0 {
+			return
+		}
+		<-lb.roomFreed
+	}
+}
@@ -0,0 +1,181 @@
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	loadBalancer "github.com/project/ObliSql/api/loadbalancer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// sizeClient answers every key with its name after delay and records the size of every batch.
+type sizeClient struct {
+	mu    sync.Mutex
+	sizes []int
+	delay time.Duration
+}
+
+func (c *sizeClient) MixBatch(keys []string, values []string, batchID int64) ([]string, error) {
+	time.Sleep(c.delay)
+	c.mu.Lock()
+	c.sizes = append(c.sizes, len(keys))
+	c.mu.Unlock()
+	resp := make([]string, len(keys))
+	for i, key := range keys {
+		resp[i] = key + ":value of " + key
+	}
+	return resp, nil
+}
+
+var (
+	constantRateClient = &sizeClient{}
+	// slowClient takes three ticks of TestConstantRateSlowExecutor per batch
+	slowClient = &sizeClient{delay: 60 * time.Millisecond}
+)
+
+func init() {
+	RegisterExecutor("test-constant-rate", func(string, int, map[string]string, trace.Tracer) (ExecutorClient, error) {
+		return constantRateClient, nil
+	})
+	RegisterExecutor("test-constant-rate-slow", func(string, int, map[string]string, trace.Tracer) (ExecutorClient, error) {
+		return slowClient, nil
+	})
+}
+
+func TestConstantRate(t *testing.T) {
+	client := constantRateClient
+	client.mu.Lock()
+	client.sizes = nil
+	client.mu.Unlock()
+	configPath := filepath.Join(t.TempDir(), "table_config.json")
+	os.WriteFile(configPath, []byte(`{"tables": [], "total_partitions": 1, "default_partitioning": "hash"}`), 0600)
+	lb := NewBatcher(context.Background(), 4, 1, 20, "test-constant-rate", nil, "localhost", "1", 1, false, false, nil, &ConstantRateConfig{QueueBound: 8}, otel.Tracer(""), configPath)
+
+	// Batches go out while idle
+	time.Sleep(150 * time.Millisecond)
+	resp, err := lb.AddKeys(context.Background(), &loadBalancer.LoadBalanceRequest{Keys: []string{"a", "b", "a"}, Values: []string{"", "", ""}})
+	if err != nil {
+		t.Fatalf("AddKeys() error = %v", err)
+	}
+	for i, key := range resp.Keys {
+		if resp.Values[i] != "value of "+key {
+			t.Errorf("%s = %q", key, resp.Values[i])
+		}
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.sizes) < 4 {
+		t.Errorf("%d batches in 150ms at one per 20ms", len(client.sizes))
+	}
+	for _, size := range client.sizes {
+		if size != 4 {
+			t.Fatalf("batch sizes = %v, want all 4", client.sizes)
+		}
+	}
+}
+
+func TestConstantRateSlowExecutor(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "table_config.json")
+	os.WriteFile(configPath, []byte(`{"tables": [], "total_partitions": 1, "default_partitioning": "hash"}`), 0600)
+	NewBatcher(context.Background(), 4, 1, 20, "test-constant-rate-slow", nil, "localhost", "1", 1, false, false, nil, &ConstantRateConfig{QueueBound: 8}, otel.Tracer(""), configPath)
+
+	// Batches queue up behind the executor, they must still go out one at a time
+	time.Sleep(400 * time.Millisecond)
+	slowClient.mu.Lock()
+	defer slowClient.mu.Unlock()
+	if len(slowClient.sizes) < 2 {
+		t.Errorf("%d batches in 400ms at one per 60ms", len(slowClient.sizes))
+	}
+	for _, size := range slowClient.sizes {
+		if size != 4 {
+			t.Fatalf("batch sizes = %v, want all 4", slowClient.sizes)
+		}
+	}
+}
+
+func TestEnqueueBounded(t *testing.T) {
+	lb := &myBatcher{queueBound: 4, executorChannels: map[int]chan *KVPair{0: make(chan *KVPair, 4), 1: make(chan *KVPair, 4)}}
+	if err := lb.enqueueBounded([]*KVPair{{}, {}, {}}, []int{0, 0, 0}); err != nil {
+		t.Fatalf("enqueueBounded() error = %v", err)
+	}
+	// All keys of a request are admitted or none
+	err := lb.enqueueBounded([]*KVPair{{}, {}, {}}, []int{1, 0, 0})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("enqueueBounded() on a full queue error = %v", err)
+	}
+	if len(lb.executorChannels[1]) != 0 || lb.TotalRejected.Load() != 1 {
+		t.Errorf("rejected request left %d keys queued, %d rejections", len(lb.executorChannels[1]), lb.TotalRejected.Load())
+	}
+}
+
+func TestEnqueueChunked(t *testing.T) {
+	lb := &myBatcher{queueBound: 4, executorChannels: map[int]chan *KVPair{0: make(chan *KVPair, 4)}, roomFreed: make(chan struct{}, 1)}
+	kvs := make([]*KVPair, 10)
+	executorIDs := make([]int, 10)
+	for i := range kvs {
+		kvs[i] = &KVPair{sortingKey: i}
+	}
+	admitted := make(chan error)
+	go func() { admitted <- lb.enqueueBounded(kvs, executorIDs) }()
+
+	// Play the coordinator: take the queued keys and free the room
+	var queued []int
+	for len(queued) < len(kvs) {
+		select {
+		case kv := <-lb.executorChannels[0]:
+			queued = append(queued, kv.sortingKey)
+		case <-time.After(10 * time.Millisecond):
+			if len(queued) == 4 {
+				// Smaller requests wait until the large one is in
+				if err := lb.enqueueBounded([]*KVPair{{}}, []int{0}); status.Code(err) != codes.ResourceExhausted {
+					t.Errorf("enqueueBounded() during a chunked admission error = %v", err)
+				}
+			}
+			lb.roomFreed <- struct{}{}
+		}
+	}
+	if err := <-admitted; err != nil {
+		t.Fatalf("enqueueBounded() of a request beyond the bound error = %v", err)
+	}
+	for i, sortingKey := range queued {
+		if sortingKey != i {
+			t.Fatalf("keys queued in order %v", queued)
+		}
+	}
+	if err := lb.enqueueBounded([]*KVPair{{}}, []int{0}); err != nil {
+		t.Errorf("enqueueBounded() after the chunked admission error = %v", err)
+	}
+}
+
+func TestConstantRateLargeRequest(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "table_config.json")
+	os.WriteFile(configPath, []byte(`{"tables": [], "total_partitions": 1, "default_partitioning": "hash"}`), 0600)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lb := NewBatcher(ctx, 4, 1, 5, "test-constant-rate", nil, "localhost", "1", 1, false, false, nil, &ConstantRateConfig{QueueBound: 8}, otel.Tracer(""), configPath)
+
+	// Three times the queue bound
+	req := &loadBalancer.LoadBalanceRequest{}
+	for i := 0; i < 24; i++ {
+		req.Keys = append(req.Keys, fmt.Sprintf("k%d", i))
+		req.Values = append(req.Values, "")
+	}
+	resp, err := lb.AddKeys(context.Background(), req)
+	if err != nil {
+		t.Fatalf("AddKeys() of a request beyond the queue bound error = %v", err)
+	}
+	for i, key := range req.Keys {
+		if resp.Keys[i] != key || resp.Values[i] != "value of "+key {
+			t.Errorf("reply %d to %s is %s = %q", i, key, resp.Keys[i], resp.Values[i])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+//go:build unix
+
+package batcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	loadBalancer "github.com/project/ObliSql/api/loadbalancer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// echoClient answers every key with its name.
+type echoClient struct{}
+
+func (echoClient) MixBatch(keys []string, values []string, batchID int64) ([]string, error) {
+	resp := make([]string, len(keys))
+	for i, key := range keys {
+		resp[i] = key + ":" + key
+	}
+	return resp, nil
+}
+
+func init() {
+	RegisterExecutor("test-echo", func(string, int, map[string]string, trace.Tracer) (ExecutorClient, error) {
+		return echoClient{}, nil
+	})
+}
+
+func newBenchBatcher(b *testing.B, ctx context.Context, R int, waitTime int, executors int) *myBatcher {
+	b.Helper()
+	configPath := filepath.Join(b.TempDir(), "table_config.json")
+	config := fmt.Sprintf(`{"tables": [], "total_partitions": %d, "default_partitioning": "hash"}`, executors)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		b.Fatal(err)
+	}
+	hosts, ports := "localhost", "1"
+	for i := 1; i < executors; i++ {
+		hosts, ports = hosts+",localhost", ports+",1"
+	}
+	return NewBatcher(ctx, R, executors, waitTime, "test-echo", nil, hosts, ports, 2, false, false, nil, nil, otel.Tracer(""), configPath)
+}
+
+// cpuTime returns the CPU time the process has used.
+func cpuTime(b *testing.B) time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		b.Fatal(err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// BenchmarkIdle reports the CPU an idle batcher uses, in CPU milliseconds per second.
+func BenchmarkIdle(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	newBenchBatcher(b, ctx, 100, 50, 2)
+	time.Sleep(100 * time.Millisecond) // Let it start up
+
+	b.ResetTimer()
+	start, used := time.Now(), cpuTime(b)
+	for i := 0; i < b.N; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+	b.ReportMetric(float64(cpuTime(b)-used)/float64(time.Millisecond)/elapsed.Seconds(), "cpu-ms/s")
+}
+
+// BenchmarkSaturation reports the keys per second the batcher pushes through executors that
+// answer at once, with requests sent from many goroutines.
+func BenchmarkSaturation(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	lb := newBenchBatcher(b, ctx, 100, 5, 2)
+
+	var requestID atomic.Int64
+	// Requests only read the keys, so they can share them
+	keys := make([]string, 10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("table%d/col/%d", i, i) // Spread over the executors
+	}
+	values := make([]string, len(keys))
+	b.SetParallelism(16)
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := &loadBalancer.LoadBalanceRequest{RequestId: requestID.Add(1), Keys: keys, Values: values}
+			if _, err := lb.AddKeys(context.Background(), req); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.ReportMetric(float64(b.N*len(keys))/time.Since(start).Seconds(), "keys/s")
+}
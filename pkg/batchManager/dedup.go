@@ -0,0 +1,27 @@
+package batcher
+
+// coalesceGets merges repeated GETs of a key within batch into the first of them, which keeps
+// its place and collects the others in its duplicates so they get its result. A PUT of the
+// key ends the run of GETs it can be merged with, so a GET after the PUT is sent on its own
+// and sees the new value. Returns the remaining accesses in their original order and how
+// many were merged.
+func coalesceGets(batch []*KVPair) ([]*KVPair, int) {
+	pendingGet := make(map[string]*KVPair) // Last GET of each key not followed by a PUT yet
+	out := batch[:0:0]
+	merged := 0
+	for _, kv := range batch {
+		if kv.Value != "" {
+			delete(pendingGet, kv.Key)
+			out = append(out, kv)
+			continue
+		}
+		if first, ok := pendingGet[kv.Key]; ok {
+			first.duplicates = append(first.duplicates, kv)
+			merged++
+			continue
+		}
+		pendingGet[kv.Key] = kv
+		out = append(out, kv)
+	}
+	return out, merged
+}
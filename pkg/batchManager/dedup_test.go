@@ -0,0 +1,47 @@
+package batcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCoalesceGets(t *testing.T) {
+	get := func(key string, i int) *KVPair { return &KVPair{Key: key, sortingKey: i, channelId: "c"} }
+	put := func(key, value string, i int) *KVPair {
+		return &KVPair{Key: key, Value: value, sortingKey: i, channelId: "c"}
+	}
+	batch := []*KVPair{
+		get("a", 0),
+		get("b", 1),
+		get("a", 2),
+		put("a", "new", 3),
+		get("a", 4),
+		get("a", 5),
+		get("b", 6),
+	}
+
+	out, merged := coalesceGets(batch)
+	if merged != 3 {
+		t.Errorf("merged %d GETs, want 3", merged)
+	}
+	var order []int
+	for _, kv := range out {
+		order = append(order, kv.sortingKey)
+	}
+	// The GETs of a after the PUT must not share the result of the GET before it
+	if want := []int{0, 1, 3, 4}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("got accesses %v, want %v", order, want)
+	}
+	duplicates := func(kv *KVPair) []int {
+		var keys []int
+		for _, dup := range kv.duplicates {
+			keys = append(keys, dup.sortingKey)
+		}
+		return keys
+	}
+	for i, want := range [][]int{{2}, {6}, nil, {5}} {
+		if got := duplicates(out[i]); !reflect.DeepEqual(got, want) {
+			t.Errorf("access %d: got duplicates %v, want %v", out[i].sortingKey, got, want)
+		}
+	}
+}
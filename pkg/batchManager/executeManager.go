@@ -2,7 +2,9 @@ package batcher
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/project/ObliSql/pkg/oramClient"
 	ptClient "github.com/project/ObliSql/pkg/plainTextClient"
@@ -69,22 +71,86 @@ func (p *plainTextClientAdapter) MixBatch(keys []string, values []string, batchI
 	return p.client.MixBatch(keys, values, batchID)
 }
 
-// Factory function to create ExecutorClient based on executorType
+// Names of the built-in executor types
 const (
 	ExecutorTypeWaffle    = "waffle"
 	ExecutorTypeORAM      = "oram"
 	ExecutorTypePlaintext = "plaintext"
 )
 
-func NewExecutorClient(executorType, host string, port int, tracer trace.Tracer) (ExecutorClient, error) {
-	switch strings.ToLower(executorType) {
-	case ExecutorTypeWaffle:
+// ExecutorFactory creates a client for the executor at host:port. options holds the backend
+// specific options given to the batcher, factories ignore the ones they do not know.
+type ExecutorFactory func(host string, port int, options map[string]string, tracer trace.Tracer) (ExecutorClient, error)
+
+var (
+	registryLock      sync.RWMutex
+	executorFactories = make(map[string]ExecutorFactory)
+)
+
+// RegisterExecutor makes an executor type available to the batcher under name, which is
+// matched case-insensitively. Packages adding an executor call it from their init function,
+// so linking them into the batcher is enough to use them. It panics if name is taken.
+func RegisterExecutor(name string, factory ExecutorFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	name = strings.ToLower(name)
+	if factory == nil {
+		panic("batcher: RegisterExecutor factory is nil")
+	}
+	if _, taken := executorFactories[name]; taken {
+		panic("batcher: RegisterExecutor called twice for executor " + name)
+	}
+	executorFactories[name] = factory
+}
+
+// RegisteredExecutors returns the names of all registered executor types, sorted.
+func RegisteredExecutors() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(executorFactories))
+	for name := range executorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewExecutorClient creates a client of the registered executor type executorType.
+func NewExecutorClient(executorType, host string, port int, options map[string]string, tracer trace.Tracer) (ExecutorClient, error) {
+	registryLock.RLock()
+	factory, ok := executorFactories[strings.ToLower(executorType)]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported executor type: %s (registered: %s)", executorType, strings.Join(RegisteredExecutors(), ", "))
+	}
+	return factory(host, port, options, tracer)
+}
+
+// ParseExecutorOptions parses backend specific options given as "key=value,key=value".
+func ParseExecutorOptions(text string) (map[string]string, error) {
+	options := make(map[string]string)
+	for _, pair := range strings.Split(text, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("executor option %q is not key=value", pair)
+		}
+		options[key] = value
+	}
+	return options, nil
+}
+
+func init() {
+	RegisterExecutor(ExecutorTypeWaffle, func(host string, port int, _ map[string]string, tracer trace.Tracer) (ExecutorClient, error) {
 		return NewWaffleExecutorAdapter(host, port, tracer)
-	case ExecutorTypeORAM:
+	})
+	RegisterExecutor(ExecutorTypeORAM, func(host string, port int, _ map[string]string, tracer trace.Tracer) (ExecutorClient, error) {
 		return NewORAMClientAdapter(host, port, tracer)
-	case ExecutorTypePlaintext:
+	})
+	RegisterExecutor(ExecutorTypePlaintext, func(host string, port int, _ map[string]string, tracer trace.Tracer) (ExecutorClient, error) {
 		return NewPlaintextClientAdapter(host, port, tracer)
-	default:
-		return nil, fmt.Errorf("unsupported executor type: %s", executorType)
-	}
+	})
 }
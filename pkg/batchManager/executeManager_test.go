@@ -0,0 +1,57 @@
+package batcher
+
+import (
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type optionsClient struct {
+	options map[string]string
+}
+
+func (c *optionsClient) MixBatch(keys []string, values []string, batchID int64) ([]string, error) {
+	return nil, nil
+}
+
+func TestRegisterExecutor(t *testing.T) {
+	RegisterExecutor("Test-Options", func(host string, port int, options map[string]string, tracer trace.Tracer) (ExecutorClient, error) {
+		return &optionsClient{options: options}, nil
+	})
+
+	options, err := ParseExecutorOptions("mode=fast, shards=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewExecutorClient("TEST-options", "localhost", 1, options, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.(*optionsClient).options; !reflect.DeepEqual(got, map[string]string{"mode": "fast", "shards": "4"}) {
+		t.Errorf("factory got options %v", got)
+	}
+
+	if _, err := NewExecutorClient("missing", "localhost", 1, nil, nil); err == nil {
+		t.Error("expected an error for an unregistered executor type")
+	}
+	if _, err := ParseExecutorOptions("mode"); err == nil {
+		t.Error("expected an error for an option without a value")
+	}
+	for _, name := range []string{ExecutorTypeWaffle, ExecutorTypeORAM, ExecutorTypePlaintext, "test-options"} {
+		found := false
+		for _, registered := range RegisteredExecutors() {
+			found = found || registered == name
+		}
+		if !found {
+			t.Errorf("%s is not registered", name)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a taken name must panic")
+		}
+	}()
+	RegisterExecutor("test-options", func(string, int, map[string]string, trace.Tracer) (ExecutorClient, error) { return nil, nil })
+}
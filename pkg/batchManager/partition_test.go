@@ -0,0 +1,39 @@
+package batcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyPartitioning(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "table_config.json")
+	os.WriteFile(configPath, []byte(`{"tables": [{"name": "users", "partition_id": 2}], "total_partitions": 3, "default_partitioning": "key"}`), 0600)
+	config, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Listed tables stay on their executor
+	for pk := 0; pk < 100; pk++ {
+		if executor := assignTableToExecutor(fmt.Sprintf("users/name/%d", pk), config); executor != 2 {
+			t.Fatalf("users key went to executor %d, want 2", executor)
+		}
+	}
+	// The others spread over every executor, and a key always goes to the same one
+	counts := make(map[uint32]int)
+	for pk := 0; pk < 3000; pk++ {
+		key := fmt.Sprintf("review/rating/%d", pk)
+		executor := assignTableToExecutor(key, config)
+		if executor != assignTableToExecutor(key, config) {
+			t.Fatalf("%s went to different executors", key)
+		}
+		counts[executor]++
+	}
+	for executor := uint32(0); executor < 3; executor++ {
+		if counts[executor] < 800 {
+			t.Errorf("review keys per executor = %v", counts)
+		}
+	}
+}
@@ -7,8 +7,6 @@ import (
 	"log"
 	"math/rand"
 	"os"
-	"strconv"
-	"strings"
 	"sync/atomic"
 	"time"
 
@@ -125,75 +123,57 @@ func sendRequestsForever(ctx context.Context, ackChannel *chan Ack, requests *[]
 	}
 }
 
-// Modified getResponses to collect latencies
-func getResponses(ctx context.Context, ackChannel *chan Ack, latencies *[]string) (int, int) {
-	operations, errors := 0, 0
+// getResponses records acknowledgements in results until ctx is done.
+func getResponses(ctx context.Context, ackChannel *chan Ack, results *runResults) (int, int) {
 	for {
 		select {
 		case <-ctx.Done():
-			return operations, errors
+			return results.ops, results.errs
 		case ack := <-*ackChannel:
-			if ack.hadError {
-				errors++
-			} else {
-				operations++
-			}
-			*latencies = append(*latencies, ack.latency.String()+"_"+ack.QueryType+"_"+strconv.Itoa(ack.responseSize))
+			results.record(ack)
 		}
 	}
 }
 
-func runBenchmark(resolverClient *[]resolver.ResolverClient, requests *[]Query, rateLimit *RateLimit, duration int, warmup bool) (int, int, time.Duration) {
+func runBenchmark(resolverClient *[]resolver.ResolverClient, requests *[]Query, rateLimit *RateLimit, duration int, warmup bool) *runResults {
 	realRequestCounter := atomic.Int64{}
-	latencies := []string{}
+	results := newRunResults()
 	ackChannel := make(chan Ack)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(duration)*time.Second)
 	defer cancel()
 
 	go sendRequestsForever(ctx, &ackChannel, requests, resolverClient, rateLimit, &realRequestCounter)
-	ops, err := getResponses(ctx, &ackChannel, &latencies)
+	ops, err := getResponses(ctx, &ackChannel, results)
 	if !warmup {
 		fmt.Printf("Ops/s,%d,Err,%d\n", ops, err)
 	}
-	fmt.Printf("Non-Empty Requests: %d\n", realRequestCounter.Load())
+	results.nonEmpty = realRequestCounter.Load()
+	fmt.Printf("Non-Empty Requests: %d\n", results.nonEmpty)
 
-	var totalLatency time.Duration
-	for _, l := range latencies {
-		parts := strings.Split(l, "_")
-		if len(parts) > 0 {
-			latency, err := time.ParseDuration(parts[0])
-			if err == nil {
-				totalLatency += latency
-			}
+	return results
+}
+
+// StartBench runs the workload defined in workloadFile, or without one, the compiled-in
+// workload queryType. Load is closed-loop with at most inFlight requests outstanding unless
+// openLoop sets an arrival rate or a sweep. The latencies of the measured runs are written to
+// output as JSON, or CSV if it ends in .csv, and to a timestamped JSON file if it is empty,
+// along with the outcome of verifier if the clients were wrapped by one.
+func StartBench(resolverClient *[]resolver.ResolverClient, inFlight int, timeDuration int, queryType string, joinRange int, rangeSize int, workloadFile string, openLoop OpenLoop, output string, verifier *Verifier) {
+	report := newReport()
+	defer func() {
+		if err := report.finish(output, verifier); err != nil {
+			log.Fatal(err)
 		}
-	}
-	averageLatency := time.Duration(0)
-	if len(latencies) > 0 {
-		averageLatency = totalLatency / time.Duration(len(latencies))
-	}
-	// Write all latencies to file
-	if !warmup {
-		timestamp := time.Now().Format("2006-01-02_15-04-05")
-		filename := fmt.Sprintf("latencies_benchmark_%s.txt", timestamp)
-		file, err := os.Create(filename)
+	}()
+
+	if workloadFile != "" {
+		workload, err := LoadWorkload(workloadFile)
 		if err != nil {
-			fmt.Println("Error creating file:", err)
-		} else {
-			defer file.Close()
-			for _, latency := range latencies {
-				_, err = file.WriteString(fmt.Sprintf("%v\n", latency))
-				if err != nil {
-					fmt.Println("Error writing to file:", err)
-					break
-				}
-			}
+			log.Fatal(err)
 		}
+		runWorkload(resolverClient, workload, inFlight, timeDuration, openLoop, report)
+		return
 	}
-	// fmt.Printf("Average Latency: %v\n", averageLatency)
-	return ops, err, averageLatency
-}
-
-func StartBench(resolverClient *[]resolver.ResolverClient, inFlight int, timeDuration int, queryType string, joinRange int, rangeSize int) {
 	fmt.Println("Join Range: ", joinRange)
 	fmt.Println("RangeSize: ", rangeSize)
 	itemIDFile := os.Getenv("ITEM_ID_FILE")
@@ -440,16 +420,20 @@ func StartBench(resolverClient *[]resolver.ResolverClient, inFlight int, timeDur
 	// 	fmt.Println(strings.Repeat("-", 50))
 	// }
 
-	fmt.Println("In-Flight Requests:", inFlight)
-	rateLimit := NewRateLimit(inFlight)
-	ops1, err1, _ := runBenchmark(resolverClient, &requestsWarmup, rateLimit, 10, true)
-	fmt.Printf("Warmup Done! %d %d\n", ops1, err1)
+	if openLoop.warmupQPS() > 0 {
+		fmt.Println("Arrivals:", openLoop.Arrival)
+	} else {
+		fmt.Println("In-Flight Requests:", inFlight)
+	}
+	warmup := runLoad(resolverClient, &requestsWarmup, inFlight, openLoop, openLoop.warmupQPS(), 10, true)
+	fmt.Printf("Warmup Done! %d %d\n", warmup.ops, warmup.errs)
+	if openLoop.sweeping() {
+		fmt.Println("-------")
+		runSweep(resolverClient, &requestsBench, openLoop, timeDuration, report, queryType)
+		return
+	}
 	fmt.Println("-------")
 	fmt.Printf("Running Benchmark! %d seconds \n", timeDuration)
-	rateLimitNew := NewRateLimit(inFlight)
-	ops2, err2, lat2 := runBenchmark(resolverClient, &requestsBench, rateLimitNew, timeDuration, false)
-
-	fmt.Printf("Total Ops: %d\n", ops2)
-	fmt.Printf("Total Err: %d\n", err2)
-	fmt.Printf("Average Latency: %v ms\n", lat2.Milliseconds())
+	results := runLoad(resolverClient, &requestsBench, inFlight, openLoop, openLoop.QPS, timeDuration, false)
+	report.record(queryType, results, openLoop.QPS, inFlight, timeDuration)
 }
@@ -0,0 +1,105 @@
+package benchmark
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramSubBits sets the precision of latencyHistogram: values are kept to within
+// 1/2^(histogramSubBits-1), about 0.1%, or three significant digits.
+const histogramSubBits = 11
+
+// latencyHistogram is a high dynamic range histogram of latencies in nanoseconds. Like
+// HdrHistogram it splits each power of two into the same number of linear sub-buckets, so
+// it takes constant memory and time per value while every percentile has a fixed relative
+// error, from microseconds to minutes.
+type latencyHistogram struct {
+	counts []int64
+	total  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{min: math.MaxInt64}
+}
+
+// bucketIndex returns the bucket of v. Values below 2^histogramSubBits have a bucket each,
+// above that each power of two has 2^(histogramSubBits-1) buckets.
+func bucketIndex(v uint64) int {
+	if v < 1<<histogramSubBits {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histogramSubBits
+	top := v >> shift // In [2^(subBits-1), 2^subBits)
+	return 1<<histogramSubBits + (shift-1)<<(histogramSubBits-1) + int(top-1<<(histogramSubBits-1))
+}
+
+// bucketHighest returns the highest value in bucket i.
+func bucketHighest(i int) uint64 {
+	if i < 1<<histogramSubBits {
+		return uint64(i)
+	}
+	i -= 1 << histogramSubBits
+	shift := i>>(histogramSubBits-1) + 1
+	top := uint64(i&(1<<(histogramSubBits-1)-1)) + 1<<(histogramSubBits-1)
+	return (top+1)<<shift - 1
+}
+
+func (h *latencyHistogram) record(latency time.Duration) {
+	if latency < 0 {
+		latency = 0
+	}
+	i := bucketIndex(uint64(latency))
+	if i >= len(h.counts) {
+		counts := make([]int64, i+1)
+		copy(counts, h.counts)
+		h.counts = counts
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += latency
+	if latency < h.min {
+		h.min = latency
+	}
+	if latency > h.max {
+		h.max = latency
+	}
+}
+
+func (h *latencyHistogram) count() int64 {
+	return h.total
+}
+
+func (h *latencyHistogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// percentile returns the latency at or below which p percent of the values are, rounded up
+// to the highest latency of its bucket and capped at the largest value recorded.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p / 100 * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := int64(0)
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			value := time.Duration(bucketHighest(i))
+			if value > h.max {
+				value = h.max
+			}
+			return value
+		}
+	}
+	return h.max
+}
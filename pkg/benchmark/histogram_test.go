@@ -0,0 +1,62 @@
+package benchmark
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	for _, v := range []uint64{0, 1, 2047, 2048, 2049, 4095, 4096, 1 << 20, 123456789, 1<<40 + 12345} {
+		i := bucketIndex(v)
+		if highest := bucketHighest(i); highest < v {
+			t.Errorf("bucket %d of %d ends at %d", i, v, highest)
+		}
+		if i > 0 && bucketHighest(i-1) >= v {
+			t.Errorf("bucket %d of %d is not the first holding it", i, v)
+		}
+		if highest := bucketHighest(i); float64(highest-v) > float64(v)/1000 {
+			t.Errorf("bucket %d of %d ends at %d, more than 0.1%% above", i, v, highest)
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	h := newLatencyHistogram()
+	if h.percentile(99) != 0 || h.mean() != 0 {
+		t.Errorf("empty histogram has p99 %v and mean %v", h.percentile(99), h.mean())
+	}
+
+	for i := 100; i >= 1; i-- {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{0: time.Millisecond, 50: 50 * time.Millisecond, 99: 99 * time.Millisecond, 100: 100 * time.Millisecond} {
+		got := h.percentile(p)
+		if got < want || got > want+want/1000 {
+			t.Errorf("percentile(%g) = %v, want %v", p, got, want)
+		}
+	}
+	if h.count() != 100 || h.mean() != 50500*time.Microsecond {
+		t.Errorf("count %d and mean %v, want 100 and 50.5ms", h.count(), h.mean())
+	}
+}
+
+func TestHistogramMatchesSorted(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	h := newLatencyHistogram()
+	latencies := make([]time.Duration, 10000)
+	for i := range latencies {
+		// Spread over microseconds to seconds
+		latencies[i] = time.Duration(rng.ExpFloat64() * float64(20*time.Millisecond))
+		h.record(latencies[i])
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		want := latencies[int(math.Ceil(p/100*float64(len(latencies))))-1]
+		if got := h.percentile(p); got < want || got > want+want/1000 {
+			t.Errorf("percentile(%g) = %v, want %v within 0.1%%", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+)
+
+// OpenLoop configures open-loop load generation. Closed-loop runs only send a request when
+// one of the in-flight slots frees up, so an overloaded system slows down the load and its
+// latency hides the queueing. Open-loop runs send requests at a target arrival rate no matter
+// how many are outstanding, and measure each latency from the time the request was due to be
+// sent, so a request delayed behind earlier ones is charged for the wait (coordinated
+// omission correction).
+type OpenLoop struct {
+	QPS     float64 // Target arrival rate, 0 runs closed-loop
+	Arrival string  // "poisson" or "constant" interarrival times
+
+	// A sweep runs the benchmark at SweepStart QPS and raises it by SweepStep until SweepMax
+	// or until the SLO is violated, and reports the highest rate that met it. SweepStep 0
+	// turns sweeping off.
+	SweepStart    float64
+	SweepStep     float64
+	SweepMax      float64
+	SLO           time.Duration // Maximum latency at SLOPercentile
+	SLOPercentile float64       // Percentile of the SLO, such as 99
+}
+
+const (
+	ArrivalPoisson  = "poisson"
+	ArrivalConstant = "constant"
+)
+
+// minAchievedRatio is the share of the target rate a sweep step must complete to pass. Below
+// it requests pile up faster than they finish and the latency keeps growing with the run.
+const minAchievedRatio = 0.95
+
+// interarrival returns the time from one arrival to the next.
+func (o OpenLoop) interarrival(rng *rand.Rand, qps float64) time.Duration {
+	mean := float64(time.Second) / qps
+	if o.Arrival == ArrivalConstant {
+		return time.Duration(mean)
+	}
+	return time.Duration(rng.ExpFloat64() * mean)
+}
+
+// unfinishedRequests collects the requests still running when an open-loop run ends.
+type unfinishedRequests struct {
+	mu   sync.Mutex
+	acks []Ack
+}
+
+func (u *unfinishedRequests) add(ack Ack) {
+	u.mu.Lock()
+	u.acks = append(u.acks, ack)
+	u.mu.Unlock()
+}
+
+// asyncOpenRequest runs request and reports its latency since intended, the time it was due.
+// Once ctx is done nobody reads ackChannel. A request that has not been reported by then is
+// added to unfinished instead, if it is set, charged up to the deadline of ctx.
+func asyncOpenRequest(ctx context.Context, ackChannel *chan Ack, resolverClient *[]resolver.ResolverClient, request *Query, intended time.Time, counter *atomic.Int64, wg *sync.WaitGroup, unfinished *unfinishedRequests) {
+	defer wg.Done()
+	conn := GetRandomClient(resolverClient)
+	resp, err := conn.ExecuteQuery(ctx, request.requestQuery)
+	latency := time.Since(intended)
+	queryType := request.requestQuery.QueryType
+
+	ack := Ack{hadError: true, latency: latency, QueryType: queryType, ErrorString: err, responseSize: -1}
+	if err == nil {
+		if len(resp.Values) > 0 {
+			counter.Add(1)
+		}
+		ack = Ack{hadError: false, latency: latency, QueryType: queryType, ErrorString: nil, responseSize: len(resp.Values)}
+	}
+	if ctx.Err() == nil {
+		select {
+		case *ackChannel <- ack:
+			return
+		case <-ctx.Done():
+		}
+	}
+	if unfinished != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			latency = min(latency, deadline.Sub(intended))
+		}
+		unfinished.add(Ack{latency: latency, QueryType: queryType})
+	}
+}
+
+// sendRequestsOpenLoop sends requests at qps, cycling through them, until ctx is done. Every
+// request it sends is added to wg.
+func sendRequestsOpenLoop(ctx context.Context, ackChannel *chan Ack, requests *[]Query, resolverClient *[]resolver.ResolverClient, o OpenLoop, qps float64, counter *atomic.Int64, wg *sync.WaitGroup, unfinished *unfinishedRequests) {
+	defer wg.Done()
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	next := time.Now()
+	for i := 0; ; i++ {
+		// Requests due in the past are sent at once, their latency still counts from next
+		if wait := time.Until(next); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		wg.Add(1)
+		go asyncOpenRequest(ctx, ackChannel, resolverClient, &(*requests)[i%len(*requests)], next, counter, wg, unfinished)
+		next = next.Add(o.interarrival(rng, qps))
+	}
+}
+
+// runOpenLoop runs requests at qps for duration seconds. It returns once the requests still
+// in flight at the end of the run are cancelled, so they do not run into the next one. Their
+// latency up to the end of the run is added to the results, so the slowest requests of an
+// overloaded run are not dropped from the percentiles.
+func runOpenLoop(resolverClient *[]resolver.ResolverClient, requests *[]Query, o OpenLoop, qps float64, duration int, warmup bool) *runResults {
+	realRequestCounter := atomic.Int64{}
+	results := newRunResults()
+	ackChannel := make(chan Ack)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(duration)*time.Second)
+	defer cancel()
+
+	var inFlight sync.WaitGroup
+	var unfinished unfinishedRequests
+	inFlight.Add(1)
+	go sendRequestsOpenLoop(ctx, &ackChannel, requests, resolverClient, o, qps, &realRequestCounter, &inFlight, &unfinished)
+	ops, err := getResponses(ctx, &ackChannel, results)
+	cancel()
+	inFlight.Wait()
+	if !warmup {
+		fmt.Printf("Target QPS,%.1f,Ops/s,%d,Err,%d\n", qps, ops, err)
+	}
+	results.nonEmpty = realRequestCounter.Load()
+	fmt.Printf("Non-Empty Requests: %d\n", results.nonEmpty)
+	for _, ack := range unfinished.acks {
+		results.recordUnfinished(ack)
+	}
+	fmt.Printf("Unfinished Requests: %d\n", results.unfinished)
+
+	return results
+}
+
+// runSweep raises the arrival rate step by step until the SLO is violated and returns the
+// highest rate that met it, 0 if none did. Every step is added to report as a run named
+// after name and its rate.
+func runSweep(resolverClient *[]resolver.ResolverClient, requests *[]Query, o OpenLoop, duration int, report *Report, name string) float64 {
+	fmt.Printf("Sweeping QPS from %.1f by %.1f up to %.1f, SLO p%g <= %v\n", o.SweepStart, o.SweepStep, o.SweepMax, o.SLOPercentile, o.SLO)
+	type step struct {
+		qps      float64
+		achieved float64
+		p50      time.Duration
+		pSLO     time.Duration
+		errs     int
+		pass     bool
+	}
+	var steps []step
+	maxSustainable := 0.0
+	for i := 0; ; i++ {
+		qps := o.SweepStart + float64(i)*o.SweepStep
+		if qps > o.SweepMax*(1+1e-9) {
+			break
+		}
+		fmt.Println("-------")
+		fmt.Printf("Running Sweep Step! %.1f QPS for %d seconds \n", qps, duration)
+		results := runOpenLoop(resolverClient, requests, o, qps, duration, false)
+		report.record(fmt.Sprintf("%s@%g", name, qps), results, qps, 0, duration)
+		s := step{
+			qps:      qps,
+			achieved: float64(results.ops) / float64(duration),
+			pSLO:     results.all.percentile(o.SLOPercentile),
+			p50:      results.all.percentile(50),
+			errs:     results.errs,
+		}
+		s.pass = results.ops > 0 && s.pSLO <= o.SLO && s.achieved >= minAchievedRatio*qps
+		steps = append(steps, s)
+		if !s.pass {
+			break
+		}
+		maxSustainable = qps
+	}
+
+	fmt.Println("-------")
+	fmt.Printf("QPS,Achieved,P50,P%g,Err,Pass\n", o.SLOPercentile)
+	for _, s := range steps {
+		fmt.Printf("%.1f,%.1f,%v,%v,%d,%t\n", s.qps, s.achieved, s.p50, s.pSLO, s.errs, s.pass)
+	}
+	fmt.Printf("Max Sustainable QPS: %.1f\n", maxSustainable)
+	report.Sweeps = append(report.Sweeps, SweepReport{
+		Name:              name,
+		SLOMs:             milliseconds(o.SLO),
+		SLOPercentile:     o.SLOPercentile,
+		MaxSustainableQPS: maxSustainable,
+	})
+	return maxSustainable
+}
+
+// sweeping reports whether a sweep is configured.
+func (o OpenLoop) sweeping() bool {
+	return o.SweepStep > 0
+}
+
+// warmupQPS is the arrival rate to warm up at, 0 for closed-loop.
+func (o OpenLoop) warmupQPS() float64 {
+	if o.sweeping() {
+		return o.SweepStart
+	}
+	return o.QPS
+}
+
+// runLoad runs requests for duration seconds closed-loop with at most inFlight outstanding,
+// or open-loop at qps if it is set.
+func runLoad(resolverClient *[]resolver.ResolverClient, requests *[]Query, inFlight int, o OpenLoop, qps float64, duration int, warmup bool) *runResults {
+	if qps > 0 {
+		return runOpenLoop(resolverClient, requests, o, qps, duration, warmup)
+	}
+	return runBenchmark(resolverClient, requests, NewRateLimit(inFlight), duration, warmup)
+}
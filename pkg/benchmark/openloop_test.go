@@ -0,0 +1,97 @@
+package benchmark
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+	"google.golang.org/grpc"
+)
+
+// queueResolver answers one query at a time, each taking service, so it completes at most
+// 1/service queries per second and queues the rest.
+type queueResolver struct {
+	mu      sync.Mutex
+	service time.Duration
+}
+
+func (r *queueResolver) ExecuteQuery(ctx context.Context, in *resolver.ParsedQuery, opts ...grpc.CallOption) (*resolver.QueryResponse, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	time.Sleep(r.service)
+	return &resolver.QueryResponse{Keys: []string{"k"}, Values: []string{"v"}}, nil
+}
+
+func (r *queueResolver) ConnectPingResolver(ctx context.Context, in *resolver.ClientConnectResolver, opts ...grpc.CallOption) (*resolver.ClientConnectResolver, error) {
+	return in, nil
+}
+
+func testQueries() *[]Query {
+	return &[]Query{{name: "q", requestQuery: &resolver.ParsedQuery{QueryType: "select"}}}
+}
+
+func TestOpenLoopRate(t *testing.T) {
+	clients := []resolver.ResolverClient{&queueResolver{}}
+	o := OpenLoop{Arrival: ArrivalConstant}
+	results := runOpenLoop(&clients, testQueries(), o, 200, 1, true)
+	if results.errs != 0 || results.ops < 180 || results.ops > 201 {
+		t.Errorf("1 second at 200 QPS completed %d queries with %d errors", results.ops, results.errs)
+	}
+}
+
+func TestOpenLoopChargesQueueing(t *testing.T) {
+	// The resolver completes at most 100 queries per second, so at 200 QPS the queue grows
+	// for the whole run. A closed loop with one query in flight only sees the service time.
+	clients := []resolver.ResolverClient{&queueResolver{service: 10 * time.Millisecond}}
+	results := runOpenLoop(&clients, testQueries(), OpenLoop{Arrival: ArrivalPoisson}, 200, 1, true)
+	if p99 := results.all.percentile(99); p99 < 200*time.Millisecond {
+		t.Errorf("open loop over capacity has p99 %v, want the queueing of the run", p99)
+	}
+
+	// A fresh resolver, the open loop only cancelled its queue
+	closedClients := []resolver.ResolverClient{&queueResolver{service: 10 * time.Millisecond}}
+	results = runBenchmark(&closedClients, testQueries(), NewRateLimit(1), 1, true)
+	if avg := results.all.mean(); avg > 50*time.Millisecond {
+		t.Errorf("closed loop has average latency %v, want about the service time", avg)
+	}
+}
+
+func TestOpenLoopChargesUnfinished(t *testing.T) {
+	// At twice the capacity about half the requests are still queued when the run ends
+	clients := []resolver.ResolverClient{&queueResolver{service: 10 * time.Millisecond}}
+	results := runOpenLoop(&clients, testQueries(), OpenLoop{Arrival: ArrivalConstant}, 200, 1, true)
+	if results.unfinished < 50 {
+		t.Fatalf("%d unfinished requests at twice the capacity", results.unfinished)
+	}
+	if count := results.all.count(); count != int64(results.ops)+results.unfinished {
+		t.Errorf("%d latencies for %d completed and %d unfinished requests", count, results.ops, results.unfinished)
+	}
+	// The last requests sent were due just before the end of the run
+	if max := results.all.percentile(100); max > 1100*time.Millisecond {
+		t.Errorf("unfinished requests charged %v, more than the run", max)
+	}
+	if p99 := results.all.percentile(99); p99 < 400*time.Millisecond {
+		t.Errorf("p99 %v with the unfinished requests, want the queueing of the run", p99)
+	}
+}
+
+func TestSweepFindsCapacity(t *testing.T) {
+	clients := []resolver.ResolverClient{&queueResolver{service: 10 * time.Millisecond}}
+	o := OpenLoop{
+		Arrival:       ArrivalConstant,
+		SweepStart:    25,
+		SweepStep:     50,
+		SweepMax:      500,
+		SLO:           100 * time.Millisecond,
+		SLOPercentile: 99,
+	}
+	report := newReport()
+	if got := runSweep(&clients, testQueries(), o, 1, report, "q"); got != 75 {
+		t.Errorf("sweep against a capacity of 100 QPS found %v QPS sustainable, want 75", got)
+	}
+	if len(report.Runs) != 3 || report.Runs[0].Name != "q@25" || report.Sweeps[0].MaxSustainableQPS != 75 {
+		t.Errorf("sweep reported %d runs and sweeps %v, want steps 25, 75 and 125", len(report.Runs), report.Sweeps)
+	}
+}
@@ -0,0 +1,93 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
+)
+
+// replayQueries turns trace entries into queries and the offsets they are due at, keeping the
+// gaps between them divided by speed.
+func replayQueries(entries []querytrace.Entry, speed float64) ([]Query, []time.Duration) {
+	queries := make([]Query, len(entries))
+	offsets := make([]time.Duration, len(entries))
+	for i, entry := range entries {
+		queries[i] = Query{name: entry.Query.QueryType, requestQuery: entry.Query}
+		// Entries are logged as queries arrive, so concurrent ones can be slightly out of order
+		if offset := time.Duration(float64(entry.Time.Sub(entries[0].Time)) / speed); offset > 0 {
+			offsets[i] = offset
+		}
+	}
+	return queries, offsets
+}
+
+// runReplay sends each query at its offset from the start of the run and waits for all of
+// them. Like open-loop runs, latency is measured from the time a query was due.
+func runReplay(resolverClient *[]resolver.ResolverClient, queries []Query, offsets []time.Duration) *runResults {
+	realRequestCounter := atomic.Int64{}
+	results := newRunResults()
+	ackChannel := make(chan Ack)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var inFlight sync.WaitGroup
+	inFlight.Add(len(queries))
+	go func() {
+		timer := time.NewTimer(0)
+		defer timer.Stop()
+		<-timer.C
+		for i := range queries {
+			intended := results.start.Add(offsets[i])
+			if wait := time.Until(intended); wait > 0 {
+				timer.Reset(wait)
+				<-timer.C
+			}
+			go asyncOpenRequest(ctx, &ackChannel, resolverClient, &queries[i], intended, &realRequestCounter, &inFlight, nil)
+		}
+	}()
+	for range queries {
+		results.record(<-ackChannel)
+	}
+	inFlight.Wait()
+	fmt.Printf("Ops/s,%d,Err,%d\n", results.ops, results.errs)
+	results.nonEmpty = realRequestCounter.Load()
+	fmt.Printf("Non-Empty Requests: %d\n", results.nonEmpty)
+	return results
+}
+
+// StartReplay replays the query trace in traceFile, as logged by the resolver, keeping the
+// time between queries divided by speed. The latencies are reported to output as with
+// StartBench.
+func StartReplay(resolverClient *[]resolver.ResolverClient, traceFile string, speed float64, output string, verifier *Verifier) {
+	if speed <= 0 {
+		log.Fatalf("Replay speed must be positive, got %g", speed)
+	}
+	entries, err := querytrace.Read(traceFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(entries) == 0 {
+		log.Fatalf("Query trace %s is empty", traceFile)
+	}
+	report := newReport()
+
+	queries, offsets := replayQueries(entries, speed)
+	duration := time.Duration(0)
+	for _, offset := range offsets {
+		duration = max(duration, offset)
+	}
+	fmt.Printf("Replaying %d queries over %v (speed %gx)\n", len(queries), duration, speed)
+	results := runReplay(resolverClient, queries, offsets)
+	report.record("replay", results, 0, 0, int(math.Ceil(duration.Seconds())))
+
+	if err := report.finish(output, verifier); err != nil {
+		log.Fatal(err)
+	}
+}
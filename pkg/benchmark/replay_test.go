@@ -0,0 +1,46 @@
+package benchmark
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
+)
+
+func TestReplayQueries(t *testing.T) {
+	start := time.Now()
+	entries := []querytrace.Entry{
+		{Time: start, Query: &resolver.ParsedQuery{QueryType: "select"}},
+		{Time: start.Add(time.Second), Query: &resolver.ParsedQuery{QueryType: "update"}},
+		{Time: start.Add(time.Second - time.Millisecond), Query: &resolver.ParsedQuery{QueryType: "join"}},
+	}
+	queries, offsets := replayQueries(entries, 4)
+	if queries[1].name != "update" || queries[1].requestQuery != entries[1].Query {
+		t.Errorf("query = %v, want the logged update", queries[1])
+	}
+	want := []time.Duration{0, 250 * time.Millisecond, 249750 * time.Microsecond}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Errorf("offsets = %v, want %v", offsets, want)
+			break
+		}
+	}
+}
+
+func TestReplayKeepsTiming(t *testing.T) {
+	clients := []resolver.ResolverClient{&queueResolver{}}
+	queries := []Query{
+		{name: "select", requestQuery: &resolver.ParsedQuery{QueryType: "select"}},
+		{name: "update", requestQuery: &resolver.ParsedQuery{QueryType: "update"}},
+		{name: "select", requestQuery: &resolver.ParsedQuery{QueryType: "select"}},
+	}
+	began := time.Now()
+	results := runReplay(&clients, queries, []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond})
+	if took := time.Since(began); took < 200*time.Millisecond || took > time.Second {
+		t.Errorf("replay of 200ms took %v", took)
+	}
+	if results.ops != 3 || results.byType["select"].count() != 2 || results.byType["update"].count() != 1 {
+		t.Errorf("replay completed %d queries, by type %v", results.ops, results.byType)
+	}
+}
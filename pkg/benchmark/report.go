@@ -0,0 +1,306 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runResults collects the acknowledgements of one benchmark run.
+type runResults struct {
+	start        time.Time
+	all          *latencyHistogram
+	byType       map[string]*latencyHistogram
+	errors       map[string]int // By error message
+	errorsByType map[string]int
+	perSecond    []ThroughputSample // Completions in each second since start
+	ops          int
+	errs         int
+	nonEmpty     int64
+	unfinished   int64 // Sent but not answered before the end of an open-loop run, in the latencies but not in ops
+}
+
+func newRunResults() *runResults {
+	return &runResults{
+		start:        time.Now(),
+		all:          newLatencyHistogram(),
+		byType:       make(map[string]*latencyHistogram),
+		errors:       make(map[string]int),
+		errorsByType: make(map[string]int),
+	}
+}
+
+// errorNumbers matches the request ids and other numbers that make each error message unique.
+var errorNumbers = regexp.MustCompile(`[0-9]+`)
+
+func (r *runResults) record(ack Ack) {
+	second := int(time.Since(r.start) / time.Second)
+	for len(r.perSecond) <= second {
+		r.perSecond = append(r.perSecond, ThroughputSample{Second: len(r.perSecond)})
+	}
+	if ack.hadError {
+		r.errs++
+		r.perSecond[second].Errors++
+		r.errors[errorNumbers.ReplaceAllString(fmt.Sprint(ack.ErrorString), "N")]++
+		r.errorsByType[ack.QueryType]++
+		return
+	}
+	r.ops++
+	r.perSecond[second].Ops++
+	r.recordLatency(ack)
+}
+
+// recordUnfinished adds a request that did not finish before the end of an open-loop run,
+// with its latency up to the end, to the latencies. It does not count as completed.
+func (r *runResults) recordUnfinished(ack Ack) {
+	r.unfinished++
+	r.recordLatency(ack)
+}
+
+func (r *runResults) recordLatency(ack Ack) {
+	r.all.record(ack.latency)
+	h, ok := r.byType[ack.QueryType]
+	if !ok {
+		h = newLatencyHistogram()
+		r.byType[ack.QueryType] = h
+	}
+	h.record(ack.latency)
+}
+
+// LatencySummary is the latency distribution of successful queries, and of queries an open-loop
+// run ended before they finished, in milliseconds.
+type LatencySummary struct {
+	Count  int64   `json:"count"`
+	Errors int     `json:"errors"`
+	MinMs  float64 `json:"minMs"`
+	MeanMs float64 `json:"meanMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P90Ms  float64 `json:"p90Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	P999Ms float64 `json:"p999Ms"`
+	MaxMs  float64 `json:"maxMs"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func summarize(h *latencyHistogram, errors int) LatencySummary {
+	s := LatencySummary{Count: h.count(), Errors: errors}
+	if h.count() == 0 {
+		return s
+	}
+	s.MinMs = milliseconds(h.min)
+	s.MeanMs = milliseconds(h.mean())
+	s.P50Ms = milliseconds(h.percentile(50))
+	s.P90Ms = milliseconds(h.percentile(90))
+	s.P99Ms = milliseconds(h.percentile(99))
+	s.P999Ms = milliseconds(h.percentile(99.9))
+	s.MaxMs = milliseconds(h.max)
+	return s
+}
+
+// ThroughputSample counts the queries completed in one second of a run.
+type ThroughputSample struct {
+	Second int `json:"second"`
+	Ops    int `json:"ops"`
+	Errors int `json:"errors"`
+}
+
+// RunReport is the outcome of one run: the measured phase of a benchmark, a workload phase
+// or a sweep step.
+type RunReport struct {
+	Name            string                    `json:"name"`
+	TargetQPS       float64                   `json:"targetQPS,omitempty"` // 0 for closed-loop runs
+	InFlight        int                       `json:"inFlight,omitempty"`  // Closed-loop runs only
+	DurationSeconds int                       `json:"durationSeconds"`
+	Ops             int                       `json:"ops"`
+	Errors          int                       `json:"errors"`
+	NonEmpty        int64                     `json:"nonEmpty"`
+	Unfinished      int64                     `json:"unfinished"`
+	Latency         LatencySummary            `json:"latency"`
+	ByQueryType     map[string]LatencySummary `json:"byQueryType"`
+	ErrorsByMessage map[string]int            `json:"errorsByMessage"` // Numbers in messages are replaced by N
+	Throughput      []ThroughputSample        `json:"throughput"`
+}
+
+func (r *runResults) report(name string, duration int) RunReport {
+	report := RunReport{
+		Name:            name,
+		DurationSeconds: duration,
+		Ops:             r.ops,
+		Errors:          r.errs,
+		NonEmpty:        r.nonEmpty,
+		Unfinished:      r.unfinished,
+		Latency:         summarize(r.all, r.errs),
+		ByQueryType:     make(map[string]LatencySummary),
+		ErrorsByMessage: r.errors,
+		Throughput:      r.perSecond,
+	}
+	for queryType, h := range r.byType {
+		report.ByQueryType[queryType] = summarize(h, r.errorsByType[queryType])
+	}
+	for queryType, errors := range r.errorsByType {
+		if _, ok := r.byType[queryType]; !ok {
+			report.ByQueryType[queryType] = LatencySummary{Errors: errors}
+		}
+	}
+	return report
+}
+
+// printLatencies prints the latency distribution by query type and the errors of a run.
+func (r RunReport) printLatencies() {
+	fmt.Println("QueryType,Count,Err,Mean(ms),P50(ms),P90(ms),P99(ms),P99.9(ms),Max(ms)")
+	print := func(name string, s LatencySummary) {
+		fmt.Printf("%s,%d,%d,%.3f,%.3f,%.3f,%.3f,%.3f,%.3f\n", name, s.Count, s.Errors, s.MeanMs, s.P50Ms, s.P90Ms, s.P99Ms, s.P999Ms, s.MaxMs)
+	}
+	for _, queryType := range sortedKeys(r.ByQueryType) {
+		print(queryType, r.ByQueryType[queryType])
+	}
+	print("all", r.Latency)
+	for _, message := range sortedKeys(r.ErrorsByMessage) {
+		fmt.Printf("Error,%d,%s\n", r.ErrorsByMessage[message], message)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SweepReport is the outcome of a QPS sweep.
+type SweepReport struct {
+	Name              string  `json:"name"`
+	SLOMs             float64 `json:"sloMs"`
+	SLOPercentile     float64 `json:"sloPercentile"`
+	MaxSustainableQPS float64 `json:"maxSustainableQPS"`
+}
+
+// Report holds every measured run of a benchmark, for plotting scripts.
+type Report struct {
+	Started      time.Time           `json:"started"`
+	Runs         []RunReport         `json:"runs"`
+	Sweeps       []SweepReport       `json:"sweeps,omitempty"`
+	Verification *VerificationReport `json:"verification,omitempty"`
+}
+
+func newReport() *Report {
+	return &Report{Started: time.Now()}
+}
+
+// record adds the results of a measured run and prints its summary.
+func (r *Report) record(name string, results *runResults, qps float64, inFlight int, duration int) RunReport {
+	run := results.report(name, duration)
+	run.TargetQPS = qps
+	if qps == 0 {
+		run.InFlight = inFlight
+	}
+	r.Runs = append(r.Runs, run)
+
+	fmt.Printf("Total Ops: %d\n", run.Ops)
+	fmt.Printf("Total Err: %d\n", run.Errors)
+	fmt.Printf("Average Latency: %v ms\n", results.all.mean().Milliseconds())
+	run.printLatencies()
+	return run
+}
+
+// finish adds the outcome of verifier, if any, and writes the report to output, or to a
+// timestamped JSON file if output is empty.
+func (r *Report) finish(output string, verifier *Verifier) error {
+	if r.Verification = verifier.report(); r.Verification != nil {
+		r.Verification.print()
+	}
+	if output == "" {
+		output = fmt.Sprintf("benchmark_%s.json", r.Started.Format("2006-01-02_15-04-05"))
+	}
+	if err := r.Write(output); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	fmt.Println("Report:", output)
+	return nil
+}
+
+// Write writes the report to path as JSON, or as CSV if path ends in .csv. CSV output is
+// three files: path with the latency summaries, and path with _errors and _throughput
+// before the extension, plus one with _verification when answers were verified.
+func (r *Report) Write(path string) error {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return r.writeCSV(path)
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (r *Report) writeCSV(path string) error {
+	ms := func(v float64) string { return strconv.FormatFloat(v, 'f', 3, 64) }
+	var latencies, errors, throughput [][]string
+
+	latencies = append(latencies, []string{"run", "targetQPS", "queryType", "count", "errors", "mean_ms", "p50_ms", "p90_ms", "p99_ms", "p999_ms", "max_ms"})
+	errors = append(errors, []string{"run", "error", "count"})
+	throughput = append(throughput, []string{"run", "second", "ops", "errors"})
+	for _, run := range r.Runs {
+		qps := strconv.FormatFloat(run.TargetQPS, 'f', -1, 64)
+		row := func(queryType string, s LatencySummary) []string {
+			return []string{run.Name, qps, queryType, strconv.FormatInt(s.Count, 10), strconv.Itoa(s.Errors), ms(s.MeanMs), ms(s.P50Ms), ms(s.P90Ms), ms(s.P99Ms), ms(s.P999Ms), ms(s.MaxMs)}
+		}
+		for _, queryType := range sortedKeys(run.ByQueryType) {
+			latencies = append(latencies, row(queryType, run.ByQueryType[queryType]))
+		}
+		latencies = append(latencies, row("all", run.Latency))
+		for _, message := range sortedKeys(run.ErrorsByMessage) {
+			errors = append(errors, []string{run.Name, message, strconv.Itoa(run.ErrorsByMessage[message])})
+		}
+		for _, sample := range run.Throughput {
+			throughput = append(throughput, []string{run.Name, strconv.Itoa(sample.Second), strconv.Itoa(sample.Ops), strconv.Itoa(sample.Errors)})
+		}
+	}
+
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	files := map[string][][]string{
+		path:                          latencies,
+		base + "_errors" + ".csv":     errors,
+		base + "_throughput" + ".csv": throughput,
+	}
+	if v := r.Verification; v != nil {
+		verification := [][]string{{"queryType", "checked", "mismatched", "skipped", "updates"}}
+		for _, queryType := range sortedKeys(v.ByQueryType) {
+			c := v.ByQueryType[queryType]
+			verification = append(verification, []string{queryType, strconv.Itoa(c.Checked), strconv.Itoa(c.Mismatched), strconv.Itoa(c.Skipped), strconv.Itoa(c.Updates)})
+		}
+		files[base+"_verification"+".csv"] = verification
+	}
+	for file, records := range files {
+		if err := writeCSVFile(file, records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSVFile(path string, records [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	writer.WriteAll(records)
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,78 @@
+package benchmark
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testResults() *runResults {
+	r := newRunResults()
+	r.record(Ack{latency: time.Millisecond, QueryType: "select"})
+	r.record(Ack{latency: 3 * time.Millisecond, QueryType: "select"})
+	r.record(Ack{latency: 10 * time.Millisecond, QueryType: "update"})
+	r.record(Ack{hadError: true, QueryType: "update", ErrorString: errors.New("request 12 timed out")})
+	r.record(Ack{hadError: true, QueryType: "join", ErrorString: errors.New("request 345 timed out")})
+	return r
+}
+
+func TestRunReport(t *testing.T) {
+	run := testResults().report("bench", 1)
+	if run.Ops != 3 || run.Errors != 2 || run.Latency.Count != 3 {
+		t.Errorf("report counts %d ops and %d errors over %d latencies", run.Ops, run.Errors, run.Latency.Count)
+	}
+	if s := run.ByQueryType["select"]; s.Count != 2 || s.MeanMs != 2 || s.Errors != 0 {
+		t.Errorf("select summary = %+v", s)
+	}
+	if s := run.ByQueryType["update"]; s.Count != 1 || s.Errors != 1 {
+		t.Errorf("update summary = %+v", s)
+	}
+	if s := run.ByQueryType["join"]; s.Count != 0 || s.Errors != 1 {
+		t.Errorf("join summary = %+v", s)
+	}
+	if got := run.ErrorsByMessage["request N timed out"]; got != 2 {
+		t.Errorf("errors by message = %v, want both timeouts together", run.ErrorsByMessage)
+	}
+	if len(run.Throughput) != 1 || run.Throughput[0].Ops != 3 || run.Throughput[0].Errors != 2 {
+		t.Errorf("throughput = %v", run.Throughput)
+	}
+}
+
+func TestReportWrite(t *testing.T) {
+	report := newReport()
+	report.Runs = append(report.Runs, testResults().report("bench", 1))
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "out.json")
+	if err := report.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	var read Report
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(read.Runs) != 1 || read.Runs[0].ByQueryType["select"].Count != 2 {
+		t.Errorf("read back %+v", read)
+	}
+
+	path = filepath.Join(dir, "out.csv")
+	if err := report.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	for file, rows := range map[string]int{"out.csv": 5, "out_errors.csv": 2, "out_throughput.csv": 2} {
+		f, err := os.Open(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil || len(records) != rows {
+			t.Errorf("%s has %d rows (error %v), want %d", file, len(records), err, rows)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/project/ObliSql/api/resolver"
+	"github.com/project/ObliSql/pkg/oracle"
+	"google.golang.org/grpc"
+)
+
+// maxMismatches is how many mismatching queries a verification report keeps.
+const maxMismatches = 20
+
+// Verifier checks the answers of the resolvers against a plaintext oracle holding the same
+// dataset, and counts the outcomes by query type over the whole benchmark, warmup included.
+// The oracle evaluates each query when its answer arrives, so latencies include that time.
+type Verifier struct {
+	oracle *oracle.Oracle
+
+	mu         sync.Mutex
+	counts     map[string]*VerificationCounts
+	skips      map[string]int // By reason
+	mismatches []Mismatch
+}
+
+// NewVerifier returns a verifier checking answers against o.
+func NewVerifier(o *oracle.Oracle) *Verifier {
+	return &Verifier{
+		oracle: o,
+		counts: make(map[string]*VerificationCounts),
+		skips:  make(map[string]int),
+	}
+}
+
+// Wrap makes every client check its answers with v.
+func (v *Verifier) Wrap(resolverClient *[]resolver.ResolverClient) {
+	for i, client := range *resolverClient {
+		(*resolverClient)[i] = verifyingClient{ResolverClient: client, verifier: v}
+	}
+}
+
+// VerificationCounts counts the answers to one query type. Updates are applied to the oracle
+// and not checked themselves.
+type VerificationCounts struct {
+	Checked    int `json:"checked"`
+	Mismatched int `json:"mismatched"`
+	Skipped    int `json:"skipped"`
+	Updates    int `json:"updates"`
+}
+
+// Mismatch is a query the resolver answered differently from the oracle.
+type Mismatch struct {
+	Query *resolver.ParsedQuery `json:"query"`
+	Diff  string                `json:"diff"`
+}
+
+// VerificationReport is the outcome of checking answers against the oracle.
+type VerificationReport struct {
+	ByQueryType map[string]VerificationCounts `json:"byQueryType"`
+	SkipReasons map[string]int                `json:"skipReasons"`
+	Mismatches  []Mismatch                    `json:"mismatches"` // The first maxMismatches
+}
+
+func (v *Verifier) countsOf(queryType string) *VerificationCounts {
+	c, ok := v.counts[queryType]
+	if !ok {
+		c = &VerificationCounts{}
+		v.counts[queryType] = c
+	}
+	return c
+}
+
+func (v *Verifier) record(q *resolver.ParsedQuery, outcome oracle.Outcome, detail string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c := v.countsOf(q.QueryType)
+	switch outcome {
+	case oracle.Match:
+		c.Checked++
+	case oracle.Mismatch:
+		c.Checked++
+		c.Mismatched++
+		if len(v.mismatches) < maxMismatches {
+			v.mismatches = append(v.mismatches, Mismatch{Query: q, Diff: detail})
+		}
+	case oracle.Skipped:
+		c.Skipped++
+		v.skips[detail]++
+	}
+}
+
+func (v *Verifier) recordUpdate(q *resolver.ParsedQuery, err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.countsOf(q.QueryType).Updates++
+	if err != nil {
+		v.skips[err.Error()]++
+	}
+}
+
+// report returns the outcomes so far, nil without a verifier.
+func (v *Verifier) report() *VerificationReport {
+	if v == nil {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	r := &VerificationReport{
+		ByQueryType: make(map[string]VerificationCounts, len(v.counts)),
+		SkipReasons: make(map[string]int, len(v.skips)),
+		Mismatches:  append([]Mismatch(nil), v.mismatches...),
+	}
+	for queryType, c := range v.counts {
+		r.ByQueryType[queryType] = *c
+	}
+	for reason, count := range v.skips {
+		r.SkipReasons[reason] = count
+	}
+	return r
+}
+
+func (r *VerificationReport) print() {
+	fmt.Println("-------")
+	fmt.Println("QueryType,Checked,Mismatched,Skipped,Updates")
+	for _, queryType := range sortedKeys(r.ByQueryType) {
+		c := r.ByQueryType[queryType]
+		fmt.Printf("%s,%d,%d,%d,%d\n", queryType, c.Checked, c.Mismatched, c.Skipped, c.Updates)
+	}
+	for _, reason := range sortedKeys(r.SkipReasons) {
+		fmt.Printf("Skipped,%d,%s\n", r.SkipReasons[reason], reason)
+	}
+	for _, m := range r.Mismatches {
+		fmt.Printf("Mismatch: %v: %s\n", m.Query, m.Diff)
+	}
+}
+
+// verifyingClient checks the answers of a resolver with a verifier.
+type verifyingClient struct {
+	resolver.ResolverClient
+	verifier *Verifier
+}
+
+func (c verifyingClient) ExecuteQuery(ctx context.Context, in *resolver.ParsedQuery, opts ...grpc.CallOption) (*resolver.QueryResponse, error) {
+	snapshot := c.verifier.oracle.Begin(in)
+	if in.QueryType == "update" {
+		// Updates run to completion even when the run ends, so the oracle knows if they applied
+		resp, err := c.ResolverClient.ExecuteQuery(context.WithoutCancel(ctx), in, opts...)
+		c.verifier.recordUpdate(in, c.verifier.oracle.Finish(in, snapshot, err == nil))
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return resp, err
+	}
+
+	resp, err := c.ResolverClient.ExecuteQuery(ctx, in, opts...)
+	if err != nil {
+		// Failed queries are already counted as errors by the benchmark
+		return resp, err
+	}
+	outcome, detail := c.verifier.oracle.Check(in, snapshot, resp.Keys, resp.Values)
+	c.verifier.record(in, outcome, detail)
+	return resp, err
+}
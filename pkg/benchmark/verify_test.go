@@ -0,0 +1,106 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/project/ObliSql/api/resolver"
+	"github.com/project/ObliSql/pkg/oracle"
+	resolverPkg "github.com/project/ObliSql/pkg/resolver"
+	testharness "github.com/project/ObliSql/pkg/testHarness"
+)
+
+// reviewData returns a small review table with its indexes, as the executors store it.
+func reviewData() ([]string, []string) {
+	rows := []map[string]string{
+		{"a_id": "10", "u_id": "812", "i_id": "7", "rating": "2", "rank": "nan", "comment": "first", "creation_date": "2020-12-15"},
+		{"a_id": "10", "u_id": "812", "i_id": "8", "rating": "4", "rank": "nan", "comment": "second", "creation_date": "2021-01-02"},
+		{"a_id": "11", "u_id": "900", "i_id": "7", "rating": "0", "rank": "nan", "comment": "third", "creation_date": "2021-03-04"},
+	}
+	var keys, values []string
+	index := make(map[string]string)
+	for pk, row := range rows {
+		for col, value := range row {
+			keys = append(keys, fmt.Sprintf("review/%s/%d", col, pk))
+			values = append(values, value)
+		}
+		for _, col := range []string{"u_id", "a_id", "i_id", "creation_date"} {
+			indexKey := fmt.Sprintf("review/%s_index/%s", col, row[col])
+			if pks, ok := index[indexKey]; ok {
+				index[indexKey] = fmt.Sprintf("%s,%d", pks, pk)
+			} else {
+				index[indexKey] = fmt.Sprint(pk)
+			}
+		}
+	}
+	for key, pks := range index {
+		keys = append(keys, key)
+		values = append(values, pks)
+	}
+	return keys, values
+}
+
+func TestVerifierAgainstStack(t *testing.T) {
+	const metaDataLoc = "../../metaData/metadata.txt"
+	stack, err := testharness.Start(metaDataLoc, 10, 5)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer stack.Close()
+	keys, values := reviewData()
+	if err := stack.Load(keys, values); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	metaData, err := resolverPkg.ReadMetaData(metaDataLoc)
+	if err != nil {
+		t.Fatalf("ReadMetaData() error = %v", err)
+	}
+	o := oracle.New(metaData)
+	for i, key := range keys {
+		o.Set(key, values[i])
+	}
+
+	v := NewVerifier(o)
+	clients := []resolver.ResolverClient{stack.Client}
+	v.Wrap(&clients)
+	for _, q := range []*resolver.ParsedQuery{
+		{QueryType: "select", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"u_id"}, SearchVal: []string{"812"}, SearchType: []string{"point"}},
+		{QueryType: "select", TableName: "review", ColToGet: []string{"*"}, SearchCol: []string{"a_id", "i_id"}, SearchVal: []string{"10", "7"}, SearchType: []string{"point", "point"}},
+		{QueryType: "select", TableName: "review", ColToGet: []string{"comment"}, SearchCol: []string{"creation_date"}, SearchVal: []string{"2020-12-01", "2021-01-31"}, SearchType: []string{"range"}},
+		{QueryType: "aggregate", TableName: "review", AggregateType: []string{"sum"}, ColToGet: []string{"rating"}, SearchCol: []string{"u_id"}, SearchVal: []string{"812"}},
+		{QueryType: "update", TableName: "review", ColToGet: []string{"comment"}, SearchCol: []string{"a_id", "i_id"}, SearchVal: []string{"10", "7"}, SearchType: []string{"point", "point"}, UpdateVal: []string{"updated"}},
+		{QueryType: "select", TableName: "review", ColToGet: []string{"comment"}, SearchCol: []string{"a_id"}, SearchVal: []string{"10"}, SearchType: []string{"point"}},
+	} {
+		q.ClientId = "1"
+		if _, err := clients[0].ExecuteQuery(context.Background(), q); err != nil {
+			t.Fatalf("ExecuteQuery(%v) error = %v", q, err)
+		}
+	}
+
+	r := v.report()
+	if len(r.Mismatches) > 0 {
+		t.Errorf("mismatches = %v", r.Mismatches)
+	}
+	want := map[string]VerificationCounts{"select": {Checked: 4}, "aggregate": {Checked: 1}, "update": {Updates: 1}}
+	for queryType, counts := range want {
+		if r.ByQueryType[queryType] != counts {
+			t.Errorf("%s counts = %+v, want %+v (skipped for %v)", queryType, r.ByQueryType[queryType], counts, r.SkipReasons)
+		}
+	}
+}
+
+func TestVerifierCountsMismatches(t *testing.T) {
+	v := NewVerifier(oracle.New(nil))
+	// queueResolver answers every query with one tuple
+	clients := []resolver.ResolverClient{&queueResolver{}}
+	v.Wrap(&clients)
+	for i := 0; i < 2*maxMismatches; i++ {
+		clients[0].ExecuteQuery(context.Background(), &resolver.ParsedQuery{QueryType: "select", TableName: "review"})
+	}
+
+	r := v.report()
+	if c := r.ByQueryType["select"]; c.Mismatched != 2*maxMismatches || c.Checked != 2*maxMismatches || len(r.Mismatches) != maxMismatches {
+		t.Errorf("%d wrong answers gave counts %+v and %d mismatches", 2*maxMismatches, c, len(r.Mismatches))
+	}
+}
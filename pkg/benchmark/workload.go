@@ -0,0 +1,436 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+)
+
+// Workload is a benchmark defined by a JSON file instead of a compiled-in query generator:
+//
+//	{
+//	  "name": "epinions-point",
+//	  "seed": 13091999,
+//	  "params": {
+//	    "u_id": {"file": "../benchmarkIdLists/u_id.csv", "skipHeader": true},
+//	    "i_id": {"min": 10000, "max": 30000, "distribution": "zipf", "theta": 0.99},
+//	    "day":  {"startDate": "2000-01-01", "endDate": "2060-12-31", "rangeLength": 5}
+//	  },
+//	  "queries": [
+//	    {"name": "Simple Select", "weight": 3, "query": {"queryType": "select", "tableName": "review",
+//	     "colToGet": ["rating"], "searchCol": ["u_id"], "searchVal": ["${u_id}"], "searchType": ["point"]}}
+//	  ],
+//	  "phases": [
+//	    {"name": "warmup", "duration": 10, "warmup": true},
+//	    {"name": "steady", "duration": 60},
+//	    {"name": "selects only", "duration": 30, "mix": {"Simple Select": 1}}
+//	  ]
+//	}
+//
+// A query is a resolver.ParsedQuery in its JSON form. ${name} in searchVal and updateVal is
+// replaced by a value of the parameter name, drawn once per query so a parameter used twice
+// has the same value. ${name.end} is the end of a range starting at that value, rangeLength
+// values long.
+type Workload struct {
+	Name    string               `json:"name"`
+	Seed    int64                `json:"seed"` // 0 seeds from the clock
+	Params  map[string]ParamSpec `json:"params"`
+	Queries []QueryTemplate      `json:"queries"`
+	Phases  []Phase              `json:"phases"`
+}
+
+// ParamSpec describes where the values of a parameter come from and how they are picked.
+// Values come from exactly one of a file, an inline list, an integer range or a date range.
+type ParamSpec struct {
+	File       string   `json:"file"`       // CSV file, relative to the workload file
+	Column     int      `json:"column"`     // Column of File to read
+	SkipHeader bool     `json:"skipHeader"` // Skip the first line of File
+	Values     []string `json:"values"`
+	Min        *int     `json:"min"` // Integer range, both ends included
+	Max        *int     `json:"max"`
+	StartDate  string   `json:"startDate"` // Date range as YYYY-MM-DD, both ends included
+	EndDate    string   `json:"endDate"`
+
+	// Distribution is "uniform", the default, or "zipf" where the i-th value is picked with
+	// probability proportional to 1/i^theta
+	Distribution string  `json:"distribution"`
+	Theta        float64 `json:"theta"`
+
+	// RangeLength is the number of values from a drawn value to its ${name.end}. File and
+	// inline values should be sorted for their ranges to make sense.
+	RangeLength int `json:"rangeLength"`
+}
+
+// QueryTemplate is a query of the workload with its share of the mix.
+type QueryTemplate struct {
+	Name   string                `json:"name"`
+	Weight float64               `json:"weight"` // Defaults to 1
+	Query  *resolver.ParsedQuery `json:"query"`
+}
+
+// Phase runs the workload for a while, possibly with a different mix.
+type Phase struct {
+	Name     string             `json:"name"`
+	Duration int                `json:"duration"` // Seconds, 0 runs for the benchmark duration
+	Warmup   bool               `json:"warmup"`   // Not reported
+	InFlight int                `json:"inFlight"` // Overrides the maximum in-flight requests
+	QPS      float64            `json:"qps"`      // Overrides the open-loop arrival rate
+	Requests int                `json:"requests"` // Queries generated for the phase
+	Mix      map[string]float64 `json:"mix"`      // Weights by query name, replacing the query weights
+}
+
+const (
+	warmupRequests = 50000
+	benchRequests  = 500000
+)
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)(\.end)?\}`)
+
+// LoadWorkload reads and checks a workload file. Parameter files are resolved relative to it.
+func LoadWorkload(path string) (*Workload, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w Workload
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, param := range w.Params {
+		if param.File != "" && !filepath.IsAbs(param.File) {
+			param.File = filepath.Join(filepath.Dir(path), param.File)
+			w.Params[name] = param
+		}
+	}
+	if err := w.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &w, nil
+}
+
+func (w *Workload) validate() error {
+	if len(w.Queries) == 0 {
+		return fmt.Errorf("workload has no queries")
+	}
+	names := make(map[string]bool, len(w.Queries))
+	for _, q := range w.Queries {
+		if q.Name == "" {
+			return fmt.Errorf("every query needs a name")
+		}
+		if names[q.Name] {
+			return fmt.Errorf("query %q is defined twice", q.Name)
+		}
+		names[q.Name] = true
+		if q.Query == nil {
+			return fmt.Errorf("query %q has no query", q.Name)
+		}
+		if q.Weight < 0 {
+			return fmt.Errorf("query %q has a negative weight", q.Name)
+		}
+		for _, value := range append(append([]string{}, q.Query.SearchVal...), q.Query.UpdateVal...) {
+			for _, match := range placeholder.FindAllStringSubmatch(value, -1) {
+				param, ok := w.Params[match[1]]
+				if !ok {
+					return fmt.Errorf("query %q uses undefined parameter %s", q.Name, match[1])
+				}
+				if match[2] != "" && param.RangeLength <= 0 {
+					return fmt.Errorf("query %q uses %s.end but %s has no rangeLength", q.Name, match[1], match[1])
+				}
+			}
+		}
+	}
+	for _, phase := range w.Phases {
+		for name, weight := range phase.Mix {
+			if !names[name] {
+				return fmt.Errorf("phase %q mixes in undefined query %q", phase.Name, name)
+			}
+			if weight < 0 {
+				return fmt.Errorf("phase %q gives %q a negative weight", phase.Name, name)
+			}
+		}
+	}
+	for name, param := range w.Params {
+		sources := 0
+		for _, set := range []bool{param.File != "", param.Values != nil, param.Min != nil || param.Max != nil, param.StartDate != "" || param.EndDate != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("parameter %s needs exactly one of file, values, min/max and startDate/endDate", name)
+		}
+		if param.Distribution != "" && param.Distribution != "uniform" && param.Distribution != "zipf" {
+			return fmt.Errorf("parameter %s has unknown distribution %q", name, param.Distribution)
+		}
+		if param.Theta < 0 {
+			return fmt.Errorf("parameter %s has a negative theta", name)
+		}
+	}
+	return nil
+}
+
+// paramGenerator draws the values of one parameter.
+type paramGenerator struct {
+	values      []string  // Values to pick from, nil for integer and date ranges
+	min         int       // First integer of an integer range
+	start       time.Time // First day of a date range
+	isDate      bool
+	n           int // Number of values
+	rangeLength int
+	zipf        *zipfian
+}
+
+func newParamGenerator(name string, spec ParamSpec) (*paramGenerator, error) {
+	g := &paramGenerator{rangeLength: spec.RangeLength}
+	switch {
+	case spec.File != "":
+		values, err := ReadCSVColumn(spec.File, spec.Column, spec.SkipHeader)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		g.values = values
+		g.n = len(values)
+	case spec.Values != nil:
+		g.values = spec.Values
+		g.n = len(spec.Values)
+	case spec.Min != nil || spec.Max != nil:
+		if spec.Min == nil || spec.Max == nil || *spec.Max < *spec.Min {
+			return nil, fmt.Errorf("parameter %s needs min <= max", name)
+		}
+		g.min = *spec.Min
+		g.n = *spec.Max - *spec.Min + 1
+	default:
+		start, err := time.Parse("2006-01-02", spec.StartDate)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		end, err := time.Parse("2006-01-02", spec.EndDate)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		g.start = start
+		g.isDate = true
+		g.n = int(end.Sub(start).Hours()/24) + 1
+	}
+	// Ranges start early enough to end within the values
+	if g.rangeLength > 0 {
+		g.n -= g.rangeLength - 1
+	}
+	if g.n <= 0 {
+		return nil, fmt.Errorf("parameter %s has no values to pick", name)
+	}
+	if spec.Distribution == "zipf" {
+		g.zipf = newZipfian(g.n, spec.Theta)
+	}
+	return g, nil
+}
+
+// next draws the index of a value.
+func (g *paramGenerator) next(rng *rand.Rand) int {
+	if g.zipf != nil {
+		return g.zipf.next(rng)
+	}
+	return rng.Intn(g.n)
+}
+
+// value returns the i-th value.
+func (g *paramGenerator) value(i int) string {
+	switch {
+	case g.values != nil:
+		return g.values[i]
+	case g.isDate:
+		return g.start.AddDate(0, 0, i).Format("2006-01-02")
+	default:
+		return strconv.Itoa(g.min + i)
+	}
+}
+
+// workloadGenerator turns the templates of a workload into queries.
+type workloadGenerator struct {
+	workload *Workload
+	params   map[string]*paramGenerator
+	rng      *rand.Rand
+}
+
+func newWorkloadGenerator(w *Workload, seed int64) (*workloadGenerator, error) {
+	g := &workloadGenerator{
+		workload: w,
+		params:   make(map[string]*paramGenerator, len(w.Params)),
+		rng:      rand.New(rand.NewSource(seed)),
+	}
+	for name, spec := range w.Params {
+		param, err := newParamGenerator(name, spec)
+		if err != nil {
+			return nil, err
+		}
+		g.params[name] = param
+	}
+	return g, nil
+}
+
+// generate returns n queries mixed by weight, the phase mix replacing the query weights if set.
+func (g *workloadGenerator) generate(n int, mix map[string]float64) ([]Query, error) {
+	weights := make([]float64, len(g.workload.Queries))
+	total := 0.0
+	for i, q := range g.workload.Queries {
+		switch {
+		case mix != nil:
+			weights[i] = mix[q.Name]
+		case q.Weight == 0:
+			weights[i] = 1
+		default:
+			weights[i] = q.Weight
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("the mix gives every query a weight of 0")
+	}
+
+	queries := make([]Query, 0, n)
+	for len(queries) < n {
+		pick := g.rng.Float64() * total
+		i := 0
+		for ; i < len(weights)-1; i++ {
+			if pick < weights[i] {
+				break
+			}
+			pick -= weights[i]
+		}
+		if weights[i] == 0 { // Rounding ran past the last weighted query
+			continue
+		}
+		queries = append(queries, g.instantiate(&g.workload.Queries[i]))
+	}
+	return queries, nil
+}
+
+// instantiate fills in the parameters of a template.
+func (g *workloadGenerator) instantiate(t *QueryTemplate) Query {
+	drawn := make(map[string]int)
+	substitute := func(values []string) []string {
+		if values == nil {
+			return nil
+		}
+		out := make([]string, len(values))
+		for i, value := range values {
+			out[i] = placeholder.ReplaceAllStringFunc(value, func(match string) string {
+				parts := placeholder.FindStringSubmatch(match)
+				param := g.params[parts[1]]
+				index, ok := drawn[parts[1]]
+				if !ok {
+					index = param.next(g.rng)
+					drawn[parts[1]] = index
+				}
+				if parts[2] != "" {
+					index += param.rangeLength - 1
+				}
+				return param.value(index)
+			})
+		}
+		return out
+	}
+
+	q := t.Query
+	clientID := q.ClientId
+	if clientID == "" {
+		clientID = "1"
+	}
+	return Query{
+		name: t.Name,
+		requestQuery: &resolver.ParsedQuery{
+			ClientId:      clientID,
+			QueryType:     q.QueryType,
+			TableName:     q.TableName,
+			ColToGet:      q.ColToGet,
+			SearchCol:     q.SearchCol,
+			SearchVal:     substitute(q.SearchVal),
+			SearchType:    q.SearchType,
+			AggregateType: q.AggregateType,
+			OrderBy:       q.OrderBy,
+			JoinColumns:   q.JoinColumns,
+			UpdateVal:     substitute(q.UpdateVal),
+		},
+	}
+}
+
+// phases returns the phases to run, a warmup and a measured phase if the workload has none.
+func (w *Workload) phases() []Phase {
+	if len(w.Phases) > 0 {
+		return w.Phases
+	}
+	return []Phase{
+		{Name: "warmup", Duration: 10, Warmup: true},
+		{Name: "benchmark"},
+	}
+}
+
+// runWorkload runs the phases of a workload in order. With a sweep configured, every measured
+// phase is a sweep.
+func runWorkload(resolverClient *[]resolver.ResolverClient, w *Workload, inFlight int, timeDuration int, openLoop OpenLoop, report *Report) {
+	seed := w.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Workload: %s (seed %d)\n", w.Name, seed)
+	generator, err := newWorkloadGenerator(w, seed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, phase := range w.phases() {
+		duration := phase.Duration
+		if duration == 0 {
+			duration = timeDuration
+		}
+		requests := phase.Requests
+		if requests == 0 {
+			requests = benchRequests
+			if phase.Warmup {
+				requests = warmupRequests
+			}
+		}
+		phaseInFlight := inFlight
+		if phase.InFlight > 0 {
+			phaseInFlight = phase.InFlight
+		}
+
+		queries, err := generator.generate(requests, phase.Mix)
+		if err != nil {
+			log.Fatalf("Phase %s: %v", phase.Name, err)
+		}
+		qps := openLoop.QPS
+		if phase.Warmup {
+			qps = openLoop.warmupQPS()
+		}
+		if phase.QPS > 0 {
+			qps = phase.QPS
+		}
+
+		fmt.Println("-------")
+		if !phase.Warmup && openLoop.sweeping() {
+			fmt.Printf("Running Phase %s! Sweep of %d second steps\n", phase.Name, duration)
+			runSweep(resolverClient, &queries, openLoop, duration, report, phase.Name)
+			continue
+		}
+		if qps > 0 {
+			fmt.Printf("Running Phase %s! %d seconds, %.1f QPS\n", phase.Name, duration, qps)
+		} else {
+			fmt.Printf("Running Phase %s! %d seconds, %d in-flight\n", phase.Name, duration, phaseInFlight)
+		}
+		results := runLoad(resolverClient, &queries, phaseInFlight, openLoop, qps, duration, phase.Warmup)
+		if phase.Warmup {
+			fmt.Printf("Warmup Done! %d %d\n", results.ops, results.errs)
+			continue
+		}
+		report.record(phase.Name, results, qps, phaseInFlight, duration)
+	}
+}
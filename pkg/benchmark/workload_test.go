@@ -0,0 +1,166 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func parseWorkload(t *testing.T, text string) *Workload {
+	t.Helper()
+	var w Workload
+	if err := json.Unmarshal([]byte(text), &w); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	return &w
+}
+
+func TestExampleWorkloads(t *testing.T) {
+	files, _ := filepath.Glob("workloads/*.json")
+	if len(files) == 0 {
+		t.Fatal("no example workloads")
+	}
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			w, err := LoadWorkload(file)
+			if err != nil {
+				t.Fatalf("LoadWorkload() error = %v", err)
+			}
+			g, err := newWorkloadGenerator(w, 1)
+			if err != nil {
+				t.Fatalf("newWorkloadGenerator() error = %v", err)
+			}
+			queries, err := g.generate(200, nil)
+			if err != nil {
+				t.Fatalf("generate() error = %v", err)
+			}
+			for _, q := range queries {
+				for _, value := range append(q.requestQuery.SearchVal, q.requestQuery.UpdateVal...) {
+					if value == "" || strings.Contains(value, "${") {
+						t.Fatalf("%s has unfilled value %q", q.name, value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWorkloadParameters(t *testing.T) {
+	w := parseWorkload(t, `{
+		"params": {
+			"id": {"values": ["a", "b", "c", "d"], "rangeLength": 2},
+			"n": {"min": 5, "max": 5},
+			"day": {"startDate": "2020-02-28", "endDate": "2020-02-28"}
+		},
+		"queries": [{"name": "q", "query": {"queryType": "select",
+			"searchVal": ["${id}", "${id.end}", "${id}", "n=${n}"], "updateVal": ["${day}"]}}]
+	}`)
+	if err := w.validate(); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+	g, err := newWorkloadGenerator(w, 1)
+	if err != nil {
+		t.Fatalf("newWorkloadGenerator() error = %v", err)
+	}
+	queries, _ := g.generate(100, nil)
+	next := map[string]string{"a": "b", "b": "c", "c": "d"}
+	for _, q := range queries {
+		got := q.requestQuery.SearchVal
+		if next[got[0]] != got[1] || got[2] != got[0] || got[3] != "n=5" {
+			t.Fatalf("searchVal = %v, want a range of two values, the start again and n=5", got)
+		}
+		if q.requestQuery.UpdateVal[0] != "2020-02-28" || q.requestQuery.ClientId != "1" {
+			t.Fatalf("query = %v", q.requestQuery)
+		}
+	}
+}
+
+func TestWorkloadMix(t *testing.T) {
+	w := parseWorkload(t, `{
+		"queries": [
+			{"name": "a", "weight": 3, "query": {"queryType": "select"}},
+			{"name": "b", "query": {"queryType": "select"}},
+			{"name": "c", "weight": 0, "query": {"queryType": "select"}}
+		]
+	}`)
+	g, err := newWorkloadGenerator(w, 1)
+	if err != nil {
+		t.Fatalf("newWorkloadGenerator() error = %v", err)
+	}
+
+	count := func(queries []Query) map[string]int {
+		counts := make(map[string]int)
+		for _, q := range queries {
+			counts[q.name]++
+		}
+		return counts
+	}
+
+	// A weight of 0 in the template defaults to 1
+	counts := count(mustGenerate(t, g, 10000, nil))
+	if counts["a"] < 5500 || counts["a"] > 6500 || counts["b"] < 1500 || counts["c"] < 1500 {
+		t.Errorf("mix of weights 3, 1 and 1 gave %v", counts)
+	}
+
+	counts = count(mustGenerate(t, g, 1000, map[string]float64{"b": 1}))
+	if counts["b"] != 1000 {
+		t.Errorf("phase mix of only b gave %v", counts)
+	}
+}
+
+func mustGenerate(t *testing.T, g *workloadGenerator, n int, mix map[string]float64) []Query {
+	t.Helper()
+	queries, err := g.generate(n, mix)
+	if err != nil {
+		t.Fatalf("generate() error = %v", err)
+	}
+	return queries
+}
+
+func TestWorkloadValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{"no queries", `{}`},
+		{"undefined parameter", `{"queries": [{"name": "q", "query": {"searchVal": ["${id}"]}}]}`},
+		{"end without range", `{"params": {"id": {"values": ["1"]}}, "queries": [{"name": "q", "query": {"searchVal": ["${id.end}"]}}]}`},
+		{"two sources", `{"params": {"id": {"values": ["1"], "min": 1, "max": 2}}, "queries": [{"name": "q", "query": {}}]}`},
+		{"unknown distribution", `{"params": {"id": {"values": ["1"], "distribution": "normal"}}, "queries": [{"name": "q", "query": {}}]}`},
+		{"duplicate query", `{"queries": [{"name": "q", "query": {}}, {"name": "q", "query": {}}]}`},
+		{"undefined query in mix", `{"queries": [{"name": "q", "query": {}}], "phases": [{"mix": {"r": 1}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := parseWorkload(t, tt.spec).validate(); err == nil {
+				t.Error("validate() succeeded")
+			}
+		})
+	}
+}
+
+func TestZipfian(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	counts := make([]int, 100)
+	z := newZipfian(len(counts), 0.99)
+	for i := 0; i < 100000; i++ {
+		counts[z.next(rng)]++
+	}
+	// With theta near 1 the first rank is picked about 100 times as often as the last
+	if counts[0] < 20*counts[99] || counts[0] < counts[1] {
+		t.Errorf("theta 0.99 gave counts %d, %d ... %d", counts[0], counts[1], counts[99])
+	}
+
+	counts = make([]int, 100)
+	z = newZipfian(len(counts), 0)
+	for i := 0; i < 100000; i++ {
+		counts[z.next(rng)]++
+	}
+	for i, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Errorf("theta 0 picked rank %d %d times, want about 1000", i, count)
+		}
+	}
+}
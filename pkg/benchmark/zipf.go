@@ -0,0 +1,35 @@
+package benchmark
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// zipfian picks ranks in [0, n) with the probability of rank i proportional to 1/(i+1)^theta,
+// so rank 0 is the most popular. Unlike rand.Zipf it accepts any theta >= 0, including the
+// YCSB skews below 1 the Skewed id lists were generated with; theta 0 is uniform.
+type zipfian struct {
+	cdf []float64
+}
+
+func newZipfian(n int, theta float64) *zipfian {
+	cdf := make([]float64, n)
+	sum := 0.0
+	for i := range cdf {
+		sum += 1 / math.Pow(float64(i+1), theta)
+		cdf[i] = sum
+	}
+	for i := range cdf {
+		cdf[i] /= sum
+	}
+	return &zipfian{cdf: cdf}
+}
+
+func (z *zipfian) next(rng *rand.Rand) int {
+	i := sort.SearchFloat64s(z.cdf, rng.Float64())
+	if i >= len(z.cdf) { // Rounding in the last sum
+		i = len(z.cdf) - 1
+	}
+	return i
+}
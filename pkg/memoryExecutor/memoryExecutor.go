@@ -0,0 +1,163 @@
+package memoryexecutor
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	batcher "github.com/project/ObliSql/pkg/batchManager"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExecutorType is the name the in-memory executor is registered under with the batcher.
+const ExecutorType = "memory"
+
+// Executor is an in-process, in-memory executor. It keeps no privacy guarantees and exists as
+// a reference for the behaviour of the real executors, for tests and local development without
+// Redis or the Waffle proxy. Accesses of a batch are applied in order, so a GET after a PUT of
+// the same key in one batch sees the new value, and a GET of a missing key returns "-1", the
+// same as executorPlaintext.
+type Executor struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// New returns an empty executor.
+func New() *Executor {
+	return &Executor{values: make(map[string]string)}
+}
+
+// InitDB replaces the contents of the executor with keys and values.
+func (e *Executor) InitDB(keys []string, values []string) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("got %d keys but %d values", len(keys), len(values))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.values = make(map[string]string, len(keys))
+	for i, key := range keys {
+		e.values[key] = values[i]
+	}
+	return nil
+}
+
+// LoadTrace replaces the contents of the executor with the SET lines of a tracefile.
+func (e *Executor) LoadTrace(tracefile string) error {
+	keys, values, err := ReadTrace(tracefile)
+	if err != nil {
+		return err
+	}
+	return e.InitDB(keys, values)
+}
+
+// Get returns the value of key, for checking the contents of the executor in tests.
+func (e *Executor) Get(key string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	value, ok := e.values[key]
+	return value, ok
+}
+
+// Len returns the number of keys held.
+func (e *Executor) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.values)
+}
+
+// MixBatch executes a batch of accesses, a PUT where values[i] is not empty and a GET
+// otherwise, and returns "key:value" for each of them like the executor clients do.
+func (e *Executor) MixBatch(keys []string, values []string, batchID int64) ([]string, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("batch %d has %d keys but %d values", batchID, len(keys), len(values))
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ret := make([]string, len(keys))
+	for i, key := range keys {
+		if values[i] != "" {
+			e.values[key] = values[i]
+		}
+		value, ok := e.values[key]
+		if !ok {
+			value = "-1"
+		}
+		ret[i] = key + ":" + value
+	}
+	return ret, nil
+}
+
+// ReadTrace reads the keys and values of the SET lines of a tracefile.
+func ReadTrace(tracefile string) ([]string, []string, error) {
+	file, err := os.Open(tracefile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open tracefile: %w", err)
+	}
+	defer file.Close()
+
+	const maxBufferSize = 1024 * 1024 // 1MB
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, maxBufferSize), maxBufferSize)
+
+	var keys, values []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "SET") {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			continue // Skip lines that don't have exactly 3 parts
+		}
+		keys = append(keys, parts[1])
+		values = append(values, parts[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read tracefile: %w", err)
+	}
+	return keys, values, nil
+}
+
+var (
+	storesLock sync.Mutex
+	stores     = make(map[string]*Executor)
+)
+
+// Store returns the executor standing in for the one at host:port, creating it if needed.
+// Every client the batcher opens for an address shares it, as clients of a real executor
+// share its database.
+func Store(host string, port int) *Executor {
+	addr := host + ":" + strconv.Itoa(port)
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	store, ok := stores[addr]
+	if !ok {
+		store = New()
+		stores[addr] = store
+	}
+	return store
+}
+
+// Reset forgets every executor created by Store.
+func Reset() {
+	storesLock.Lock()
+	defer storesLock.Unlock()
+	stores = make(map[string]*Executor)
+}
+
+func init() {
+	// Option tracefile loads the SET lines of a tracefile when the executor is first created.
+	batcher.RegisterExecutor(ExecutorType, func(host string, port int, options map[string]string, _ trace.Tracer) (batcher.ExecutorClient, error) {
+		store := Store(host, port)
+		if tracefile := options["tracefile"]; tracefile != "" && store.Len() == 0 {
+			if err := store.LoadTrace(tracefile); err != nil {
+				return nil, err
+			}
+		}
+		return store, nil
+	})
+}
@@ -0,0 +1,77 @@
+package memoryexecutor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	batcher "github.com/project/ObliSql/pkg/batchManager"
+)
+
+func TestMixBatch(t *testing.T) {
+	e := New()
+	if err := e.InitDB([]string{"a", "b"}, []string{"1", "2"}); err != nil {
+		t.Fatalf("InitDB() error = %v", err)
+	}
+
+	// A GET before a PUT sees the old value, a GET after it the new one
+	keys := []string{"a", "a", "a", "missing", "c", "c", "b"}
+	values := []string{"", "3", "", "", "4", "", ""}
+	got, err := e.MixBatch(keys, values, 1)
+	if err != nil {
+		t.Fatalf("MixBatch() error = %v", err)
+	}
+	want := []string{"a:1", "a:3", "a:3", "missing:-1", "c:4", "c:4", "b:2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MixBatch() = %v, want %v", got, want)
+	}
+
+	got, _ = e.MixBatch([]string{"a", "c"}, []string{"", ""}, 2)
+	want = []string{"a:3", "c:4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MixBatch() in the next batch = %v, want %v", got, want)
+	}
+
+	if _, err := e.MixBatch([]string{"a"}, nil, 3); err == nil {
+		t.Error("MixBatch() with fewer values than keys succeeded")
+	}
+}
+
+func TestInitDBReplaces(t *testing.T) {
+	e := New()
+	e.InitDB([]string{"a"}, []string{"1"})
+	e.InitDB([]string{"b"}, []string{"2"})
+	if _, ok := e.Get("a"); ok {
+		t.Error("InitDB() kept a key of the previous contents")
+	}
+	if value, _ := e.Get("b"); value != "2" {
+		t.Errorf("Get(b) = %q, want 2", value)
+	}
+}
+
+func TestRegisteredClientsShareStore(t *testing.T) {
+	defer Reset()
+	tracefile := filepath.Join(t.TempDir(), "trace.txt")
+	if err := os.WriteFile(tracefile, []byte("SET a 1\nGET a\nSET b two words\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	options := map[string]string{"tracefile": tracefile}
+
+	first, err := batcher.NewExecutorClient("Memory", "localhost", 1, options, nil)
+	if err != nil {
+		t.Fatalf("NewExecutorClient() error = %v", err)
+	}
+	second, _ := batcher.NewExecutorClient("memory", "localhost", 1, options, nil)
+	other, _ := batcher.NewExecutorClient("memory", "localhost", 2, nil, nil)
+
+	first.MixBatch([]string{"a"}, []string{"3"}, 1)
+	got, _ := second.MixBatch([]string{"a", "b"}, []string{"", ""}, 2)
+	if want := []string{"a:3", "b:two words"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("second client of the same address got %v, want %v", got, want)
+	}
+	got, _ = other.MixBatch([]string{"a"}, []string{""}, 3)
+	if want := []string{"a:-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("client of another address got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,592 @@
+// Package oracle evaluates resolver queries over a plaintext copy of the dataset, so the
+// answers of a deployment can be checked against it.
+package oracle
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/project/ObliSql/api/resolver"
+	memoryexecutor "github.com/project/ObliSql/pkg/memoryExecutor"
+	resolverPkg "github.com/project/ObliSql/pkg/resolver"
+)
+
+// ErrUnsupported is returned for queries the oracle cannot evaluate.
+var ErrUnsupported = errors.New("query not supported by the oracle")
+
+// Oracle holds the rows of every table in memory and evaluates queries on them the way the
+// resolver is meant to. Updates are applied when they finish. Reads racing an update of a
+// table they read cannot be checked, and neither can reads of values written by updates that
+// raced each other, since the deployment may have applied those in either order; both are
+// skipped.
+type Oracle struct {
+	mu            sync.Mutex
+	metaData      map[string]resolverPkg.MetaData
+	rows          map[string]map[string]map[string]string // table -> pk -> column -> value
+	pending       map[string]int                          // Updates in flight by table
+	version       map[string]int64                        // Updates started by table
+	uncertain     map[string]map[string]bool              // table -> pk/column written by racing updates
+	uncertainCols map[string]map[string]bool              // table -> columns with uncertain values
+	diverged      map[string]bool                         // Tables with an update that failed
+
+	// Point conditions look rows up by value, the index of a column is built when it is
+	// first searched: table -> column -> value -> pks
+	indexes map[string]map[string]map[string]map[string]bool
+}
+
+// New returns an empty oracle for the tables in metaData.
+func New(metaData map[string]resolverPkg.MetaData) *Oracle {
+	return &Oracle{
+		metaData:      metaData,
+		rows:          make(map[string]map[string]map[string]string),
+		pending:       make(map[string]int),
+		version:       make(map[string]int64),
+		uncertain:     make(map[string]map[string]bool),
+		uncertainCols: make(map[string]map[string]bool),
+		diverged:      make(map[string]bool),
+		indexes:       make(map[string]map[string]map[string]map[string]bool),
+	}
+}
+
+// Load returns an oracle with the tables described in the metadata file at metaDataLoc and
+// the rows of the tracefile the executors were initialized from.
+func Load(metaDataLoc, tracefile string) (*Oracle, error) {
+	metaData, err := resolverPkg.ReadMetaData(metaDataLoc)
+	if err != nil {
+		return nil, err
+	}
+	keys, values, err := memoryexecutor.ReadTrace(tracefile)
+	if err != nil {
+		return nil, err
+	}
+	o := New(metaData)
+	for i, key := range keys {
+		o.Set(key, values[i])
+	}
+	return o, nil
+}
+
+// Set stores the value of a table/column/pk key. Index entries (table/column_index/value) are
+// ignored, the oracle finds rows by scanning.
+func (o *Oracle) Set(key, value string) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || strings.HasSuffix(parts[1], "_index") {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.set(parts[0], parts[2], parts[1], resolverPkg.UnpadValue(value))
+}
+
+func (o *Oracle) set(table, pk, column, value string) {
+	if o.rows[table] == nil {
+		o.rows[table] = make(map[string]map[string]string)
+	}
+	if o.rows[table][pk] == nil {
+		o.rows[table][pk] = make(map[string]string)
+	}
+	if index := o.indexes[table][column]; index != nil {
+		if old, ok := o.rows[table][pk][column]; ok {
+			delete(index[old], pk)
+		}
+		if index[value] == nil {
+			index[value] = make(map[string]bool)
+		}
+		index[value][pk] = true
+	}
+	o.rows[table][pk][column] = value
+}
+
+// index returns the pks of table by value of column, building it on first use.
+func (o *Oracle) index(table, column string) map[string]map[string]bool {
+	if index := o.indexes[table][column]; index != nil {
+		return index
+	}
+	index := make(map[string]map[string]bool)
+	for pk, row := range o.rows[table] {
+		if value, ok := row[column]; ok {
+			if index[value] == nil {
+				index[value] = make(map[string]bool)
+			}
+			index[value][pk] = true
+		}
+	}
+	if o.indexes[table] == nil {
+		o.indexes[table] = make(map[string]map[string]map[string]bool)
+	}
+	o.indexes[table][column] = index
+	return index
+}
+
+// Snapshot is the state of the tables a query reads when it was sent.
+type Snapshot struct {
+	tables   []string
+	versions []int64
+	clean    bool // No other update of the tables was in flight
+}
+
+func tables(q *resolver.ParsedQuery) []string {
+	return strings.Split(q.TableName, ",")
+}
+
+// Begin must be called before q is sent. For an update it marks the table as changing until
+// Finish, for a read it records the state the result is checked against.
+func (o *Oracle) Begin(q *resolver.ParsedQuery) Snapshot {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := Snapshot{tables: tables(q), clean: true}
+	for _, table := range s.tables {
+		if q.QueryType == "update" {
+			o.pending[table]++
+			o.version[table]++
+		}
+		if o.pending[table] > 0 && q.QueryType != "update" || o.pending[table] > 1 {
+			s.clean = false
+		}
+		s.versions = append(s.versions, o.version[table])
+	}
+	return s
+}
+
+// Finish applies an update once it is answered, s is what Begin returned for it. An update
+// that failed may or may not have been applied, so its table is no longer checked, and neither
+// is the table of an update the oracle cannot apply.
+func (o *Oracle) Finish(q *resolver.ParsedQuery, s Snapshot, applied bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Another update of the table was in flight when this one started or started since
+	raced := !s.clean
+	for i, table := range s.tables {
+		o.pending[table]--
+		raced = raced || o.version[table] != s.versions[i]
+	}
+	if !applied {
+		o.diverge(s.tables)
+		return nil
+	}
+	if len(q.ColToGet) != 1 || q.ColToGet[0] == "*" || len(q.UpdateVal) == 0 {
+		o.diverge(s.tables)
+		return fmt.Errorf("%w: update of %v", ErrUnsupported, q.ColToGet)
+	}
+	pks, err := o.match(q.TableName, q.SearchCol, q.SearchType, q.SearchVal)
+	if err != nil {
+		o.diverge(s.tables)
+		return err
+	}
+	for _, pk := range pks {
+		o.set(q.TableName, pk, q.ColToGet[0], q.UpdateVal[0])
+		if raced {
+			if o.uncertain[q.TableName] == nil {
+				o.uncertain[q.TableName] = make(map[string]bool)
+				o.uncertainCols[q.TableName] = make(map[string]bool)
+			}
+			o.uncertain[q.TableName][pk+"/"+q.ColToGet[0]] = true
+			o.uncertainCols[q.TableName][q.ColToGet[0]] = true
+		}
+	}
+	return nil
+}
+
+func (o *Oracle) diverge(tables []string) {
+	for _, table := range tables {
+		o.diverged[table] = true
+	}
+}
+
+// touchesUncertain reports whether q reads a value written by racing updates: any value of a
+// column it searches or aggregates, or the values of its result keys.
+func (o *Oracle) touchesUncertain(q *resolver.ParsedQuery, keys []string) bool {
+	columns := q.SearchCol
+	if q.QueryType == "aggregate" {
+		columns = append(append([]string{}, q.SearchCol...), q.ColToGet...)
+	}
+	for _, table := range tables(q) {
+		for _, column := range columns {
+			if o.uncertainCols[table][column] || o.uncertainCols[table][strings.TrimPrefix(column, table+".")] {
+				return true
+			}
+		}
+	}
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 3)
+		if len(parts) == 3 && o.uncertain[parts[0]][parts[2]+"/"+parts[1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome is the result of checking a response.
+type Outcome int
+
+const (
+	Match    Outcome = iota
+	Mismatch         // The response differs from the oracle
+	Skipped          // The response cannot be checked
+)
+
+// Check compares the response to a read sent after Begin returned s with the oracle. For a
+// mismatch it also returns a description of the difference, for a skip the reason.
+func (o *Oracle) Check(q *resolver.ParsedQuery, s Snapshot, keys, values []string) (Outcome, string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, table := range s.tables {
+		if o.diverged[table] {
+			return Skipped, "table diverged from the oracle"
+		}
+	}
+	if !s.clean {
+		return Skipped, "concurrent update"
+	}
+	for i, table := range s.tables {
+		if o.version[table] != s.versions[i] {
+			return Skipped, "concurrent update"
+		}
+	}
+
+	wantKeys, wantValues, err := o.evaluate(q)
+	if err != nil {
+		return Skipped, err.Error()
+	}
+	if o.touchesUncertain(q, wantKeys) || o.touchesUncertain(q, keys) {
+		return Skipped, "value written by racing updates"
+	}
+	var diff string
+	if q.QueryType == "aggregate" {
+		diff = diffAggregates(wantValues, values)
+	} else {
+		diff = diffTuples(wantKeys, wantValues, keys, values)
+	}
+	if diff != "" {
+		return Mismatch, diff
+	}
+	return Match, ""
+}
+
+// Evaluate returns the keys and values the resolver should answer q with.
+func (o *Oracle) Evaluate(q *resolver.ParsedQuery) ([]string, []string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.evaluate(q)
+}
+
+func (o *Oracle) evaluate(q *resolver.ParsedQuery) ([]string, []string, error) {
+	switch q.QueryType {
+	case "select":
+		return o.selectRows(q.TableName, q.ColToGet, q.SearchCol, q.SearchType, q.SearchVal)
+	case "join":
+		return o.join(q)
+	case "aggregate":
+		return o.aggregate(q)
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupported, q.QueryType)
+	}
+}
+
+// condition is one search condition of a query. Range conditions are inclusive and compare
+// as numbers for int columns and as YYYY-MM-DD strings for date columns.
+type condition struct {
+	column     string
+	kind       string // point, int or date
+	start, end string
+}
+
+func (c condition) holds(value string) bool {
+	switch c.kind {
+	case "int":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		start, errStart := strconv.ParseInt(c.start, 10, 64)
+		end, errEnd := strconv.ParseInt(c.end, 10, 64)
+		return errStart == nil && errEnd == nil && start <= v && v <= end
+	case "date":
+		return c.start <= value && value <= c.end
+	default:
+		return value == c.start
+	}
+}
+
+// match returns the pks of table whose rows satisfy every condition. A point condition takes
+// one value, a range condition the start and end of its range.
+func (o *Oracle) match(table string, searchCol, searchType, searchVal []string) ([]string, error) {
+	if len(searchCol) != len(searchType) {
+		return nil, fmt.Errorf("%d search columns with %d search types", len(searchCol), len(searchType))
+	}
+	conditions := make([]condition, len(searchCol))
+	next := 0
+	for i, column := range searchCol {
+		c := condition{column: column, kind: searchType[i]}
+		values := 1
+		if c.kind == "range" {
+			values = 2
+			c.kind = o.metaData[table].ColTypes[column]
+			if c.kind != "int" && c.kind != "date" {
+				return nil, fmt.Errorf("%w: range on %s column", ErrUnsupported, c.kind)
+			}
+		} else if c.kind != "point" {
+			return nil, fmt.Errorf("%w: search type %s", ErrUnsupported, c.kind)
+		}
+		if next+values > len(searchVal) {
+			return nil, fmt.Errorf("not enough search values for %v", searchCol)
+		}
+		c.start, c.end = searchVal[next], searchVal[next+values-1]
+		next += values
+		conditions[i] = c
+	}
+
+	var pks []string
+	check := func(pk string) {
+		row := o.rows[table][pk]
+		for _, c := range conditions {
+			if value, ok := row[c.column]; !ok || !c.holds(value) {
+				return
+			}
+		}
+		pks = append(pks, pk)
+	}
+	for _, c := range conditions {
+		if c.kind == "point" {
+			for pk := range o.index(table, c.column)[c.start] {
+				check(pk)
+			}
+			return pks, nil
+		}
+	}
+	for pk := range o.rows[table] {
+		check(pk)
+	}
+	return pks, nil
+}
+
+// columns returns the columns to fetch, all of them for *.
+func (o *Oracle) columns(table string, colToGet []string) []string {
+	if len(colToGet) > 0 && colToGet[0] == "*" {
+		return o.metaData[table].ColNames
+	}
+	return colToGet
+}
+
+// fetch returns the table/column/pk keys and values of the given rows.
+func (o *Oracle) fetch(table string, pks []string, columns []string) ([]string, []string) {
+	var keys, values []string
+	for _, pk := range pks {
+		for _, column := range columns {
+			if value, ok := o.rows[table][pk][column]; ok {
+				keys = append(keys, table+"/"+column+"/"+pk)
+				values = append(values, value)
+			}
+		}
+	}
+	return keys, values
+}
+
+func (o *Oracle) selectRows(table string, colToGet, searchCol, searchType, searchVal []string) ([]string, []string, error) {
+	pks, err := o.match(table, searchCol, searchType, searchVal)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, values := o.fetch(table, pks, o.columns(table, colToGet))
+	return keys, values, nil
+}
+
+// join evaluates an equi-join of two tables. Search columns are table.column with a point
+// value; when there are fewer than tables, the last value also applies to the same column of
+// the remaining tables. Columns to get are table.column or table.*.
+func (o *Oracle) join(q *resolver.ParsedQuery) ([]string, []string, error) {
+	names := tables(q)
+	if len(names) != 2 || len(q.JoinColumns) != 2 {
+		return nil, nil, fmt.Errorf("%w: join of %s on %v", ErrUnsupported, q.TableName, q.JoinColumns)
+	}
+	searchCol := make(map[string][]string)
+	searchVal := make(map[string][]string)
+	for i, col := range q.SearchCol {
+		table, column, ok := strings.Cut(col, ".")
+		if !ok || i >= len(q.SearchVal) {
+			return nil, nil, fmt.Errorf("search column %s is not table.column", col)
+		}
+		searchCol[table] = append(searchCol[table], column)
+		searchVal[table] = append(searchVal[table], q.SearchVal[i])
+	}
+	if n := len(q.SearchCol); n > 0 && n < len(names) {
+		_, column, _ := strings.Cut(q.SearchCol[n-1], ".")
+		for _, table := range names[n:] {
+			searchCol[table] = append(searchCol[table], column)
+			searchVal[table] = append(searchVal[table], q.SearchVal[len(q.SearchVal)-1])
+		}
+	}
+
+	candidates := make([][]string, len(names))
+	for i, table := range names {
+		searchType := make([]string, len(searchCol[table]))
+		for j := range searchType {
+			searchType[j] = "point"
+		}
+		pks, err := o.match(table, searchCol[table], searchType, searchVal[table])
+		if err != nil {
+			return nil, nil, err
+		}
+		candidates[i] = pks
+	}
+
+	joined := []map[string]bool{make(map[string]bool), make(map[string]bool)}
+	for _, left := range candidates[0] {
+		leftValue, ok := o.rows[names[0]][left][q.JoinColumns[0]]
+		if !ok {
+			continue
+		}
+		for _, right := range candidates[1] {
+			if rightValue, ok := o.rows[names[1]][right][q.JoinColumns[1]]; ok && rightValue == leftValue {
+				joined[0][left] = true
+				joined[1][right] = true
+			}
+		}
+	}
+
+	var keys, values []string
+	for _, col := range q.ColToGet {
+		table, column, ok := strings.Cut(col, ".")
+		i := indexOf(names, table)
+		if !ok || i < 0 {
+			return nil, nil, fmt.Errorf("column %s is not table.column of a joined table", col)
+		}
+		pks := make([]string, 0, len(joined[i]))
+		for pk := range joined[i] {
+			pks = append(pks, pk)
+		}
+		k, v := o.fetch(table, pks, o.columns(table, []string{column}))
+		keys = append(keys, k...)
+		values = append(values, v...)
+	}
+	return keys, values, nil
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// aggregate evaluates each aggregate over a point selection of its column, or for avg over a
+// join, over the joined values.
+func (o *Oracle) aggregate(q *resolver.ParsedQuery) ([]string, []string, error) {
+	keys := make([]string, len(q.AggregateType))
+	values := make([]string, len(q.AggregateType))
+	for i, aggregateType := range q.AggregateType {
+		var selected []string
+		if len(tables(q)) > 1 {
+			if aggregateType != "avg" {
+				return nil, nil, fmt.Errorf("%w: %s over a join", ErrUnsupported, aggregateType)
+			}
+			join := &resolver.ParsedQuery{QueryType: "join", TableName: q.TableName, ColToGet: q.ColToGet, SearchCol: q.SearchCol, SearchVal: q.SearchVal, JoinColumns: q.JoinColumns}
+			_, v, err := o.join(join)
+			if err != nil {
+				return nil, nil, err
+			}
+			selected = v
+		} else {
+			if i >= len(q.ColToGet) || i >= len(q.SearchCol) || i >= len(q.SearchVal) {
+				return nil, nil, fmt.Errorf("aggregate %d has no column or condition", i)
+			}
+			_, v, err := o.selectRows(q.TableName, q.ColToGet[i:i+1], q.SearchCol[i:i+1], []string{"point"}, q.SearchVal[i:i+1])
+			if err != nil {
+				return nil, nil, err
+			}
+			selected = v
+		}
+
+		sum := 0.0
+		for _, v := range selected {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %s of non-numeric value %q", ErrUnsupported, aggregateType, v)
+			}
+			sum += f
+		}
+		var result float64
+		switch aggregateType {
+		case "sum":
+			result = sum
+		case "count":
+			result = float64(len(selected))
+		case "avg":
+			if len(selected) > 0 {
+				result = sum / float64(len(selected))
+			}
+		default:
+			return nil, nil, fmt.Errorf("%w: %s aggregate", ErrUnsupported, aggregateType)
+		}
+		values[i] = strconv.FormatFloat(result, 'f', -1, 64)
+	}
+	return keys, values, nil
+}
+
+// maxListed is how many differing tuples a diff names.
+const maxListed = 3
+
+// diffTuples compares the key/value pairs of two responses as sets, ignoring order and
+// repeated pairs. It returns "" if they are equal.
+func diffTuples(wantKeys, wantValues, gotKeys, gotValues []string) string {
+	want := tupleSet(wantKeys, wantValues)
+	got := tupleSet(gotKeys, gotValues)
+	missing := difference(want, got)
+	extra := difference(got, want)
+	if len(missing) == 0 && len(extra) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d tuples missing %s, %d unexpected %s", len(missing), listSome(missing), len(extra), listSome(extra))
+}
+
+func tupleSet(keys, values []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		set[key+"="+value] = true
+	}
+	return set
+}
+
+func difference(a, b map[string]bool) []string {
+	var only []string
+	for tuple := range a {
+		if !b[tuple] {
+			only = append(only, tuple)
+		}
+	}
+	sort.Strings(only)
+	return only
+}
+
+func listSome(tuples []string) string {
+	if len(tuples) > maxListed {
+		return fmt.Sprintf("%q...", tuples[:maxListed])
+	}
+	return fmt.Sprintf("%q", tuples)
+}
+
+// diffAggregates compares aggregate values in order, allowing for rounding.
+func diffAggregates(want, got []string) string {
+	if len(want) != len(got) {
+		return fmt.Sprintf("%d aggregates, want %d", len(got), len(want))
+	}
+	for i := range want {
+		w, errWant := strconv.ParseFloat(want[i], 64)
+		g, errGot := strconv.ParseFloat(got[i], 64)
+		if errWant != nil || errGot != nil || math.Abs(w-g) > 1e-9*math.Max(1, math.Abs(w)) {
+			return fmt.Sprintf("aggregate %d is %q, want %q", i, got[i], want[i])
+		}
+	}
+	return ""
+}
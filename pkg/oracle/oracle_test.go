@@ -0,0 +1,227 @@
+package oracle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/project/ObliSql/api/resolver"
+	resolverPkg "github.com/project/ObliSql/pkg/resolver"
+)
+
+var testMetaData = map[string]resolverPkg.MetaData{
+	"review": {
+		ColNames: []string{"a_id", "u_id", "i_id", "rating", "comment", "creation_date"},
+		ColTypes: map[string]string{"a_id": "int", "u_id": "int", "i_id": "int", "rating": "int", "comment": "varchar", "creation_date": "date"},
+	},
+	"item": {
+		ColNames: []string{"i_id", "title"},
+		ColTypes: map[string]string{"i_id": "int", "title": "varchar"},
+	},
+}
+
+func testOracle() *Oracle {
+	o := New(testMetaData)
+	rows := map[string][]map[string]string{
+		"review": {
+			{"a_id": "10", "u_id": "812", "i_id": "7", "rating": "2", "comment": "first", "creation_date": "2020-12-15"},
+			{"a_id": "10", "u_id": "812", "i_id": "8", "rating": "4", "comment": "second", "creation_date": "2021-01-02"},
+			{"a_id": "11", "u_id": "900", "i_id": "7", "rating": "0", "comment": "third", "creation_date": "2021-03-04"},
+		},
+		"item": {
+			{"i_id": "7", "title": "seven"},
+			{"i_id": "8", "title": "eight"},
+		},
+	}
+	for table, tableRows := range rows {
+		for pk, row := range tableRows {
+			for col, value := range row {
+				o.Set(fmt.Sprintf("%s/%s/%d", table, col, pk), value)
+			}
+		}
+	}
+	// Index entries are ignored
+	o.Set("review/u_id_index/812", "0,1")
+	return o
+}
+
+func evaluate(t *testing.T, o *Oracle, q *resolver.ParsedQuery) map[string]string {
+	t.Helper()
+	keys, values, err := o.Evaluate(q)
+	if err != nil {
+		t.Fatalf("Evaluate(%v) error = %v", q, err)
+	}
+	result := make(map[string]string, len(keys))
+	for i, key := range keys {
+		result[key] = values[i]
+	}
+	return result
+}
+
+func TestSelect(t *testing.T) {
+	o := testOracle()
+	tests := []struct {
+		name string
+		q    *resolver.ParsedQuery
+		want map[string]string
+	}{
+		{
+			"point",
+			&resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"u_id"}, SearchVal: []string{"812"}, SearchType: []string{"point"}},
+			map[string]string{"review/rating/0": "2", "review/rating/1": "4"},
+		},
+		{
+			"two points",
+			&resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"comment"}, SearchCol: []string{"a_id", "i_id"}, SearchVal: []string{"10", "7"}, SearchType: []string{"point", "point"}},
+			map[string]string{"review/comment/0": "first"},
+		},
+		{
+			"int range",
+			&resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"comment"}, SearchCol: []string{"u_id"}, SearchVal: []string{"813", "900"}, SearchType: []string{"range"}},
+			map[string]string{"review/comment/2": "third"},
+		},
+		{
+			"date range",
+			&resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"creation_date"}, SearchVal: []string{"2020-12-15", "2021-01-02"}, SearchType: []string{"range"}},
+			map[string]string{"review/rating/0": "2", "review/rating/1": "4"},
+		},
+		{
+			"all columns",
+			&resolver.ParsedQuery{QueryType: "select", TableName: "item", ColToGet: []string{"*"}, SearchCol: []string{"i_id"}, SearchVal: []string{"8"}, SearchType: []string{"point"}},
+			map[string]string{"item/i_id/1": "8", "item/title/1": "eight"},
+		},
+		{
+			"no match",
+			&resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"u_id"}, SearchVal: []string{"1"}, SearchType: []string{"point"}},
+			map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evaluate(t, o, tt.q); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	o := testOracle()
+	got := evaluate(t, o, &resolver.ParsedQuery{
+		QueryType:   "join",
+		TableName:   "review,item",
+		ColToGet:    []string{"review.rating", "item.title"},
+		SearchCol:   []string{"review.u_id", "item.i_id"},
+		SearchVal:   []string{"812", "7"},
+		JoinColumns: []string{"i_id", "i_id"},
+	})
+	want := map[string]string{"review/rating/0": "2", "item/title/0": "seven"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("join = %v, want %v", got, want)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	o := testOracle()
+	_, values, err := o.Evaluate(&resolver.ParsedQuery{
+		QueryType:     "aggregate",
+		TableName:     "review",
+		AggregateType: []string{"sum", "count", "avg"},
+		ColToGet:      []string{"rating", "rating", "rating"},
+		SearchCol:     []string{"u_id", "i_id", "u_id"},
+		SearchVal:     []string{"812", "7", "812"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if want := []string{"6", "2", "3"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("sum, count and avg = %v, want %v", values, want)
+	}
+}
+
+var (
+	readRating   = &resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"a_id"}, SearchVal: []string{"11"}, SearchType: []string{"point"}}
+	updateRating = &resolver.ParsedQuery{QueryType: "update", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"a_id"}, SearchVal: []string{"11"}, SearchType: []string{"point"}, UpdateVal: []string{"5"}}
+)
+
+func TestCheck(t *testing.T) {
+	o := testOracle()
+	s := o.Begin(readRating)
+	if outcome, diff := o.Check(readRating, s, []string{"review/rating/2"}, []string{"0"}); outcome != Match {
+		t.Errorf("Check() of the right answer = %v, %s", outcome, diff)
+	}
+	outcome, diff := o.Check(readRating, s, []string{"review/rating/2", "review/rating/0"}, []string{"1", "2"})
+	if outcome != Mismatch || !strings.Contains(diff, `review/rating/2=0`) || !strings.Contains(diff, `review/rating/0=2`) {
+		t.Errorf("Check() of a wrong answer = %v, %q", outcome, diff)
+	}
+
+	u := o.Begin(updateRating)
+	if err := o.Finish(updateRating, u, true); err != nil {
+		t.Fatalf("Finish() error = %v", err)
+	}
+	s = o.Begin(readRating)
+	if outcome, diff := o.Check(readRating, s, []string{"review/rating/2"}, []string{"5"}); outcome != Match {
+		t.Errorf("Check() after an update = %v, %s", outcome, diff)
+	}
+}
+
+func TestCheckSkipsRaces(t *testing.T) {
+	o := testOracle()
+	// The update can be applied before or after the read
+	s := o.Begin(readRating)
+	u := o.Begin(updateRating)
+	o.Finish(updateRating, u, true)
+	if outcome, _ := o.Check(readRating, s, []string{"review/rating/2"}, []string{"0"}); outcome != Skipped {
+		t.Errorf("Check() of a read racing an update = %v, want skipped", outcome)
+	}
+
+	// Racing updates may be applied in either order
+	updateAgain := &resolver.ParsedQuery{QueryType: "update", TableName: "review", ColToGet: []string{"rating"}, SearchCol: []string{"a_id"}, SearchVal: []string{"11"}, SearchType: []string{"point"}, UpdateVal: []string{"6"}}
+	first := o.Begin(updateRating)
+	second := o.Begin(updateAgain)
+	o.Finish(updateRating, first, true)
+	o.Finish(updateAgain, second, true)
+	s = o.Begin(readRating)
+	if outcome, _ := o.Check(readRating, s, []string{"review/rating/2"}, []string{"6"}); outcome != Skipped {
+		t.Errorf("Check() of a value written by racing updates = %v, want skipped", outcome)
+	}
+	other := &resolver.ParsedQuery{QueryType: "select", TableName: "review", ColToGet: []string{"comment"}, SearchCol: []string{"a_id"}, SearchVal: []string{"11"}, SearchType: []string{"point"}}
+	if outcome, diff := o.Check(other, o.Begin(other), []string{"review/comment/2"}, []string{"third"}); outcome != Match {
+		t.Errorf("Check() of another column = %v, %s", outcome, diff)
+	}
+
+	// A failed update may have been applied or not
+	u = o.Begin(updateRating)
+	o.Finish(updateRating, u, false)
+	if outcome, _ := o.Check(other, o.Begin(other), []string{"review/comment/2"}, []string{"third"}); outcome != Skipped {
+		t.Errorf("Check() after a failed update = %v, want skipped", outcome)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	metaDataLoc := filepath.Join(dir, "metadata.txt")
+	os.WriteFile(metaDataLoc, []byte(`{"item": {"colNames": ["i_id", "title"], "colTypes": {"i_id": "int", "title": "varchar"}}}`), 0644)
+	tracefile := filepath.Join(dir, "trace.txt")
+	trace := "SET item/i_id/0 7\nSET item/title/0 a title" + resolverPkg.ValuePadChar + resolverPkg.ValuePadChar + "\nSET item/i_id_index/7 0\n"
+	os.WriteFile(tracefile, []byte(trace), 0644)
+
+	o, err := Load(metaDataLoc, tracefile)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	keys, values, _ := o.Evaluate(&resolver.ParsedQuery{QueryType: "select", TableName: "item", ColToGet: []string{"*"}, SearchCol: []string{"i_id"}, SearchVal: []string{"7"}, SearchType: []string{"point"}})
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"item/i_id/0", "item/title/0"}) || len(values) != 2 {
+		t.Errorf("select * = %v %q", keys, values)
+	}
+	for _, v := range values {
+		if strings.Contains(v, resolverPkg.ValuePadChar) {
+			t.Errorf("value %q is still padded", v)
+		}
+	}
+}
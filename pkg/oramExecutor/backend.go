@@ -0,0 +1,109 @@
+package oramexecutor
+
+import "fmt"
+
+// ORAM constructions the executor can run on.
+const (
+	BackendPath = "path"
+	BackendRing = "ring"
+)
+
+// BackendConfig holds the parameters of an ORAM tree.
+type BackendConfig struct {
+	Backend        string // BackendPath or BackendRing
+	LogCapacity    int    // Logarithm base 2 of the number of leaves
+	Z              int    // Blocks per bucket
+	StashSize      int    // Maximum number of blocks in the stash, 0 for no bound
+	PositionCutoff int    // Largest position map kept in memory, 0 always keeps it in memory
+	RingDummies    int    // Ring ORAM: dummy slots per bucket
+	RingEvictRate  int    // Ring ORAM: accesses between evictions
+}
+
+// Backend is an ORAM construction that serves batches of GET and PUT requests.
+// Batching, checkpoints and recovery are only ever called from one goroutine.
+type Backend interface {
+	// Batching executes requests in order and returns one value per request, "-1" for
+	// GETs of missing keys.
+	Batching(requests []Request, batchSize int) ([]string, error)
+	StashStats() StashStats
+
+	// evictIdle runs one round of stash eviction if the stash needs it and reports whether
+	// it needs more. The executor calls it while no request is waiting.
+	evictIdle() (bool, error)
+	writeCheckpoint(dir string) error
+	recoverCheckpoint(dir string) (bool, error)
+	lastCheckpoint() int64
+}
+
+// uniqueKeys returns the keys of requests in order of first appearance. A key is added
+// to the position map if any request in the batch PUTs it.
+func uniqueKeys(requests []Request) ([]string, []bool) {
+	var keys []string
+	var insert []bool
+	keyIndex := make(map[string]int)
+	for _, req := range requests {
+		i, seen := keyIndex[req.Key]
+		if !seen {
+			i = len(keys)
+			keyIndex[req.Key] = i
+			keys = append(keys, req.Key)
+			insert = append(insert, false)
+		}
+		if req.Value != "" {
+			insert[i] = true
+		}
+	}
+	return keys, insert
+}
+
+// serveRequests applies requests in order to a stash holding the blocks of all their keys.
+func serveRequests(stash map[string]Block, requests []Request) []string {
+	values := make([]string, len(requests))
+	for i, req := range requests {
+		if req.Value != "" {
+			// Replying to PUT requests
+			stash[req.Key] = Block{Key: req.Key, Value: req.Value}
+			values[i] = req.Value
+		} else if block, exists := stash[req.Key]; exists {
+			// Replying to GET requests that access existing data
+			values[i] = block.Value
+		} else {
+			// Replying to GET requests trying to access non-existent keys
+			values[i] = "-1"
+		}
+	}
+	return values
+}
+
+// newBackend creates an empty ORAM of the configured construction on client.
+func newBackend(client *TreeStore, config BackendConfig) (Backend, error) {
+	var o Backend
+	var posMap positionMap
+	switch config.Backend {
+	case BackendPath:
+		oram := &ORAM{
+			Store:       client,
+			LogCapacity: config.LogCapacity,
+			Z:           config.Z,
+			StashSize:   config.StashSize,
+			StashMap:    make(map[string]Block),
+		}
+		oram.posMap = newPositionMap(client, config.LogCapacity, config.Z, config.StashSize, config.PositionCutoff, 1)
+		o, posMap = oram, oram.posMap
+	case BackendRing:
+		if client.integrity {
+			return nil, fmt.Errorf("integrity protection is only supported by the %q backend", BackendPath)
+		}
+		ring, err := newRingORAM(client, config)
+		if err != nil {
+			return nil, err
+		}
+		o, posMap = ring, ring.posMap
+	default:
+		return nil, fmt.Errorf("unknown ORAM backend %q, expected %q or %q", config.Backend, BackendPath, BackendRing)
+	}
+	if levels := positionLevels(posMap); levels > 0 {
+		fmt.Printf("ORAM position map stored recursively in %d levels\n", levels)
+	}
+	return o, nil
+}
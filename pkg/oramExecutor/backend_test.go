@@ -0,0 +1,232 @@
+package oramexecutor
+
+import (
+	"fmt"
+	"testing"
+)
+
+var testKey, _ = NewKeyRing(map[uint16][]byte{1: make([]byte, 32)})
+
+// testConfig is the ORAM most tests run on, 2^10 leaves with room for every test key.
+func testConfig(backend string, positionCutoff int) BackendConfig {
+	return BackendConfig{
+		Backend:        backend,
+		LogCapacity:    10,
+		Z:              4,
+		StashSize:      500,
+		PositionCutoff: positionCutoff,
+		RingDummies:    6,
+		RingEvictRate:  3,
+	}
+}
+
+func newTestBackend(t *testing.T, backend string, store BucketStore, positionCutoff int) Backend {
+	t.Helper()
+	o, err := newBackend(NewTreeStore(store, testKey, 0, false), testConfig(backend, positionCutoff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// runRandomBatches runs batches of mixed GETs and PUTs, including repeated keys and keys
+// that were never written, and checks every result against a plain map.
+func runRandomBatches(t *testing.T, o Backend, expected map[string]string, batches, batchSize int) {
+	t.Helper()
+	for b := 0; b < batches; b++ {
+		requests := make([]Request, batchSize)
+		for i := range requests {
+			key := fmt.Sprintf("K%d", GetRandomInt(300))
+			if GetRandomInt(3) == 0 {
+				requests[i] = Request{Key: key, Value: fmt.Sprintf("V%d-%d", b, i)}
+			} else {
+				requests[i] = Request{Key: key}
+			}
+		}
+		values, err := o.Batching(requests, batchSize)
+		if err != nil {
+			t.Fatalf("batch %d: %v", b, err)
+		}
+		for i, req := range requests {
+			want, ok := expected[req.Key]
+			if req.Value != "" {
+				expected[req.Key] = req.Value
+				want, ok = req.Value, true
+			}
+			if !ok {
+				want = "-1"
+			}
+			if values[i] != want {
+				t.Fatalf("batch %d request %d (%s): got %q, want %q", b, i, req.Key, values[i], want)
+			}
+		}
+	}
+}
+
+func TestBackendsMatchMap(t *testing.T) {
+	for _, backend := range []string{BackendPath, BackendRing} {
+		for _, cutoff := range []int{0, 16} {
+			t.Run(fmt.Sprintf("%s/cutoff=%d", backend, cutoff), func(t *testing.T) {
+				o := newTestBackend(t, backend, NewMemoryStore(), cutoff)
+				runRandomBatches(t, o, make(map[string]string), 200, 10)
+				if stats := o.StashStats(); stats.Overflows != 0 {
+					t.Errorf("unexpected stash overflows: %+v", stats)
+				}
+			})
+		}
+	}
+}
+
+func TestRecursivePositionMapLevels(t *testing.T) {
+	client := NewTreeStore(NewMemoryStore(), testKey, 0, false)
+	if levels := positionLevels(newPositionMap(client, 10, 4, 500, 0, 1)); levels != 0 {
+		t.Errorf("cutoff 0 should keep the position map in memory, got %d levels", levels)
+	}
+	// 2^12 positions packed into 2^9 blocks, then 2^6 and 2^3 blocks which fit the cutoff
+	if levels := positionLevels(newPositionMap(client, 12, 4, 500, 16, 1)); levels != 3 {
+		t.Errorf("expected 3 recursive levels, got %d", levels)
+	}
+}
+
+// TestRecursivePositionMapBlockSize runs recursive position maps under a data block size
+// smaller than a position block.
+func TestRecursivePositionMapBlockSize(t *testing.T) {
+	for _, backend := range []string{BackendPath, BackendRing} {
+		t.Run(backend, func(t *testing.T) {
+			o, err := newBackend(NewTreeStore(NewMemoryStore(), testKey, 64, false), testConfig(backend, 16))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var posMap positionMap
+			switch o := o.(type) {
+			case *ORAM:
+				posMap = o.posMap
+			case *RingORAM:
+				posMap = o.posMap
+			}
+			if positionLevels(posMap) == 0 {
+				t.Fatal("position map is not recursive")
+			}
+			runRandomBatches(t, o, make(map[string]string), 200, 10)
+		})
+	}
+}
+
+func TestPositionBlockProbing(t *testing.T) {
+	slots := make([]positionSlot, 1<<positionsPerBlockLog)
+	slots[3] = positionSlot{fingerprint: 7, leaf: 1}
+	if slot := findSlot(slots, 7, 3); slot != 3 {
+		t.Errorf("findSlot() = %d for a mapped key, want 3", slot)
+	}
+	// Another key starting at the same slot probes to the next empty one
+	if slot := findSlot(slots, 9, 3); slot != 4 {
+		t.Errorf("findSlot() = %d for a new key, want 4", slot)
+	}
+	for i := range slots {
+		slots[i] = positionSlot{fingerprint: uint64(i + 100), leaf: i}
+	}
+	if slot := findSlot(slots, 9, 3); slot != -1 {
+		t.Errorf("findSlot() = %d in a full block, want -1", slot)
+	}
+	encoded := encodePositionBlock(slots)
+	if len(encoded) != positionValueSize {
+		t.Errorf("position block value of %d bytes, want %d", len(encoded), positionValueSize)
+	}
+	decoded, err := decodePositionBlock(encoded)
+	if err != nil || fmt.Sprint(decoded) != fmt.Sprint(slots) {
+		t.Errorf("position block did not round trip: %v, %v", decoded, err)
+	}
+}
+
+func TestPositionBlockFull(t *testing.T) {
+	o := newTestBackend(t, BackendPath, NewMemoryStore(), 16)
+	posMap := o.(*ORAM).posMap.(*recursivePositionMap)
+
+	// Fill one position block, and find one more key of it and a key of another block
+	full, _, _ := posMap.slotOf("K0")
+	var blockKeys []string
+	other := ""
+	for i := 0; len(blockKeys) <= 1<<positionsPerBlockLog || other == ""; i++ {
+		key := fmt.Sprintf("K%d", i)
+		if block, _, _ := posMap.slotOf(key); block == full {
+			blockKeys = append(blockKeys, key)
+		} else if other == "" {
+			other = key
+		}
+	}
+	keys := append([]string{other}, blockKeys[:1<<positionsPerBlockLog]...)
+	leaves := make([]int, len(keys))
+	insert := make([]bool, len(keys))
+	for i := range keys {
+		leaves[i], insert[i] = i, true
+	}
+	if _, _, err := posMap.access(keys, leaves, insert); err != nil {
+		t.Fatal(err)
+	}
+
+	// The access fails as a whole, other keeps its leaf
+	if _, _, err := posMap.access([]string{other, blockKeys[1<<positionsPerBlockLog]}, []int{100, 101}, []bool{true, true}); err == nil {
+		t.Fatal("insert into a full position block did not fail")
+	}
+	oldLeaves, found, err := posMap.access([]string{other}, []int{0}, []bool{false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found[0] || oldLeaves[0] != 0 {
+		t.Errorf("%s is at leaf %d (found %t) after the failed access, want 0", other, oldLeaves[0], found[0])
+	}
+}
+
+func TestCheckpointBlockSize(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMemoryStore()
+	o, err := newBackend(NewTreeStore(store, testKey, 128, false), testConfig(BackendPath, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string]string)
+	runRandomBatches(t, o, expected, 20, 10)
+	if err := o.writeCheckpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewTreeStore(store, testKey, AutoBlockSize, false)
+	restarted, err := newBackend(client, testConfig(BackendPath, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.recoverCheckpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	if client.blockSize != 128 {
+		t.Errorf("recovered block size %d, want 128", client.blockSize)
+	}
+	runRandomBatches(t, restarted, expected, 20, 10)
+}
+
+func TestCheckpointRecovery(t *testing.T) {
+	for _, backend := range []string{BackendPath, BackendRing} {
+		for _, cutoff := range []int{0, 16} {
+			t.Run(fmt.Sprintf("%s/cutoff=%d", backend, cutoff), func(t *testing.T) {
+				dir := t.TempDir()
+				store := NewMemoryStore()
+				o := newTestBackend(t, backend, store, cutoff)
+				expected := make(map[string]string)
+				runRandomBatches(t, o, expected, 50, 10)
+				if err := o.writeCheckpoint(dir); err != nil {
+					t.Fatal(err)
+				}
+
+				restarted := newTestBackend(t, backend, store, cutoff)
+				recovered, err := restarted.recoverCheckpoint(dir)
+				if err != nil || !recovered {
+					t.Fatalf("recovery failed: %v, %v", recovered, err)
+				}
+				if restarted.lastCheckpoint() != 1 {
+					t.Errorf("expected epoch 1, got %d", restarted.lastCheckpoint())
+				}
+				runRandomBatches(t, restarted, expected, 50, 10)
+			})
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package oramexecutor
+
+import "sync"
+
+// BucketStore is the untrusted storage holding the encrypted values of the ORAM trees.
+// Values are opaque, and are read and written in bulk so a batch of paths costs one round trip.
+type BucketStore interface {
+	// Get returns the value of every key, nil for keys that were never written.
+	Get(keys []string) ([][]byte, error)
+	// Set writes values[i] under keys[i].
+	Set(keys []string, values [][]byte) error
+	// Flush deletes every value.
+	Flush() error
+	// SaveCheckpointEpoch records epoch and makes everything written so far durable, so a
+	// restarted store holds the state of the checkpoint with that epoch.
+	SaveCheckpointEpoch(epoch int64) error
+	// CheckpointEpoch returns the epoch of the last durable checkpoint, or 0 if there is none.
+	CheckpointEpoch() (int64, error)
+	Close() error
+}
+
+// MemoryStore keeps values in process memory. Nothing survives a restart, it is meant for
+// tests and for running the executor without external services.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	values map[string][]byte
+	epoch  int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{values: make(map[string][]byte)}
+}
+
+func (m *MemoryStore) Get(keys []string) ([][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		// Copies, like the values of a remote store, since callers decrypt in place
+		if value, ok := m.values[key]; ok {
+			values[i] = append([]byte(nil), value...)
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryStore) Set(keys []string, values [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, key := range keys {
+		m.values[key] = append([]byte(nil), values[i]...)
+	}
+	return nil
+}
+
+func (m *MemoryStore) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = make(map[string][]byte)
+	m.epoch = 0
+	return nil
+}
+
+func (m *MemoryStore) SaveCheckpointEpoch(epoch int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.epoch = epoch
+	return nil
+}
+
+func (m *MemoryStore) CheckpointEpoch() (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.epoch, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
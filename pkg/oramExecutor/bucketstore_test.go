@@ -0,0 +1,71 @@
+package oramexecutor
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreRestartsFromLastCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.log")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set([]string{"a", "b"}, [][]byte{[]byte("1"), []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveCheckpointEpoch(7); err != nil {
+		t.Fatal(err)
+	}
+	// Written after the checkpoint, gone after a restart
+	if err := s.Set([]string{"a", "c"}, [][]byte{[]byte("changed"), []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	values, _ := s.Get([]string{"a", "c"})
+	if string(values[0]) != "changed" || string(values[1]) != "3" {
+		t.Fatalf("unexpected values before restart: %q", values)
+	}
+	s.Close()
+
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if epoch, _ := s.CheckpointEpoch(); epoch != 7 {
+		t.Errorf("expected epoch 7, got %d", epoch)
+	}
+	values, _ = s.Get([]string{"a", "b", "c"})
+	if string(values[0]) != "1" || string(values[1]) != "2" || values[2] != nil {
+		t.Errorf("expected the checkpointed values, got %q", values)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buckets.log")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 1<<20)
+	for i := 0; i < 3*compactMinSize>>20; i++ {
+		value[0] = byte(i)
+		if err := s.Set([]string{"k"}, [][]byte{value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.size > compactMinSize {
+		t.Errorf("log was not compacted, %d bytes", s.size)
+	}
+	s.Close()
+
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	values, _ := s.Get([]string{"k"})
+	if len(values[0]) != len(value) || values[0][0] != value[0] {
+		t.Errorf("latest value lost by compaction")
+	}
+}
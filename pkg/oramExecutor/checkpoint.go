@@ -0,0 +1,193 @@
+package oramexecutor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	checkpointPrefix = "oram-checkpoint-"
+	checkpointSuffix = ".snap"
+	checkpointsKept  = 2 // The previous checkpoint covers a crash between writing the file and saving the store
+)
+
+// checkpoint is the trusted client state needed to resume an ORAM whose buckets are in a BucketStore.
+// Keymap and StashMap use the same names as the snapshot files read by loadSnapshotMaps.
+type checkpoint struct {
+	Epoch     int64
+	BlockSize int `json:",omitempty"` // Block size of the tree, recovered with AutoBlockSize
+	oramState
+}
+
+func checkpointPath(dir string, epoch int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%d%s", checkpointPrefix, epoch, checkpointSuffix))
+}
+
+// listCheckpoints returns the epochs of all checkpoints in dir, newest first.
+func listCheckpoints(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var epochs []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, checkpointPrefix) || !strings.HasSuffix(name, checkpointSuffix) {
+			continue
+		}
+		epoch, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, checkpointPrefix), checkpointSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		epochs = append(epochs, epoch)
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] > epochs[j] })
+	return epochs, nil
+}
+
+// saveCheckpoint encrypts state into checkpoint file epoch and then records the epoch in the
+// store and makes it durable, so the store and the file describe the same state.
+func saveCheckpoint(client *TreeStore, dir string, epoch int64, state oramState) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	data, err := json.Marshal(checkpoint{Epoch: epoch, BlockSize: client.blockSize, oramState: state})
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	encrypted, err := client.Keys.Encrypt(data)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt checkpoint: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated checkpoint behind
+	path := checkpointPath(dir, epoch)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, encrypted, 0600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+
+	if err := client.SaveCheckpointEpoch(epoch); err != nil {
+		return fmt.Errorf("failed to persist bucket state for checkpoint %d: %w", epoch, err)
+	}
+
+	epochs, err := listCheckpoints(dir)
+	if err != nil {
+		return err
+	}
+	for i := checkpointsKept; i < len(epochs); i++ {
+		os.Remove(checkpointPath(dir, epochs[i]))
+	}
+	return nil
+}
+
+// loadCheckpoint reads the checkpoint matching the epoch last saved in the store. It returns
+// false if dir holds no checkpoints.
+func loadCheckpoint(client *TreeStore, dir string) (checkpoint, bool, error) {
+	epochs, err := listCheckpoints(dir)
+	if err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to list checkpoints: %w", err)
+	}
+	if len(epochs) == 0 {
+		return checkpoint{}, false, nil
+	}
+
+	epoch, err := client.CheckpointEpoch()
+	if err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to read checkpoint epoch from the store: %w", err)
+	}
+	found := false
+	for _, e := range epochs {
+		if e == epoch {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return checkpoint{}, false, fmt.Errorf("no checkpoint in %s matches the store epoch %d (have %v)", dir, epoch, epochs)
+	}
+
+	encrypted, err := os.ReadFile(checkpointPath(dir, epoch))
+	if err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	data, err := client.Keys.Decrypt(encrypted)
+	if err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to decrypt checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpoint{}, false, fmt.Errorf("failed to decode checkpoint %d, wrong key?: %w", epoch, err)
+	}
+	if client.blockSize == AutoBlockSize && cp.BlockSize > 0 {
+		client.blockSize = cp.BlockSize
+	}
+	return cp, true, nil
+}
+
+// writeCheckpoint writes the position map and stash to a new checkpoint. It must only run
+// between batches.
+func (o *ORAM) writeCheckpoint(dir string) error {
+	epoch := o.checkpointEpoch + 1
+	if err := saveCheckpoint(o.Store, dir, epoch, o.state()); err != nil {
+		return err
+	}
+	o.checkpointEpoch = epoch
+	return nil
+}
+
+// recoverCheckpoint restores the position map and stash from the checkpoint matching the
+// epoch last saved in the store. It returns false if dir holds no checkpoints.
+func (o *ORAM) recoverCheckpoint(dir string) (bool, error) {
+	cp, found, err := loadCheckpoint(o.Store, dir)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := o.restore(cp.oramState); err != nil {
+		return false, err
+	}
+	o.checkpointEpoch = cp.Epoch
+	o.recordStash()
+	return true, nil
+}
+
+func (o *ORAM) lastCheckpoint() int64 {
+	return o.checkpointEpoch
+}
+
+// Checkpoint asks the batch goroutine to write a checkpoint between batches and waits for it.
+func (e *MyOram) Checkpoint() error {
+	if e.checkpointDir == "" {
+		return nil
+	}
+	done := make(chan error)
+	e.checkpointCh <- done
+	return <-done
+}
+
+// checkpointPeriodically triggers a checkpoint every interval.
+func (e *MyOram) checkpointPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		start := time.Now()
+		if err := e.Checkpoint(); err != nil {
+			fmt.Printf("ORAM checkpoint failed: %v\n", err)
+			continue
+		}
+		fmt.Printf("ORAM checkpoint %d written in %v\n", e.o.lastCheckpoint(), time.Since(start))
+	}
+}
@@ -0,0 +1,269 @@
+package oramexecutor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+)
+
+// Binary bucket format. All integers are little endian.
+//
+//	header: magic (1) | version (1) | block count (2) | block size (4) | real block count (2)
+//	block:  key length (2) | key | value length (4) | value | leaf (4) | zero padding to block size
+//	hashes: hash count (1) | hash count digests of digestSize bytes, version 2 only
+//
+// With a block size of 0 blocks are not padded. Otherwise every block takes exactly block
+// size bytes, so all buckets of a tree encrypt to the same length. Version 2 adds the child
+// digests of integrity protected trees, an all zero digest stands for a missing child.
+const (
+	bucketMagic         = 0xB7
+	bucketVersion       = 1
+	bucketVersionHashes = 2
+	bucketHeaderSize    = 10
+	blockOverhead       = 10 // Key length, value length and leaf
+	legacyJSONFirstByte = '{'
+)
+
+// AutoBlockSize sizes blocks to the largest block loaded from the tracefile, or to the block
+// size saved with the checkpoint an ORAM recovers from.
+const AutoBlockSize = -1
+
+// Binary Ring ORAM metadata format, little endian like buckets.
+//
+//	header: magic (1) | version (1) | slot count (2) | reads since the last write (4)
+//	slot:   key fingerprint (8) | valid (1)
+//
+// Metadata of the same Z+S encodes to the same length whatever the bucket holds.
+const (
+	ringMetaMagic      = 0xB8
+	ringMetaVersion    = 1
+	ringMetaHeaderSize = 8
+	ringMetaSlotSize   = 9
+)
+
+// keyFingerprint hashes key to a non-zero fingerprint. Ring ORAM metadata and position blocks
+// store it in place of the key, so they do not grow with the length of keys.
+func keyFingerprint(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// FNV spreads similar keys poorly over the high bits, finish with a mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	if x == 0 {
+		return 1
+	}
+	return x
+}
+
+func encodeRingMeta(meta *ringMeta) []byte {
+	buf := make([]byte, ringMetaHeaderSize+len(meta.Keys)*ringMetaSlotSize)
+	buf[0] = ringMetaMagic
+	buf[1] = ringMetaVersion
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(meta.Keys)))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(meta.Count))
+	offset := ringMetaHeaderSize
+	for i, fingerprint := range meta.Keys {
+		binary.LittleEndian.PutUint64(buf[offset:], fingerprint)
+		if meta.Valid[i] {
+			buf[offset+8] = 1
+		}
+		offset += ringMetaSlotSize
+	}
+	return buf
+}
+
+// decodeRingMeta decodes metadata in the binary format, or in the JSON encoding with the keys
+// of the slots used before it.
+func decodeRingMeta(data []byte) (*ringMeta, error) {
+	if len(data) > 0 && data[0] == legacyJSONFirstByte {
+		var legacy struct {
+			Keys  []string
+			Valid []bool
+			Count int
+		}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, err
+		}
+		meta := &ringMeta{Keys: make([]uint64, len(legacy.Keys)), Valid: legacy.Valid, Count: legacy.Count}
+		for i, key := range legacy.Keys {
+			meta.Keys[i] = slotFingerprint(key)
+		}
+		return meta, nil
+	}
+	if len(data) < ringMetaHeaderSize {
+		return nil, errTruncatedBucket
+	}
+	if data[0] != ringMetaMagic || data[1] != ringMetaVersion {
+		return nil, fmt.Errorf("unknown bucket metadata encoding")
+	}
+	slots := int(binary.LittleEndian.Uint16(data[2:]))
+	if len(data) != ringMetaHeaderSize+slots*ringMetaSlotSize {
+		return nil, errTruncatedBucket
+	}
+	meta := &ringMeta{
+		Keys:  make([]uint64, slots),
+		Valid: make([]bool, slots),
+		Count: int(binary.LittleEndian.Uint32(data[4:])),
+	}
+	offset := ringMetaHeaderSize
+	for i := range meta.Keys {
+		meta.Keys[i] = binary.LittleEndian.Uint64(data[offset:])
+		meta.Valid[i] = data[offset+8] == 1
+		offset += ringMetaSlotSize
+	}
+	return meta, nil
+}
+
+// BlockTooLargeError is returned when a block does not fit the configured block size.
+type BlockTooLargeError struct {
+	Key       string
+	Size      int
+	BlockSize int
+}
+
+func (e *BlockTooLargeError) Error() string {
+	return fmt.Sprintf("block %q needs %d bytes, block size is %d", e.Key, e.Size, e.BlockSize)
+}
+
+// encodedBlockSize is the number of bytes block takes before padding.
+func encodedBlockSize(block Block) int {
+	return blockOverhead + len(block.Key) + len(block.Value)
+}
+
+// encodeBucket encodes bucket in the binary format, padding every block to blockSize.
+func encodeBucket(bucket Bucket, blockSize int) ([]byte, error) {
+	if blockSize < 0 {
+		return nil, fmt.Errorf("block size %d was never resolved", blockSize)
+	}
+	size := bucketHeaderSize
+	for _, block := range bucket.Blocks {
+		n := encodedBlockSize(block)
+		if len(block.Key) > 0xFFFF {
+			return nil, fmt.Errorf("block key of %d bytes is too long", len(block.Key))
+		}
+		if blockSize > 0 {
+			if n > blockSize {
+				return nil, &BlockTooLargeError{Key: block.Key, Size: n, BlockSize: blockSize}
+			}
+			n = blockSize
+		}
+		size += n
+	}
+	version := byte(bucketVersion)
+	if len(bucket.Hashes) > 0 {
+		version = bucketVersionHashes
+		size += 1 + len(bucket.Hashes)*digestSize
+	}
+
+	buf := make([]byte, size)
+	buf[0] = bucketMagic
+	buf[1] = version
+	binary.LittleEndian.PutUint16(buf[2:], uint16(len(bucket.Blocks)))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(blockSize))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(bucket.RealBlockCount))
+
+	offset := bucketHeaderSize
+	for _, block := range bucket.Blocks {
+		start := offset
+		binary.LittleEndian.PutUint16(buf[offset:], uint16(len(block.Key)))
+		offset += 2
+		offset += copy(buf[offset:], block.Key)
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(len(block.Value)))
+		offset += 4
+		offset += copy(buf[offset:], block.Value)
+		binary.LittleEndian.PutUint32(buf[offset:], uint32(block.Leaf))
+		offset += 4
+		if blockSize > 0 {
+			offset = start + blockSize // Padding is already zero
+		}
+	}
+	if version == bucketVersionHashes {
+		buf[offset] = byte(len(bucket.Hashes))
+		offset++
+		for _, hash := range bucket.Hashes {
+			if hash != nil && len(hash) != digestSize {
+				return nil, fmt.Errorf("child digest of %d bytes, expected %d", len(hash), digestSize)
+			}
+			copy(buf[offset:offset+digestSize], hash) // A missing child stays all zero
+			offset += digestSize
+		}
+	}
+	return buf, nil
+}
+
+var errTruncatedBucket = errors.New("truncated bucket")
+
+// decodeBucket decodes a bucket in the binary format, or in the JSON encoding used before it.
+func decodeBucket(data []byte) (Bucket, error) {
+	var bucket Bucket
+	if len(data) > 0 && data[0] == legacyJSONFirstByte {
+		err := json.Unmarshal(data, &bucket)
+		return bucket, err
+	}
+	if len(data) < bucketHeaderSize {
+		return bucket, errTruncatedBucket
+	}
+	if data[0] != bucketMagic {
+		return bucket, fmt.Errorf("unknown bucket encoding")
+	}
+	if data[1] != bucketVersion && data[1] != bucketVersionHashes {
+		return bucket, fmt.Errorf("unsupported bucket encoding version %d", data[1])
+	}
+	count := int(binary.LittleEndian.Uint16(data[2:]))
+	blockSize := int(binary.LittleEndian.Uint32(data[4:]))
+	bucket.RealBlockCount = int(binary.LittleEndian.Uint16(data[8:]))
+	bucket.Blocks = make([]Block, count)
+
+	offset := bucketHeaderSize
+	for i := range bucket.Blocks {
+		start := offset
+		if offset+2 > len(data) {
+			return bucket, errTruncatedBucket
+		}
+		keyLen := int(binary.LittleEndian.Uint16(data[offset:]))
+		offset += 2
+		if offset+keyLen+4 > len(data) {
+			return bucket, errTruncatedBucket
+		}
+		bucket.Blocks[i].Key = string(data[offset : offset+keyLen])
+		offset += keyLen
+		valueLen := int(binary.LittleEndian.Uint32(data[offset:]))
+		offset += 4
+		if offset+valueLen+4 > len(data) {
+			return bucket, errTruncatedBucket
+		}
+		bucket.Blocks[i].Value = string(data[offset : offset+valueLen])
+		offset += valueLen
+		bucket.Blocks[i].Leaf = int(binary.LittleEndian.Uint32(data[offset:]))
+		offset += 4
+		if blockSize > 0 {
+			offset = start + blockSize
+		}
+	}
+	if data[1] == bucketVersionHashes {
+		if offset >= len(data) {
+			return bucket, errTruncatedBucket
+		}
+		count := int(data[offset])
+		offset++
+		if offset+count*digestSize > len(data) {
+			return bucket, errTruncatedBucket
+		}
+		bucket.Hashes = make([][]byte, count)
+		for i := range bucket.Hashes {
+			hash := data[offset : offset+digestSize]
+			offset += digestSize
+			if !bytes.Equal(hash, zeroDigest[:]) {
+				bucket.Hashes[i] = append([]byte(nil), hash...)
+			}
+		}
+	}
+	return bucket, nil
+}
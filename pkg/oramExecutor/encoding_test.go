@@ -0,0 +1,171 @@
+package oramexecutor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testBucket(z int) Bucket {
+	bucket := Bucket{Blocks: make([]Block, z), RealBlockCount: z - 1}
+	for i := range bucket.Blocks {
+		bucket.Blocks[i] = Block{Key: fmt.Sprintf("review/u_id/%d", i*7919), Value: strings.Repeat("v", 50+i*10), Leaf: i * 1013}
+	}
+	bucket.Blocks[z-1] = Block{Key: "-1", Value: ""}
+	return bucket
+}
+
+func TestBucketEncodingRoundTrip(t *testing.T) {
+	bucket := testBucket(5)
+	for _, blockSize := range []int{0, 256} {
+		data, err := encodeBucket(bucket, blockSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeBucket(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, bucket) {
+			t.Errorf("block size %d: got %+v, want %+v", blockSize, decoded, bucket)
+		}
+	}
+}
+
+func TestBucketEncodingHashes(t *testing.T) {
+	bucket := testBucket(2)
+	bucket.Hashes = [][]byte{nil, bucketDigest([]byte("child"))}
+	data, err := encodeBucket(bucket, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeBucket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, bucket) {
+		t.Errorf("got %+v, want %+v", decoded, bucket)
+	}
+}
+
+func TestBucketEncodingFixedSize(t *testing.T) {
+	full, err := encodeBucket(testBucket(5), 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := Bucket{Blocks: make([]Block, 5)}
+	for i := range empty.Blocks {
+		empty.Blocks[i].Key = "-1"
+	}
+	dummies, err := encodeBucket(empty, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(full) != len(dummies) || len(full) != bucketHeaderSize+5*256 {
+		t.Errorf("padded buckets differ in size: %d and %d", len(full), len(dummies))
+	}
+
+	_, err = encodeBucket(testBucket(5), 64)
+	var tooLarge *BlockTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Errorf("expected BlockTooLargeError, got %v", err)
+	}
+}
+
+func TestDecodeLegacyJSONBucket(t *testing.T) {
+	bucket := testBucket(3)
+	data, _ := json.Marshal(bucket)
+	decoded, err := decodeBucket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, bucket) {
+		t.Errorf("got %+v, want %+v", decoded, bucket)
+	}
+	if _, err := decodeBucket([]byte{bucketMagic, 9, 0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
+
+func TestRingMetaEncoding(t *testing.T) {
+	meta := &ringMeta{Keys: make([]uint64, 6), Valid: make([]bool, 6), Count: 3}
+	meta.Keys[1], meta.Valid[1] = slotFingerprint("review/u_id/7"), true
+	meta.Valid[4] = true
+	data := encodeRingMeta(meta)
+	decoded, err := decodeRingMeta(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, meta) {
+		t.Errorf("got %+v, want %+v", decoded, meta)
+	}
+
+	// The length only depends on the number of slots
+	full := &ringMeta{Keys: make([]uint64, 6), Valid: make([]bool, 6), Count: 1 << 20}
+	for i := range full.Keys {
+		full.Keys[i], full.Valid[i] = slotFingerprint(fmt.Sprintf("a/much/longer/key/%d", i)), true
+	}
+	if len(encodeRingMeta(full)) != len(data) {
+		t.Errorf("metadata of %d and %d bytes for the same slots", len(encodeRingMeta(full)), len(data))
+	}
+
+	// Metadata written as JSON by older executors is still read
+	legacy, _ := json.Marshal(map[string]any{"Keys": []string{"-1", "review/u_id/7"}, "Valid": []bool{true, false}, "Count": 2})
+	decoded, err = decodeRingMeta(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ringMeta{Keys: []uint64{0, slotFingerprint("review/u_id/7")}, Valid: []bool{true, false}, Count: 2}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("legacy metadata: got %+v, want %+v", decoded, want)
+	}
+}
+
+func TestLargestBlockSize(t *testing.T) {
+	requests := []Request{{Key: "a/b/1", Value: "short"}, {Key: "a/b/2", Value: strings.Repeat("v", 100)}}
+	if size := largestBlockSize(requests); size != blockOverhead+5+100 {
+		t.Errorf("largestBlockSize() = %d, want %d", size, blockOverhead+5+100)
+	}
+	if size := largestBlockSize(nil); size != blockOverhead+len(dummyKey) {
+		t.Errorf("largestBlockSize() without requests = %d", size)
+	}
+	if _, err := encodeBucket(testBucket(2), AutoBlockSize); err == nil {
+		t.Error("encodeBucket() accepted an unresolved block size")
+	}
+}
+
+func BenchmarkEncodeBucketJSON(b *testing.B) {
+	bucket := testBucket(5)
+	for i := 0; i < b.N; i++ {
+		data, _ := json.Marshal(bucket)
+		b.SetBytes(int64(len(data)))
+	}
+}
+
+func BenchmarkEncodeBucketBinary(b *testing.B) {
+	bucket := testBucket(5)
+	for i := 0; i < b.N; i++ {
+		data, _ := encodeBucket(bucket, 256)
+		b.SetBytes(int64(len(data)))
+	}
+}
+
+func BenchmarkDecodeBucketJSON(b *testing.B) {
+	data, _ := json.Marshal(testBucket(5))
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		var bucket Bucket
+		json.Unmarshal(data, &bucket)
+	}
+}
+
+func BenchmarkDecodeBucketBinary(b *testing.B) {
+	data, _ := encodeBucket(testBucket(5), 256)
+	b.SetBytes(int64(len(data)))
+	for i := 0; i < b.N; i++ {
+		decodeBucket(data)
+	}
+}
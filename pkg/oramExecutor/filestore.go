@@ -0,0 +1,283 @@
+package oramexecutor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	fileRecordSet   = 1 // A key and its new value
+	fileRecordEpoch = 2 // A checkpoint marker, the value is the epoch
+
+	fileRecordHeader = 9        // Record type, key length and value length
+	compactRatio     = 2        // The log is rewritten once it is this many times larger than its live records
+	compactMinSize   = 64 << 20 // Smaller logs are never rewritten
+)
+
+// FileStore is a BucketStore in a local append-only log file with an in-memory index of
+// the latest value of every key. SaveCheckpointEpoch appends a marker and syncs the file,
+// and opening the file discards everything written after the last marker, the same way
+// Redis restarts from its last SAVE. Without checkpoints the whole log is kept.
+type FileStore struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	index map[string]fileEntry
+	size  int64 // Bytes in the log
+	live  int64 // Bytes of the records in index
+	epoch int64
+}
+
+type fileEntry struct {
+	offset int64 // Offset of the value in the log
+	length int
+}
+
+func (e fileEntry) recordSize(key string) int64 {
+	return int64(fileRecordHeader + len(key) + e.length)
+}
+
+// NewFileStore opens or creates the log at path.
+func NewFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{path: path, file: file}
+	if err := s.load(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// load replays the log up to its last checkpoint marker and truncates what follows,
+// including a record left incomplete by a crash.
+func (s *FileStore) load() error {
+	var lastMarker int64
+	end, hasMarker, err := s.scan(-1, &lastMarker)
+	if err != nil {
+		return err
+	}
+	if hasMarker && lastMarker < end {
+		// Replay again, stopping at the last marker
+		if end, _, err = s.scan(lastMarker, nil); err != nil {
+			return err
+		}
+	}
+	s.size = end
+	return s.file.Truncate(end)
+}
+
+// scan replays complete records until limit (or the end of the file if limit is -1) into
+// the index and returns where it stopped. If lastMarker is set it receives the end of the
+// last checkpoint marker.
+func (s *FileStore) scan(limit int64, lastMarker *int64) (int64, bool, error) {
+	s.index = make(map[string]fileEntry)
+	s.live = 0
+	s.epoch = 0
+	hasMarker := false
+
+	var offset int64
+	header := make([]byte, fileRecordHeader)
+	for limit < 0 || offset < limit {
+		if _, err := s.file.ReadAt(header, offset); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, false, err
+		}
+		keyLen := int(binary.LittleEndian.Uint32(header[1:5]))
+		valueLen := int(binary.LittleEndian.Uint32(header[5:9]))
+		record := make([]byte, keyLen+valueLen)
+		if _, err := s.file.ReadAt(record, offset+fileRecordHeader); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return 0, false, err
+		}
+		next := offset + int64(fileRecordHeader+keyLen+valueLen)
+
+		switch header[0] {
+		case fileRecordSet:
+			key := string(record[:keyLen])
+			s.put(key, fileEntry{offset: offset + int64(fileRecordHeader+keyLen), length: valueLen})
+		case fileRecordEpoch:
+			if valueLen != 8 {
+				return 0, false, fmt.Errorf("corrupt checkpoint marker at offset %d", offset)
+			}
+			s.epoch = int64(binary.LittleEndian.Uint64(record[keyLen:]))
+			hasMarker = true
+			if lastMarker != nil {
+				*lastMarker = next
+			}
+		default:
+			return 0, false, fmt.Errorf("corrupt record at offset %d", offset)
+		}
+		offset = next
+	}
+	return offset, hasMarker, nil
+}
+
+func (s *FileStore) put(key string, entry fileEntry) {
+	if old, ok := s.index[key]; ok {
+		s.live -= old.recordSize(key)
+	}
+	s.index[key] = entry
+	s.live += entry.recordSize(key)
+}
+
+func appendRecord(buf []byte, recordType byte, key string, value []byte) []byte {
+	var header [fileRecordHeader]byte
+	header[0] = recordType
+	binary.LittleEndian.PutUint32(header[1:5], uint32(len(key)))
+	binary.LittleEndian.PutUint32(header[5:9], uint32(len(value)))
+	buf = append(buf, header[:]...)
+	buf = append(buf, key...)
+	return append(buf, value...)
+}
+
+func (s *FileStore) Get(keys []string) ([][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		entry, ok := s.index[key]
+		if !ok {
+			continue
+		}
+		values[i] = make([]byte, entry.length)
+		if _, err := s.file.ReadAt(values[i], entry.offset); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (s *FileStore) Set(keys []string, values [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf []byte
+	entries := make([]fileEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = fileEntry{offset: s.size + int64(len(buf)+fileRecordHeader+len(key)), length: len(values[i])}
+		buf = appendRecord(buf, fileRecordSet, key, values[i])
+	}
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	s.size += int64(len(buf))
+	for i, key := range keys {
+		s.put(key, entries[i])
+	}
+
+	// Compacting writes the current values, which only match a checkpoint while there is none
+	if s.epoch == 0 && s.needsCompaction() {
+		return s.compact(false)
+	}
+	return nil
+}
+
+func (s *FileStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.file.Truncate(0); err != nil {
+		return err
+	}
+	s.index = make(map[string]fileEntry)
+	s.size, s.live, s.epoch = 0, 0, 0
+	return nil
+}
+
+func (s *FileStore) SaveCheckpointEpoch(epoch int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.epoch = epoch
+	if s.needsCompaction() {
+		return s.compact(true)
+	}
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], uint64(epoch))
+	buf := appendRecord(nil, fileRecordEpoch, "", value[:])
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	s.size += int64(len(buf))
+	return s.file.Sync()
+}
+
+func (s *FileStore) needsCompaction() bool {
+	return s.size > compactMinSize && s.size > compactRatio*s.live
+}
+
+// compact rewrites the live records into a new log, followed by a checkpoint marker if
+// marker is set, and atomically replaces the old log with it.
+func (s *FileStore) compact(marker bool) error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	var size int64
+	index := make(map[string]fileEntry, len(s.index))
+	for key, entry := range s.index {
+		value := make([]byte, entry.length)
+		if _, err := s.file.ReadAt(value, entry.offset); err != nil {
+			return fail(err)
+		}
+		record := appendRecord(nil, fileRecordSet, key, value)
+		if _, err := w.Write(record); err != nil {
+			return fail(err)
+		}
+		index[key] = fileEntry{offset: size + int64(fileRecordHeader+len(key)), length: entry.length}
+		size += int64(len(record))
+	}
+	if marker {
+		var value [8]byte
+		binary.LittleEndian.PutUint64(value[:], uint64(s.epoch))
+		record := appendRecord(nil, fileRecordEpoch, "", value[:])
+		if _, err := w.Write(record); err != nil {
+			return fail(err)
+		}
+		size += int64(len(record))
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fail(err)
+	}
+
+	s.file.Close()
+	s.file = tmp
+	s.index = index
+	s.size = size
+	return nil
+}
+
+func (s *FileStore) CheckpointEpoch() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.epoch, nil
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
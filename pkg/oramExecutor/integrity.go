@@ -0,0 +1,103 @@
+package oramexecutor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+)
+
+const digestSize = sha256.Size
+
+var zeroDigest [digestSize]byte
+
+// IntegrityError is returned when a bucket read from the store does not match the digest
+// recorded by its parent, or by the trusted root for the root bucket. The store either
+// modified the bucket or replayed an older version of it.
+type IntegrityError struct {
+	Key string
+}
+
+func (e *IntegrityError) Error() string {
+	return fmt.Sprintf("integrity check failed for %s: bucket was tampered with or rolled back", e.Key)
+}
+
+// bucketDigest hashes the plaintext encoding of a bucket, nil for a bucket never written.
+func bucketDigest(encoded []byte) []byte {
+	if encoded == nil {
+		return nil
+	}
+	sum := sha256.Sum256(encoded)
+	return sum[:]
+}
+
+// verifyBuckets checks read buckets against the Merkle tree formed by the child digests every
+// bucket stores, starting from the trusted root. indices must contain every ancestor of every
+// bucket, which is the case for whole paths. The child digests of verified buckets are kept
+// for the following write.
+func (r *TreeStore) verifyBuckets(order []int, data [][]byte, buckets map[int]Bucket) error {
+	r.verified = make(map[int][][]byte, len(order))
+	digests := make(map[int][]byte, len(order))
+	for i, index := range order {
+		digests[index] = bucketDigest(data[i])
+	}
+	for _, index := range order {
+		expected := r.root
+		if index > 0 {
+			parent := (index - 1) / 2
+			if _, ok := digests[parent]; !ok {
+				return fmt.Errorf("cannot verify %s without its parent", r.bucketKey(index))
+			}
+			expected = nil
+			if hashes := buckets[parent].Hashes; len(hashes) == 2 {
+				expected = hashes[(index-1)%2]
+			}
+		}
+		if !bytes.Equal(digests[index], expected) {
+			return &IntegrityError{Key: r.bucketKey(index)}
+		}
+		r.verified[index] = buckets[index].Hashes
+	}
+	return nil
+}
+
+// hashBuckets stores the digests of their children in the buckets about to be written, from
+// the leaves up, and returns their encodings. Every written bucket must have been verified
+// by the preceding read and its parent must be written too, as with whole paths.
+func (r *TreeStore) hashBuckets(requests []BucketRequest) (map[int][]byte, error) {
+	sorted := append([]BucketRequest(nil), requests...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].BucketId > sorted[j].BucketId })
+	written := make(map[int]struct{}, len(sorted))
+	for _, req := range sorted {
+		written[req.BucketId] = struct{}{}
+	}
+
+	encoded := make(map[int][]byte, len(sorted))
+	for _, req := range sorted {
+		index := req.BucketId
+		if _, ok := written[(index-1)/2]; index > 0 && !ok {
+			return nil, fmt.Errorf("cannot write %s without its parent", r.bucketKey(index))
+		}
+		previous, ok := r.verified[index]
+		if !ok {
+			return nil, fmt.Errorf("cannot write %s, it was not read and verified first", r.bucketKey(index))
+		}
+		hashes := make([][]byte, 2)
+		for side := range hashes {
+			child := 2*index + 1 + side
+			if data, ok := encoded[child]; ok {
+				hashes[side] = bucketDigest(data)
+			} else if len(previous) == 2 {
+				hashes[side] = previous[side] // Child unchanged
+			}
+		}
+		bucket := req.Bucket
+		bucket.Hashes = hashes
+		data, err := encodeBucket(bucket, r.blockSize)
+		if err != nil {
+			return nil, err
+		}
+		encoded[index] = data
+	}
+	return encoded, nil
+}
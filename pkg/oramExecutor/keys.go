@@ -0,0 +1,322 @@
+package oramexecutor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Encrypted values are tagged with the version of the key that encrypted them:
+//
+//	magic (2) | key version (2) | IV | ciphertext
+//
+// Version 0 is the untagged format written before keys were versioned, so values encrypted
+// with a version 0 key carry no tag and are readable by older executors.
+var keyTagMagic = [2]byte{0xEC, 0x4B}
+
+const (
+	keyTagSize = 4
+	keySize    = 32 // AES-256
+
+	// devKeyPassphrase is the passphrase of the key every deployment used before keys were
+	// configurable. It is only accepted as the current key for development.
+	devKeyPassphrase = "oblisqloram"
+)
+
+// ErrDevKey is returned when the development key would be used to encrypt new data without
+// explicitly allowing it.
+var ErrDevKey = errors.New("the built-in development key is not allowed, configure a key or pass the dev key flag")
+
+// KeyRing holds every version of the encryption key still needed to read stored data. New
+// data is always encrypted with the current, highest version, so data encrypted with older
+// versions is re-encrypted as it is rewritten, which Path ORAM does for every bucket it reads.
+type KeyRing struct {
+	keys    map[uint16][]byte
+	current uint16
+	stale   atomic.Int64 // Values decrypted with an older version than current
+}
+
+// NewKeyRing creates a key ring from keys by version. The highest version is current.
+func NewKeyRing(keys map[uint16][]byte) (*KeyRing, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	k := &KeyRing{keys: make(map[uint16][]byte, len(keys))}
+	for version, key := range keys {
+		if len(key) != keySize {
+			return nil, fmt.Errorf("key version %d has %d bytes, expected %d", version, len(key), keySize)
+		}
+		k.keys[version] = append([]byte(nil), key...)
+		if version > k.current {
+			k.current = version
+		}
+	}
+	return k, nil
+}
+
+// DevKeyRing returns the key ring with only the development key, as version 0 so data
+// written by executors before keys were versioned stays readable.
+func DevKeyRing() *KeyRing {
+	k, _ := NewKeyRing(map[uint16][]byte{0: devKey()})
+	return k
+}
+
+func devKey() []byte {
+	sum := sha256.Sum256([]byte(devKeyPassphrase))
+	return sum[:]
+}
+
+// CurrentVersion returns the version new data is encrypted with.
+func (k *KeyRing) CurrentVersion() uint16 {
+	return k.current
+}
+
+// UsesDevKey reports whether new data is encrypted with the development key.
+func (k *KeyRing) UsesDevKey() bool {
+	return bytes.Equal(k.keys[k.current], devKey())
+}
+
+// StaleReads returns how many values were read that were encrypted with an older key version.
+// Once it stops growing after a full pass over the data, older versions can be retired.
+func (k *KeyRing) StaleReads() int64 {
+	return k.stale.Load()
+}
+
+// Encrypt encrypts data with the current key and tags it with its version.
+func (k *KeyRing) Encrypt(data []byte) ([]byte, error) {
+	encrypted, err := Encrypt(data, k.keys[k.current])
+	if err != nil || k.current == 0 {
+		return encrypted, err
+	}
+	tagged := make([]byte, keyTagSize+len(encrypted))
+	copy(tagged, keyTagMagic[:])
+	binary.BigEndian.PutUint16(tagged[2:], k.current)
+	copy(tagged[keyTagSize:], encrypted)
+	return tagged, nil
+}
+
+// Decrypt decrypts data with the key version it is tagged with. Untagged data is decrypted
+// with version 0.
+func (k *KeyRing) Decrypt(data []byte) ([]byte, error) {
+	plaintext, _, err := k.decrypt(data)
+	return plaintext, err
+}
+
+// decrypt is Decrypt that also returns the key version data was encrypted with.
+func (k *KeyRing) decrypt(data []byte) ([]byte, uint16, error) {
+	version := uint16(0)
+	if len(data) >= keyTagSize && bytes.Equal(data[:2], keyTagMagic[:]) {
+		// An untagged value starts with a random IV, so it may look tagged by chance
+		if v := binary.BigEndian.Uint16(data[2:]); v != 0 {
+			if _, ok := k.keys[v]; ok {
+				version = v
+				data = data[keyTagSize:]
+			} else if _, ok := k.keys[0]; !ok {
+				return nil, 0, fmt.Errorf("no key for version %d", v)
+			}
+		}
+	}
+	key, ok := k.keys[version]
+	if !ok {
+		return nil, 0, fmt.Errorf("no key for version %d", version)
+	}
+	if version != k.current {
+		k.stale.Add(1)
+	}
+	plaintext, err := Decrypt(data, key)
+	return plaintext, version, err
+}
+
+// parseKeys parses one key per line or comma separated entry, each "<version>:<hex key>".
+// A single entry may leave out the version, it is then version 1. Blank lines and lines
+// starting with # are ignored.
+func parseKeys(text string) (map[uint16][]byte, error) {
+	var entries []string
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			entries = append(entries, line)
+		}
+	}
+
+	keys := make(map[uint16][]byte, len(entries))
+	for _, entry := range entries {
+		version := uint64(1)
+		encoded := entry
+		if i := strings.IndexByte(entry, ':'); i >= 0 {
+			var err error
+			if version, err = strconv.ParseUint(entry[:i], 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid key version %q", entry[:i])
+			}
+			encoded = entry[i+1:]
+		} else if len(entries) > 1 {
+			return nil, errors.New("every key needs a version when there are several")
+		}
+		key, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key version %d is not hex encoded", version)
+		}
+		if _, ok := keys[uint16(version)]; ok {
+			return nil, fmt.Errorf("key version %d is given twice", version)
+		}
+		keys[uint16(version)] = key
+	}
+	return keys, nil
+}
+
+// LoadKeyFile reads a key ring from a file in the format of parseKeys.
+func LoadKeyFile(path string) (*KeyRing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseKeys(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewKeyRing(keys)
+}
+
+// LoadKeyEnv reads a key ring from an environment variable in the format of parseKeys.
+func LoadKeyEnv(name string) (*KeyRing, error) {
+	text, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	keys, err := parseKeys(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return NewKeyRing(keys)
+}
+
+// LocalKMS is a key provider backed by a directory holding one "<version>.key" file per key
+// version. Keys are generated by Rotate and never leave the directory other than through Load,
+// so operators do not handle raw keys.
+type LocalKMS struct {
+	Dir string
+}
+
+func (m LocalKMS) keyPath(version uint16) string {
+	return filepath.Join(m.Dir, fmt.Sprintf("%d.key", version))
+}
+
+func (m LocalKMS) versions() ([]uint16, error) {
+	entries, err := os.ReadDir(m.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var versions []uint16
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".key") {
+			continue
+		}
+		version, err := strconv.ParseUint(strings.TrimSuffix(name, ".key"), 10, 16)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, uint16(version))
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// Load returns a key ring with every key version in the directory.
+func (m LocalKMS) Load() (*KeyRing, error) {
+	versions, err := m.versions()
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no keys in %s, create one by rotating", m.Dir)
+	}
+	keys := make(map[uint16][]byte, len(versions))
+	for _, version := range versions {
+		data, err := os.ReadFile(m.keyPath(version))
+		if err != nil {
+			return nil, err
+		}
+		if keys[version], err = hex.DecodeString(strings.TrimSpace(string(data))); err != nil {
+			return nil, fmt.Errorf("%s is not hex encoded", m.keyPath(version))
+		}
+	}
+	return NewKeyRing(keys)
+}
+
+// Rotate generates a random key with the next version, which becomes the current key.
+func (m LocalKMS) Rotate() (uint16, error) {
+	versions, err := m.versions()
+	if err != nil {
+		return 0, err
+	}
+	version := uint16(1)
+	if len(versions) > 0 {
+		if versions[len(versions)-1] == 0xFFFF {
+			return 0, errors.New("no key versions left")
+		}
+		version = versions[len(versions)-1] + 1
+	}
+	key, err := GenerateRandomKey()
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return 0, err
+	}
+	// O_EXCL so two rotations never hand out the same version
+	file, err := os.OpenFile(m.keyPath(version), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := file.WriteString(hex.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return version, file.Close()
+}
+
+// LoadKeyRing loads the key ring from the one configured source: a key file, an environment
+// variable or a local KMS directory. Without a source the development key is used, which, like
+// a configured ring whose current key is the development key, is refused unless allowDevKey
+// is set. The development key may still be an older version to read data written with it.
+func LoadKeyRing(keyFile, keyEnv, kmsDir string, allowDevKey bool) (*KeyRing, error) {
+	sources := 0
+	for _, source := range []string{keyFile, keyEnv, kmsDir} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, errors.New("configure only one of a key file, a key environment variable and a KMS directory")
+	}
+
+	var keys *KeyRing
+	var err error
+	switch {
+	case keyFile != "":
+		keys, err = LoadKeyFile(keyFile)
+	case keyEnv != "":
+		keys, err = LoadKeyEnv(keyEnv)
+	case kmsDir != "":
+		keys, err = LocalKMS{Dir: kmsDir}.Load()
+	default:
+		keys = DevKeyRing()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if keys.UsesDevKey() && !allowDevKey {
+		return nil, ErrDevKey
+	}
+	return keys, nil
+}
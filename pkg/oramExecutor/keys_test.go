@@ -0,0 +1,154 @@
+package oramexecutor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKeyBytes(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestParseKeys(t *testing.T) {
+	single, err := parseKeys(hex.EncodeToString(testKeyBytes(1)) + "\n")
+	if err != nil || !bytes.Equal(single[1], testKeyBytes(1)) {
+		t.Fatalf("single key: %v %v", single, err)
+	}
+
+	text := "# rotated\n3:" + hex.EncodeToString(testKeyBytes(3)) + ",7:" + hex.EncodeToString(testKeyBytes(7))
+	keys, err := parseKeys(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := NewKeyRing(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ring.CurrentVersion() != 7 || len(keys) != 2 {
+		t.Errorf("got current version %d of %d keys", ring.CurrentVersion(), len(keys))
+	}
+
+	for _, bad := range []string{"1:zz", "1:00", hex.EncodeToString(testKeyBytes(1)) + "\n2:" + hex.EncodeToString(testKeyBytes(2))} {
+		if keys, err := parseKeys(bad); err == nil {
+			if _, err = NewKeyRing(keys); err == nil {
+				t.Errorf("%q: expected an error", bad)
+			}
+		}
+	}
+}
+
+func TestLoadKeyRingRefusesDevKey(t *testing.T) {
+	if _, err := LoadKeyRing("", "", "", false); !errors.Is(err, ErrDevKey) {
+		t.Errorf("expected ErrDevKey without a key source, got %v", err)
+	}
+	if _, err := LoadKeyRing("", "", "", true); err != nil {
+		t.Errorf("dev key allowed: %v", err)
+	}
+
+	// The dev key may stay in the ring as an older version, but not as the current one
+	dir := t.TempDir()
+	path := filepath.Join(dir, "keys")
+	os.WriteFile(path, []byte("0:"+hex.EncodeToString(devKey())+"\n"), 0600)
+	if _, err := LoadKeyRing(path, "", "", false); !errors.Is(err, ErrDevKey) {
+		t.Errorf("expected ErrDevKey for a key file with only the dev key, got %v", err)
+	}
+	os.WriteFile(path, []byte("0:"+hex.EncodeToString(devKey())+"\n1:"+hex.EncodeToString(testKeyBytes(1))+"\n"), 0600)
+	if _, err := LoadKeyRing(path, "", "", false); err != nil {
+		t.Error(err)
+	}
+
+	t.Setenv("ORAM_TEST_KEYS", hex.EncodeToString(testKeyBytes(1)))
+	if ring, err := LoadKeyRing("", "ORAM_TEST_KEYS", "", false); err != nil || ring.CurrentVersion() != 1 {
+		t.Errorf("environment keys: %v", err)
+	}
+	if _, err := LoadKeyRing(path, "ORAM_TEST_KEYS", "", false); err == nil {
+		t.Error("expected an error for two key sources")
+	}
+}
+
+func TestLocalKMSRotate(t *testing.T) {
+	kms := LocalKMS{Dir: filepath.Join(t.TempDir(), "kms")}
+	if _, err := kms.Load(); err == nil {
+		t.Fatal("expected an error for an empty key directory")
+	}
+	for want := uint16(1); want <= 2; want++ {
+		version, err := kms.Rotate()
+		if err != nil || version != want {
+			t.Fatalf("rotate: got version %d, %v", version, err)
+		}
+	}
+	ring, err := kms.Load()
+	if err != nil || ring.CurrentVersion() != 2 || len(ring.keys) != 2 {
+		t.Fatalf("load: %v", err)
+	}
+}
+
+func TestKeyRingDecryptsOlderVersions(t *testing.T) {
+	legacy := DevKeyRing()
+	v1, _ := NewKeyRing(map[uint16][]byte{1: testKeyBytes(1)})
+	rotated, _ := NewKeyRing(map[uint16][]byte{0: devKey(), 1: testKeyBytes(1), 2: testKeyBytes(2)})
+
+	untagged, _ := legacy.Encrypt([]byte("legacy"))
+	tagged, _ := v1.Encrypt([]byte("v1"))
+	for _, c := range []struct {
+		data []byte
+		want string
+	}{{untagged, "legacy"}, {tagged, "v1"}} {
+		plaintext, err := rotated.Decrypt(c.data)
+		if err != nil || string(plaintext) != c.want {
+			t.Errorf("got %q %v, want %q", plaintext, err, c.want)
+		}
+	}
+	if rotated.StaleReads() != 2 {
+		t.Errorf("got %d stale reads, want 2", rotated.StaleReads())
+	}
+
+	current, _ := rotated.Encrypt([]byte("v2"))
+	if _, err := v1.Decrypt(current); err == nil {
+		t.Error("expected an error for a key version that is not in the ring")
+	}
+}
+
+func TestKeyRotationReencryptsLazily(t *testing.T) {
+	dir := t.TempDir()
+	store := NewMemoryStore()
+	v1, _ := NewKeyRing(map[uint16][]byte{1: testKeyBytes(1)})
+	config := BackendConfig{Backend: BackendPath, LogCapacity: 6, Z: 4, StashSize: 500}
+	o, err := newBackend(NewTreeStore(store, v1, 0, false), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string]string)
+	runRandomBatches(t, o, expected, 50, 10)
+	if err := o.writeCheckpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, _ := NewKeyRing(map[uint16][]byte{1: testKeyBytes(1), 2: testKeyBytes(2)})
+	client := NewTreeStore(store, rotated, 0, false)
+	restarted, err := newBackend(client, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.recoverCheckpoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	runRandomBatches(t, restarted, expected, 200, 10)
+	if client.IOStats().StaleKeyReads == 0 {
+		t.Error("expected reads of values encrypted with version 1")
+	}
+
+	reencrypted := 0
+	for _, value := range store.values {
+		if bytes.HasPrefix(value, []byte{keyTagMagic[0], keyTagMagic[1], 0, 2}) {
+			reencrypted++
+		}
+	}
+	if reencrypted == 0 {
+		t.Error("expected buckets re-encrypted with version 2")
+	}
+}
@@ -0,0 +1,121 @@
+package oramexecutor
+
+import (
+	"strings"
+)
+
+// MigrationStats summarizes a bucket migration.
+type MigrationStats struct {
+	Values       int // Buckets, Ring ORAM slots and Ring ORAM metadata found
+	Migrated     int // Values rewritten from JSON to the binary encoding or to the current key
+	LargestBlock int // Largest encoded block, the smallest block size that fits every block
+	BytesBefore  int64
+	BytesAfter   int64
+}
+
+// isBucketKey reports whether key holds a bucket of a Path ORAM tree, including the trees of a
+// recursive position map, or a Ring ORAM slot.
+func isBucketKey(key string) bool {
+	return strings.HasPrefix(key, "bucket:") ||
+		(strings.HasPrefix(key, "pos") && strings.Contains(key, ":bucket:")) ||
+		strings.HasPrefix(key, ringSlotPrefix)
+}
+
+// MigrateBuckets re-encodes every JSON encoded bucket and Ring ORAM slot in r into the
+// binary encoding padded to blockSize, JSON Ring ORAM metadata into its fixed size binary
+// encoding, and re-encrypts buckets, slots and Ring ORAM metadata
+// encrypted with an older key version than the current one of keyRing. Values in the binary
+// encoding are not re-encoded. With dryRun nothing is written, which is useful to find the
+// block size to use.
+func MigrateBuckets(r *RedisStore, keyRing *KeyRing, blockSize, scanCount int, dryRun bool) (MigrationStats, error) {
+	var stats MigrationStats
+	var cursor uint64
+	for {
+		found, next, err := r.Client.Scan(r.Ctx, cursor, "*", int64(scanCount)).Result()
+		if err != nil {
+			return stats, err
+		}
+		var keys []string
+		for _, key := range found {
+			if isBucketKey(key) || strings.HasPrefix(key, ringMetaPrefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		values, err := r.Get(keys)
+		if err != nil {
+			return stats, err
+		}
+		var writeKeys []string
+		var writeValues [][]byte
+		for i, encrypted := range values {
+			if encrypted == nil {
+				continue // Deleted since the scan
+			}
+			stats.Values++
+			stats.BytesBefore += int64(len(encrypted))
+
+			data, version, err := keyRing.decrypt(encrypted)
+			if err != nil {
+				return stats, err
+			}
+			encoded := data
+			if strings.HasPrefix(keys[i], ringMetaPrefix) {
+				isJSON := data[0] == legacyJSONFirstByte
+				if !isJSON && version == keyRing.CurrentVersion() {
+					stats.BytesAfter += int64(len(encrypted))
+					continue
+				}
+				if isJSON {
+					meta, err := decodeRingMeta(data)
+					if err != nil {
+						return stats, err
+					}
+					encoded = encodeRingMeta(meta)
+				}
+			} else {
+				bucket, err := decodeBucket(data)
+				if err != nil {
+					return stats, err
+				}
+				for _, block := range bucket.Blocks {
+					if n := encodedBlockSize(block); n > stats.LargestBlock {
+						stats.LargestBlock = n
+					}
+				}
+				isJSON := data[0] == legacyJSONFirstByte
+				if !isJSON && version == keyRing.CurrentVersion() {
+					stats.BytesAfter += int64(len(encrypted))
+					continue
+				}
+				if isJSON {
+					if encoded, err = encodeBucket(bucket, blockSize); err != nil {
+						if dryRun {
+							continue // Reported through LargestBlock
+						}
+						return stats, err
+					}
+				}
+			}
+
+			reencrypted, err := keyRing.Encrypt(encoded)
+			if err != nil {
+				return stats, err
+			}
+			stats.Migrated++
+			stats.BytesAfter += int64(len(reencrypted))
+			writeKeys = append(writeKeys, keys[i])
+			writeValues = append(writeValues, reencrypted)
+		}
+		if !dryRun {
+			if err := r.Set(writeKeys, writeValues); err != nil {
+				return stats, err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return stats, nil
+		}
+	}
+}
@@ -4,12 +4,14 @@ package oramexecutor
 type Block struct {
 	Key   string // dummy can have key -1
 	Value string
+	Leaf  int `json:",omitempty"` // Leaf the block is mapped to, needed when the position map is not in memory
 }
 
 // Bucket represents a collection of blocks
 type Bucket struct {
 	Blocks         []Block
 	RealBlockCount int
+	Hashes         [][]byte `json:",omitempty"` // Digests of the two child buckets when integrity protection is on
 }
 
 type BucketRequest struct {
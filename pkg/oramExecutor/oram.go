@@ -10,10 +10,13 @@ import (
 type ORAM struct {
 	LogCapacity int // height of the tree or logarithm base 2 of capacity (i.e. capacity is 2 to the power of this value)
 	Z           int // number of blocks in a bucket (typically, 3 to 7)
-	RedisClient *RedisClient
+	Store       *TreeStore
 	StashMap    map[string]Block
 	StashSize   int // Maximum number of blocks the stash can hold
-	keyMap      map[string]int
+	posMap      positionMap
+	metrics     stashMetrics
+
+	checkpointEpoch int64 // Epoch of the last checkpoint written or recovered
 }
 
 // Initializing ORAM and populating with key = -1
@@ -27,7 +30,7 @@ func (o *ORAM) initialize() {
 			bucket.Blocks[j].Key = "-1"
 			bucket.Blocks[j].Value = ""
 		}
-		o.RedisClient.WriteBucketToDb(i, bucket) // initialize dosen't use redis batching mechanism to write: NOTE: don't run this initialize formally for experiments
+		o.Store.WriteBucketToDb(i, bucket) // initialize dosen't use redis batching mechanism to write: NOTE: don't run this initialize formally for experiments
 	}
 }
 
@@ -39,10 +42,38 @@ func (o *ORAM) ClearStash() {
 
 // ClearKeymap clears the ORAM keymap by resetting it to an empty state.
 func (o *ORAM) ClearKeymap() {
-	o.keyMap = make(map[string]int) // Resets the stash (assuming stash is a map of block indices to data).
+	o.posMap.reset()
 	fmt.Println("KeyMap has been cleared.")
 }
 
+// state returns the trusted state to checkpoint.
+func (o *ORAM) state() oramState {
+	state := oramState{StashMap: o.StashMap, Root: o.Store.root}
+	o.posMap.save(&state)
+	return state
+}
+
+// restore replaces the position map and stash with a checkpointed state.
+func (o *ORAM) restore(state oramState) error {
+	if err := o.posMap.restore(state); err != nil {
+		return err
+	}
+	o.StashMap = state.StashMap
+	if o.StashMap == nil {
+		o.StashMap = make(map[string]Block)
+	}
+	o.Store.root = state.Root
+	return nil
+}
+
+// leafOf returns the leaf a stash block is mapped to.
+func (o *ORAM) leafOf(key string, block Block) int {
+	if leaf, ok := o.posMap.cachedLeaf(key); ok {
+		return leaf
+	}
+	return block.Leaf
+}
+
 // getDepth calculates the depth of a bucket in the tree
 func (o *ORAM) GetDepth(bucketIndex int) int {
 	depth := 0
@@ -63,7 +94,7 @@ func (o *ORAM) canInclude(entryLeaf, leaf, level int) bool {
 }
 
 // ReadPath reads the paths from the root to the given leaves and optionally populates the stash.
-func (o *ORAM) ReadPaths(leafs []int) map[int]struct{} {
+func (o *ORAM) ReadPaths(leafs []int) (map[int]struct{}, error) {
 
 	// Calculate the maximum valid leaf value
 	maxLeaf := (1 << o.LogCapacity) - 1
@@ -87,7 +118,10 @@ func (o *ORAM) ReadPaths(leafs []int) map[int]struct{} {
 		}
 	}
 
-	bucketsData, _ := o.RedisClient.ReadBucketsFromDb(uniqueBuckets)
+	bucketsData, err := o.Store.ReadBucketsFromDb(uniqueBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paths: %w", err)
+	}
 	// Read the blocks from all buckets in all retrived buckets
 	for _, bucketData := range bucketsData {
 		for _, block := range bucketData.Blocks {
@@ -99,11 +133,10 @@ func (o *ORAM) ReadPaths(leafs []int) map[int]struct{} {
 		}
 	}
 
-	return uniqueBuckets
-
+	return uniqueBuckets, nil
 }
 
-func (o *ORAM) WritePaths(oldLeaves map[string]int, bucketIndices map[int]struct{}) {
+func (o *ORAM) WritePaths(oldLeaves map[string]int, bucketIndices map[int]struct{}) error {
 	newBuckets := make(map[int]Bucket)
 
 	// Initialize all buckets we need to write
@@ -118,7 +151,7 @@ func (o *ORAM) WritePaths(oldLeaves map[string]int, bucketIndices map[int]struct
 
 	// Iterate through stashmap for eviction
 	for key, block := range o.StashMap {
-		newLeafID := o.keyMap[key]
+		newLeafID := o.leafOf(key, block)
 		oldLeafID, hasOldPath := oldLeaves[key]
 
 		// Iterate through each level (leaf to root)
@@ -169,94 +202,74 @@ func (o *ORAM) WritePaths(oldLeaves map[string]int, bucketIndices map[int]struct
 	}
 
 	// Write to Redis
-	if err := o.RedisClient.WriteBucketsToDb(requests); err != nil {
-		fmt.Println("Error writing buckets:", err)
+	if err := o.Store.WriteBucketsToDb(requests); err != nil {
+		return fmt.Errorf("failed to write paths: %w", err)
 	}
+	return nil
 }
 
-func (o *ORAM) Batching(requests []Request, batchSize int) ([]string, error) {
-
-	if len(requests) > batchSize {
-		return nil, errors.New("batch size exceeded")
+// accessKeys performs one batched access to keys, which must be unique. Every key is remapped
+// to a fresh random leaf, and fakeReads extra random paths are read so repeated keys in a
+// batch are not visible. Unmapped keys read a random path and are only added to the position
+// map if insert is set. apply runs once all blocks of keys are in the stash and may read or
+// replace them, after which the stash is written back.
+func (o *ORAM) accessKeys(keys []string, insert []bool, fakeReads int, apply func()) error {
+	// Refuse the batch before touching any state if the stash cannot be brought under its bound
+	if err := o.enforceStashBound(); err != nil {
+		return err
 	}
 
-	// fakeReadMap = {key: fakeRead?, key: fakeRead? , ...}
-	fakeReadMap := make(map[string]bool)
-
-	//determine keys from position map for all of them ; if something doesn't exist, add it to Keymap and stash as done in Access()
-	previousPositionLeavesMap := make(map[int]struct{})
+	numLeaves := 1 << o.LogCapacity
+	newLeaves := make([]int, len(keys))
+	for i := range keys {
+		newLeaves[i] = GetRandomInt(numLeaves)
+	}
+	previousLeaves, found, err := o.posMap.access(keys, newLeaves, insert)
+	if err != nil {
+		return err
+	}
 
 	// previousPositionLeaves: List of Unique leaves
-	// - true leaves for first time keys in batch that already exist in system
+	// - true leaves for keys that already exist in system
 	// - fake leaves for keys never seen before (GET or PUT)
-	// - fake leaves for keys already seen in batch
+	// - fake leaves for keys repeated in the batch
+	previousPositionLeavesMap := make(map[int]struct{})
 	var previousPositionLeaves []int
-
-	oldLeaves := make(map[string]int)
-
-	for _, req := range requests {
-
-		_, keyReadBefore := fakeReadMap[req.Key]
-		var previousPositionLeaf = -1
-
-		if !keyReadBefore { // if seeing key for first time in batch
-			var exists bool
-			previousPositionLeaf, exists = o.keyMap[req.Key]
-
-			if !exists { // if key doesn't exist in Keymap
-				if req.Value == "" { // New key: GET request
-					// GET request for a new key, perform a random fake read, don't add a block, need to return -1
-					previousPositionLeaf = GetRandomInt(1 << o.LogCapacity)
-					// Skip adding to stash
-				} else { // New key: PUT request
-					// PUT request for a new key, add block to stash
-					previousPositionLeaf = GetRandomInt(1 << o.LogCapacity)
-					o.StashMap[req.Key] = Block{Key: req.Key, Value: req.Value}
-				}
-			}
-			fakeReadMap[req.Key] = true // This key has now been visited; future appearances in batch should lead to fake reads
-
-		} else { // if key seen before in batch
-			previousPositionLeaf = GetRandomInt(1 << o.LogCapacity)
-		}
-
-		oldLeaves[req.Key] = previousPositionLeaf // keep track of old leaf for each key
-
+	addLeaf := func(leaf int) {
 		// ensure there are no duplicates in previousPositionLeaves - otherwise writepaths may overwrite content
-		if _, alreadyExists := previousPositionLeavesMap[previousPositionLeaf]; !alreadyExists {
-			previousPositionLeavesMap[previousPositionLeaf] = struct{}{}
-			previousPositionLeaves = append(previousPositionLeaves, previousPositionLeaf) // previousPositionLeaves goes from index 0..batchSize - 1
+		if _, alreadyExists := previousPositionLeavesMap[leaf]; !alreadyExists {
+			previousPositionLeavesMap[leaf] = struct{}{}
+			previousPositionLeaves = append(previousPositionLeaves, leaf)
 		}
+	}
 
-		// randomly remap key
-		o.keyMap[req.Key] = GetRandomInt(1 << o.LogCapacity) // keep track of new leaf for each key
+	oldLeaves := make(map[string]int, len(keys))
+	for i, key := range keys {
+		leaf := previousLeaves[i]
+		if !found[i] {
+			leaf = GetRandomInt(numLeaves)
+		}
+		oldLeaves[key] = leaf // keep track of old leaf for each key
+		addLeaf(leaf)
+	}
+	for i := 0; i < fakeReads; i++ {
+		addLeaf(GetRandomInt(numLeaves))
 	}
 
 	// perform read path, go through all paths at once and find non overlapping buckets, fetch all from redis at once - read path use redis MGET
 	// adding all blocks to stash
-	var bucketIndices map[int]struct{} = o.ReadPaths(previousPositionLeaves)
+	bucketIndices, err := o.ReadPaths(previousPositionLeaves)
+	if err != nil {
+		return err
+	}
 
-	/*
-		Optimization v1
-		previousPositionLeaves: all leaves for requested keys (these could be real or fake, but they were requested)
-		newLeaves: for all requested keys, o.Keymap now has NEW leaf ids
-	*/
+	apply()
 
-	// Retrieve values from stash map for all keys in requests and load them into an array
-	values := make([]string, len(requests))
-
-	// Craft reply to requests
-	for i, req := range requests {
-		if req.Value != "" {
-			// Replying to PUT requests
-			o.StashMap[req.Key] = Block{Key: req.Key, Value: req.Value}
-			values[i] = o.StashMap[req.Key].Value
-		} else if block, exists := o.StashMap[req.Key]; exists {
-			// Replying to GET requests that access existing data
-			values[i] = block.Value
-		} else {
-			// Replying to GET requests trying to access non-existent keys
-			values[i] = "-1"
+	// Blocks carry their new leaf so WritePaths can place them without consulting the position map
+	for i, key := range keys {
+		if block, ok := o.StashMap[key]; ok {
+			block.Leaf = newLeaves[i]
+			o.StashMap[key] = block
 		}
 	}
 
@@ -269,7 +282,31 @@ func (o *ORAM) Batching(requests []Request, batchSize int) ([]string, error) {
 	// no point in redoing, this is an optmization. also, the other path may write -1s into
 	// already filled buckets
 
-	o.WritePaths(oldLeaves, bucketIndices)
+	if err := o.WritePaths(oldLeaves, bucketIndices); err != nil {
+		return err
+	}
+
+	// Blocks that could not be placed stay in the stash, the executor drains it while idle
+	o.recordStash()
+	return nil
+}
+
+func (o *ORAM) Batching(requests []Request, batchSize int) ([]string, error) {
+
+	if len(requests) > batchSize {
+		return nil, errors.New("batch size exceeded")
+	}
+
+	keys, insert := uniqueKeys(requests)
+
+	// Retrieve values from stash map for all keys in requests and load them into an array
+	var values []string
+	err := o.accessKeys(keys, insert, len(requests)-len(keys), func() {
+		values = serveRequests(o.StashMap, requests)
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	// return the results to the batch in an array
 	return values, nil
@@ -9,8 +9,10 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	executor "github.com/project/ObliSql/api/oramExecutor"
+	accesstrace "github.com/project/ObliSql/pkg/accessTrace"
 	// "github.com/redis/go-redis/v9"
 	"github.com/schollz/progressbar/v3"
 )
@@ -19,6 +21,10 @@ const (
 	logCapacity = 15     // Logarithm base 2 of capacity (1024 buckets)
 	Z           = 5      // Number of blocks per bucket
 	stashSize   = 100000 // Maximum number of blocks in stash
+
+	// dummyKey pads partial batches. Requests for it are rejected, so it is never written and
+	// every access to it is a fake path read.
+	dummyKey = "oram-dummy"
 )
 
 type Operation struct {
@@ -30,8 +36,10 @@ type Operation struct {
 
 type KVPair struct {
 	channelId string
+	index     int // Position of the operation in its request, replies of pipelined batches arrive out of order
 	Key       string
 	Value     string
+	err       error // Set when the batch containing this operation failed
 }
 
 type responseChannel struct {
@@ -41,9 +49,19 @@ type responseChannel struct {
 
 type MyOram struct {
 	executor.UnimplementedExecutorServer
-	o *ORAM
+	o     Backend
+	store *TreeStore
+
+	batchSize    int
+	batchTimeout time.Duration // Partial batches are padded and flushed after this long
+
+	checkpointDir string
+	checkpointCh  chan chan error // Checkpoint requests, served between batches
 
-	batchSize int
+	trace         *accesstrace.Writer // Records every batch and its storage accesses when set
+	traceExecutor int
+	traceID       int64
+	recorder      *recordingStore
 
 	channelMap    map[string]responseChannel
 	requestNumber atomic.Int64
@@ -61,10 +79,15 @@ func (e *MyOram) ExecuteBatch(ctx context.Context, req *executor.RequestBatchORA
 	if len(req.Keys) != len(req.Values) {
 		return nil, fmt.Errorf("keys and values length mismatch")
 	}
+	for _, key := range req.Keys {
+		if key == dummyKey {
+			return nil, fmt.Errorf("key %q is reserved for dummy accesses", dummyKey)
+		}
+	}
 
 	reqNum := e.requestNumber.Add(1) // New id for this client/batch channel
 
-	recv_resp := make([]KVPair, 0, len(req.Keys)) // This will store completed key value pairs
+	recv_resp := make([]KVPair, len(req.Keys)) // This will store completed key value pairs, in request order
 
 	channelId := fmt.Sprintf("%d-%d", req.RequestId, reqNum)
 	localRespChannel := make(chan KVPair, len(req.Keys))
@@ -81,6 +104,7 @@ func (e *MyOram) ExecuteBatch(ctx context.Context, req *executor.RequestBatchORA
 		value := req.Values[i]
 		kv := &KVPair{
 			channelId: channelId,
+			index:     i,
 			Key:       key,
 			Value:     value,
 		}
@@ -92,9 +116,13 @@ func (e *MyOram) ExecuteBatch(ctx context.Context, req *executor.RequestBatchORA
 	// Finished adding keys to ORAM channel
 
 	// Now wait for responses
+	var batchErr error
 	for i := 0; i < len(req.Keys); i++ {
 		item := <-localRespChannel
-		recv_resp = append(recv_resp, item)
+		if item.err != nil {
+			batchErr = item.err
+		}
+		recv_resp[item.index] = item
 	}
 
 	close(localRespChannel)
@@ -103,6 +131,10 @@ func (e *MyOram) ExecuteBatch(ctx context.Context, req *executor.RequestBatchORA
 	delete(e.channelMap, channelId)
 	e.channelLock.Unlock()
 
+	if batchErr != nil {
+		return nil, batchErr
+	}
+
 	sendKeys := make([]string, 0, len(req.Keys))
 	sendVal := make([]string, 0, len(req.Keys))
 
@@ -119,93 +151,204 @@ func (e *MyOram) ExecuteBatch(ctx context.Context, req *executor.RequestBatchORA
 	}, nil
 }
 
-func (e *MyOram) processBatches() {
-	for {
+// nextBatch blocks until an operation arrives and then collects operations until the
+// batch is full or batchTimeout has passed since the first one. Partial batches are
+// padded with dummy accesses so every batch sent to the ORAM has batchSize operations.
+func (e *MyOram) nextBatch() []*KVPair {
+	batch := make([]*KVPair, 0, e.batchSize)
+
+	// Checkpoints are written while no batch is in flight so they match the bucket state.
+	// While nothing is waiting the stash is evicted a round at a time, so an operation that
+	// arrives meanwhile waits for one round at most.
+	evicting, rounds := true, 0
+	for len(batch) == 0 {
+		if evicting && rounds < maxEvictionRounds {
+			select {
+			case op := <-e.oramExecutorChannel:
+				batch = append(batch, op)
+			case done := <-e.checkpointCh:
+				done <- e.o.writeCheckpoint(e.checkpointDir)
+			default:
+				var err error
+				rounds++
+				if evicting, err = e.o.evictIdle(); err != nil {
+					fmt.Printf("ORAM eviction error: %v\n", err)
+					evicting = false
+				}
+			}
+			continue
+		}
+		select {
+		case op := <-e.oramExecutorChannel:
+			batch = append(batch, op)
+		case done := <-e.checkpointCh:
+			done <- e.o.writeCheckpoint(e.checkpointDir)
+		}
+	}
 
-		if len(e.oramExecutorChannel) >= e.batchSize {
-			var requestList []Request
+	timer := time.NewTimer(e.batchTimeout)
+	defer timer.Stop()
 
-			var chanIds []string
+Collect:
+	for len(batch) < e.batchSize {
+		select {
+		case op := <-e.oramExecutorChannel:
+			batch = append(batch, op)
+		case <-timer.C:
+			break Collect
+		}
+	}
 
-			for i := 0; i < e.batchSize; i++ {
-				op := <-e.oramExecutorChannel // Read from channel
+	for len(batch) < e.batchSize {
+		batch = append(batch, &KVPair{Key: dummyKey})
+	}
+	return batch
+}
 
-				chanIds = append(chanIds, op.channelId)
+func (e *MyOram) processBatches() {
+	for {
+		batch := e.nextBatch()
+
+		requestList := make([]Request, 0, len(batch))
+		for _, op := range batch {
+			requestList = append(requestList, Request{
+				Key:   op.Key,
+				Value: op.Value,
+			})
+		}
 
-				requestList = append(requestList, Request{
-					Key:   op.Key,
-					Value: op.Value,
-				})
-			}
-			// Execute ORAM batch
-			returnValues, err := e.o.Batching(requestList, e.batchSize)
-			if err != nil {
-				// Handle error (e.g., log and continue)
-				fmt.Printf("ORAM batch error: %v\n", err)
-				continue
-			}
+		// Execute ORAM batch
+		start := time.Now()
+		returnValues, err := e.o.Batching(requestList, e.batchSize)
+		if err != nil {
+			// Every waiting request gets the error instead of blocking forever
+			fmt.Printf("ORAM batch error: %v\n", err)
+		}
+		if e.trace != nil {
+			e.recordBatch(batch, start)
+		}
 
-			channelCache := make(map[string]chan KVPair, e.batchSize)
+		// With pipelined writes the next batch starts while this one is written back, its
+		// results are only returned once they are in the store
+		wait := e.store.writeBarrier()
+		if e.store.writes.active() {
+			go e.respond(batch, returnValues, err, wait)
+		} else {
+			e.respond(batch, returnValues, err, wait)
+		}
+	}
+}
 
-			e.channelLock.RLock()
-			for _, v := range chanIds {
+// respond sends the results of a batch to the waiting requests once wait returns.
+func (e *MyOram) respond(batch []*KVPair, returnValues []string, err error, wait func() error) {
+	if writeErr := wait(); err == nil && writeErr != nil {
+		fmt.Printf("ORAM write error: %v\n", writeErr)
+		err = writeErr
+	}
 
-				channelCache[v] = e.channelMap[v].channel
+	channelCache := make(map[string]chan KVPair, e.batchSize)
 
-			}
-			e.channelLock.RUnlock()
+	e.channelLock.RLock()
+	for _, op := range batch {
+		if op.channelId != "" {
+			channelCache[op.channelId] = e.channelMap[op.channelId].channel
+		}
+	}
+	e.channelLock.RUnlock()
 
-			for i := 0; i < e.batchSize; i++ {
-				newKVPair := KVPair{
-					Key:   requestList[i].Key,
-					Value: returnValues[i],
-				}
-				responseChannel := channelCache[chanIds[i]]
-				responseChannel <- newKVPair
-			}
+	for i, op := range batch {
+		if op.channelId == "" {
+			// Dummy access, nobody is waiting on it
+			continue
+		}
+		if err != nil {
+			channelCache[op.channelId] <- KVPair{index: op.index, Key: op.Key, err: err}
+			continue
+		}
+		channelCache[op.channelId] <- KVPair{
+			index: op.index,
+			Key:   op.Key,
+			Value: returnValues[i],
 		}
 	}
 }
 
-func NewORAM(LogCapacity, Z, StashSize int, redisAddr string, tracefile string, snapLocation string, useSnapshot bool, batchSize int, key []byte) (*MyOram, error) {
-	// If key is not provided (nil or empty), generate a random key
-	if len(key) == 0 {
-		var err error
-		key, err = GenerateRandomKey()
+// Config holds the parameters of an ORAM executor.
+type Config struct {
+	BackendConfig
+	BlockSize          int  // Bytes every stored block is padded to, AutoBlockSize or 0 to disable padding
+	Integrity          bool // Verify path reads against a Merkle tree, path backend only
+	Tracefile          string
+	SnapLocation       string
+	UseSnapshot        bool
+	BatchSize          int
+	BatchTimeout       time.Duration       // Wait before a partial batch is padded and executed
+	PipelineDepth      int                 // Store writes in flight while the next batches run
+	CheckpointDir      string              // Empty disables checkpoints
+	CheckpointInterval time.Duration       // 0 only checkpoints on shutdown
+	AccessTrace        *accesstrace.Writer // Records every batch and the storage accesses it makes when set
+	TraceExecutor      int                 // Executor number recorded in the access trace
+}
+
+// NewORAM creates an ORAM executor on store, recovering it from a checkpoint or snapshot or
+// loading it from the tracefile. Without keys a random key is generated.
+func NewORAM(config Config, store BucketStore, keys *KeyRing) (*MyOram, error) {
+	// If no keys are provided, generate a random key
+	if keys == nil {
+		key, err := GenerateRandomKey()
 		if err != nil {
 			return nil, err
 		}
+		if keys, err = NewKeyRing(map[uint16][]byte{1: key}); err != nil {
+			return nil, err
+		}
+	}
+
+	var recorder *recordingStore
+	if config.AccessTrace != nil {
+		recorder = &recordingStore{BucketStore: store}
+		store = recorder
 	}
+	client := NewTreeStore(store, keys, config.BlockSize, config.Integrity)
+	client.Pipeline(config.PipelineDepth)
 
-	client, err := NewRedisClient(redisAddr, key)
+	o, err := newBackend(client, config.BackendConfig)
 	if err != nil {
 		return nil, err
 	}
+	oram, isPath := o.(*ORAM) // Only Path ORAM with an in-memory position map can load snapshots
+	if config.UseSnapshot && (!isPath || positionLevels(oram.posMap) > 0) {
+		return nil, fmt.Errorf("snapshots hold Path ORAM state with an in-memory position map, use the path backend without a position map cutoff")
+	}
+	fmt.Println("ORAM backend: ", config.Backend)
 
-	oram := &ORAM{
-		RedisClient: client,
-		LogCapacity: LogCapacity,
-		Z:           Z,
-		StashSize:   StashSize,
-		StashMap:    make(map[string]Block),
-		keyMap:      make(map[string]int),
+	recovered := false
+	if config.CheckpointDir != "" {
+		recovered, err = o.recoverCheckpoint(config.CheckpointDir)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if useSnapshot {
+	if recovered {
+		fmt.Printf("ORAM recovered from checkpoint %d! StashSize: %d\n", o.lastCheckpoint(), o.StashStats().Occupancy)
+		if client.blockSize == AutoBlockSize {
+			fmt.Println("Checkpoint has no block size, blocks are not padded")
+			client.blockSize = 0
+		}
+	} else if config.UseSnapshot {
 		// Load the Stashmap and Keymap into memory
 		// Allow redis to update state using dump.rdb
-		oram.loadSnapshotMaps(snapLocation)
+		oram.loadSnapshotMaps(config.SnapLocation)
 		fmt.Println("ORAM snapshot loaded successfully!")
-	} else {
-		// Clear the Redis database to ensure a fresh start
-		if err := client.FlushDB(); err != nil {
-			return nil, fmt.Errorf("failed to flush Redis database: %v", err)
+		if client.blockSize == AutoBlockSize {
+			fmt.Println("Snapshots have no block size, blocks are not padded. Pass -bs to pad them")
+			client.blockSize = 0
 		}
-		oram.initialize()
-
+	} else {
 		// Load data from tracefile and create Request objects
 		var requests []Request
-		file, err := os.Open(tracefile)
+		file, err := os.Open(config.Tracefile)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open tracefile: %v", err)
 		}
@@ -227,6 +370,9 @@ func NewORAM(LogCapacity, Z, StashSize int, redisAddr string, tracefile string,
 				}
 				key := parts[1]
 				value := parts[2]
+				if key == dummyKey {
+					return nil, fmt.Errorf("tracefile sets the reserved key %q", dummyKey)
+				}
 				requests = append(requests, Request{Key: key, Value: value})
 			}
 		}
@@ -235,8 +381,22 @@ func NewORAM(LogCapacity, Z, StashSize int, redisAddr string, tracefile string,
 			return nil, fmt.Errorf("error reading tracefile: %v", err)
 		}
 
+		if client.blockSize == AutoBlockSize {
+			client.blockSize = largestBlockSize(requests)
+		}
+		fmt.Println("ORAM block size set as: ", client.blockSize)
+
+		// Clear the store to ensure a fresh start
+		if err := client.FlushDB(); err != nil {
+			return nil, fmt.Errorf("failed to flush bucket store: %v", err)
+		}
+		// Buckets that were never written read as empty, integrity protected trees start out that way
+		if isPath && !config.Integrity {
+			oram.initialize()
+		}
+
 		// Initialize DB with tracefile contents and display a progress bar
-		batchSize := batchSize
+		batchSize := config.BatchSize
 		bar := progressbar.Default(int64(len(requests)), "Setting values...")
 
 		for start := 0; start < len(requests); start += batchSize {
@@ -245,7 +405,9 @@ func NewORAM(LogCapacity, Z, StashSize int, redisAddr string, tracefile string,
 				end = len(requests) // Ensure we don't go out of bounds
 			}
 
-			oram.Batching(requests[start:end], batchSize)
+			if _, err := o.Batching(requests[start:end], batchSize); err != nil {
+				return nil, fmt.Errorf("failed to initialize DB: %w", err)
+			}
 
 			// Increment the progress bar by the batch size or remaining items
 			_ = bar.Add(end - start)
@@ -255,21 +417,61 @@ func NewORAM(LogCapacity, Z, StashSize int, redisAddr string, tracefile string,
 		fmt.Println("Finished Initializing DB!")
 	}
 
-	myOram := &MyOram{
-		o:                   oram,
-		batchSize:           batchSize, // Set from config or constant
-		channelMap:          make(map[string]responseChannel),
-		channelLock:         sync.RWMutex{},
-		oramExecutorChannel: make(chan *KVPair),
+	myOram := newMyOram(o, client, config.BatchSize, config.BatchTimeout, config.CheckpointDir)
+	if recorder != nil {
+		// Only the accesses made for batches are traced, not those of loading the data
+		if err := client.writes.drain(); err != nil {
+			return nil, fmt.Errorf("failed to initialize DB: %w", err)
+		}
+		recorder.take()
+		myOram.trace, myOram.traceExecutor, myOram.recorder = config.AccessTrace, config.TraceExecutor, recorder
 	}
 	fmt.Println("Oram Batch Size set as: ", myOram.batchSize)
-	myOram.oramExecutorChannel = make(chan *KVPair, 100000)
+	fmt.Println("Oram Batch Timeout set as: ", myOram.batchTimeout)
+	fmt.Println("Oram Write Pipeline Depth set as: ", config.PipelineDepth)
 
 	go myOram.processBatches() // Start batch processing
+	if config.CheckpointDir != "" && config.CheckpointInterval > 0 {
+		go myOram.checkpointPeriodically(config.CheckpointInterval)
+	}
 
 	return myOram, nil
 }
 
+// largestBlockSize returns the encoded size of the largest block requests write, and at
+// least that of the dummy block padding partial batches.
+func largestBlockSize(requests []Request) int {
+	size := encodedBlockSize(Block{Key: dummyKey})
+	for _, req := range requests {
+		size = max(size, encodedBlockSize(Block{Key: req.Key, Value: req.Value}))
+	}
+	return size
+}
+
+func newMyOram(o Backend, store *TreeStore, batchSize int, batchTimeout time.Duration, checkpointDir string) *MyOram {
+	return &MyOram{
+		o:                   o,
+		store:               store,
+		batchSize:           batchSize, // Set from config or constant
+		batchTimeout:        batchTimeout,
+		checkpointDir:       checkpointDir,
+		checkpointCh:        make(chan chan error),
+		channelMap:          make(map[string]responseChannel),
+		channelLock:         sync.RWMutex{},
+		oramExecutorChannel: make(chan *KVPair, 100000),
+	}
+}
+
+// StashStats reports the stash occupancy of the underlying ORAM.
+func (e *MyOram) StashStats() StashStats {
+	return e.o.StashStats()
+}
+
+// IOStats reports the traffic between the executor and its store, for comparing backends.
+func (e *MyOram) IOStats() IOStats {
+	return e.store.IOStats()
+}
+
 // Load Keymap and Stashmap into memory
 func (oram *ORAM) loadSnapshotMaps(snapLocation string) {
 	// Read from snapshot.json
@@ -293,11 +495,13 @@ func (oram *ORAM) loadSnapshotMaps(snapLocation string) {
 	// Load Keymap
 	if keymap, ok := data["Keymap"].(map[string]interface{}); ok {
 		// Convert map[string]interface{} to map[string]int for Keymap
+		leaves := make(map[string]int, len(keymap))
 		for key, value := range keymap {
 			if val, ok := value.(float64); ok { // JSON numbers are decoded as float64
-				oram.keyMap[key] = int(val) // Convert float64 to int
+				leaves[key] = int(val) // Convert float64 to int
 			}
 		}
+		oram.posMap = newMemoryPositionMap(leaves)
 	} else {
 		fmt.Println("Error: Keymap data is not of expected type")
 	}
@@ -331,5 +535,5 @@ func (oram *ORAM) loadSnapshotMaps(snapLocation string) {
 	} else {
 		fmt.Println("Error: StashMap data is not of expected type")
 	}
-	fmt.Println("KeymapSize: ", len(oram.keyMap))
+	fmt.Println("KeymapSize: ", oram.posMap.size())
 }
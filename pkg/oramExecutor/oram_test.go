@@ -6,6 +6,7 @@ import (
 	"log"
 	"reflect"
 	"testing"
+	"time"
 
 	executor "github.com/project/ObliSql/api/oramExecutor"
 
@@ -117,6 +118,155 @@ func generateDataSet() *executor.RequestBatchORAM {
 	return &newReq
 }
 
+func TestNextBatchFlushesPartialBatch(t *testing.T) {
+	e := &MyOram{
+		o:                   newTestBackend(t, BackendPath, NewMemoryStore(), 0),
+		batchSize:           4,
+		batchTimeout:        20 * time.Millisecond,
+		oramExecutorChannel: make(chan *KVPair, 10),
+	}
+	e.oramExecutorChannel <- &KVPair{channelId: "1-1", Key: "K1"}
+	e.oramExecutorChannel <- &KVPair{channelId: "1-1", Key: "K2", Value: "V2"}
+
+	start := time.Now()
+	batch := e.nextBatch()
+	elapsed := time.Since(start)
+
+	if len(batch) != e.batchSize {
+		t.Fatalf("Expected padded batch of %d, got %d", e.batchSize, len(batch))
+	}
+	if batch[0].Key != "K1" || batch[1].Key != "K2" || batch[1].Value != "V2" {
+		t.Errorf("Real operations not at the front of the batch: %v, %v", batch[0], batch[1])
+	}
+	for _, op := range batch[2:] {
+		if op.Key != dummyKey || op.channelId != "" || op.Value != "" {
+			t.Errorf("Expected dummy GET, got %+v", op)
+		}
+	}
+	if elapsed < e.batchTimeout {
+		t.Errorf("Partial batch flushed after %v, before the %v timeout", elapsed, e.batchTimeout)
+	}
+}
+
+func TestNextBatchFullBatchSkipsTimeout(t *testing.T) {
+	e := &MyOram{
+		o:                   newTestBackend(t, BackendPath, NewMemoryStore(), 0),
+		batchSize:           3,
+		batchTimeout:        time.Hour,
+		oramExecutorChannel: make(chan *KVPair, 10),
+	}
+	for i := 0; i < 5; i++ {
+		e.oramExecutorChannel <- &KVPair{channelId: "1-1", Key: fmt.Sprintf("K%d", i)}
+	}
+
+	done := make(chan []*KVPair)
+	go func() { done <- e.nextBatch() }()
+
+	select {
+	case batch := <-done:
+		for i, op := range batch {
+			if op.Key != fmt.Sprintf("K%d", i) {
+				t.Errorf("Expected K%d at position %d, got %s", i, i, op.Key)
+			}
+		}
+		if len(e.oramExecutorChannel) != 2 {
+			t.Errorf("Expected 2 operations left for the next batch, got %d", len(e.oramExecutorChannel))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Full batch waited for the timeout")
+	}
+}
+
+func TestNextBatchBlocksWhenIdle(t *testing.T) {
+	e := &MyOram{
+		o:                   newTestBackend(t, BackendPath, NewMemoryStore(), 0),
+		batchSize:           2,
+		batchTimeout:        time.Millisecond,
+		oramExecutorChannel: make(chan *KVPair, 10),
+	}
+
+	done := make(chan []*KVPair)
+	go func() { done <- e.nextBatch() }()
+
+	select {
+	case <-done:
+		t.Fatal("nextBatch returned without any queued operation")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	e.oramExecutorChannel <- &KVPair{channelId: "1-1", Key: "K1"}
+	select {
+	case batch := <-done:
+		if batch[0].Key != "K1" || batch[1].Key != dummyKey {
+			t.Errorf("Unexpected batch: %v, %v", batch[0], batch[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Partial batch never completed")
+	}
+}
+
+// batchSizeBackend records the size of every batch the ORAM executes.
+type batchSizeBackend struct {
+	Backend
+	sizes chan int
+}
+
+func (b *batchSizeBackend) Batching(requests []Request, batchSize int) ([]string, error) {
+	b.sizes <- len(requests)
+	return b.Backend.Batching(requests, batchSize)
+}
+
+func TestPartialBatchCompletes(t *testing.T) {
+	backend, client := newPipelinedBackend(t, BackendPath, NewMemoryStore(), 0, 0)
+	o := &batchSizeBackend{Backend: backend, sizes: make(chan int, 10)}
+	e := newMyOram(o, client, 4, 20*time.Millisecond, "")
+	go e.processBatches()
+
+	done := make(chan *executor.RespondBatchORAM)
+	start := time.Now()
+	go func() {
+		resp, err := e.ExecuteBatch(context.Background(), &executor.RequestBatchORAM{
+			RequestId: 1,
+			Keys:      []string{"K1"},
+			Values:    []string{"V1"},
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+
+	select {
+	case resp := <-done:
+		if elapsed := time.Since(start); elapsed < e.batchTimeout {
+			t.Errorf("Partial batch completed after %v, before the %v timeout", elapsed, e.batchTimeout)
+		}
+		if resp == nil || resp.Values[0] != "V1" {
+			t.Fatalf("Unexpected response: %v", resp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Partial batch never completed")
+	}
+	if size := <-o.sizes; size != e.batchSize {
+		t.Errorf("Executed a batch of %d, want it padded to %d", size, e.batchSize)
+	}
+}
+
+func TestDummyKeyReserved(t *testing.T) {
+	e := newMyOram(newTestBackend(t, BackendPath, NewMemoryStore(), 0), nil, 2, time.Millisecond, "")
+	_, err := e.ExecuteBatch(context.Background(), &executor.RequestBatchORAM{
+		RequestId: 1,
+		Keys:      []string{"K1", dummyKey},
+		Values:    []string{"", "V"},
+	})
+	if err == nil {
+		t.Fatal("Request for the dummy key was accepted")
+	}
+	if len(e.oramExecutorChannel) != 0 {
+		t.Error("Rejected request queued operations")
+	}
+}
+
 func TestExecutor(t *testing.T) {
 	ctx := context.Background()
 	fullAddr := "localhost:9090"
@@ -0,0 +1,126 @@
+package oramexecutor
+
+import (
+	"sync"
+)
+
+// writePipeline writes the values of a tree store behind the ORAM, so reading the paths of
+// the next batch overlaps with writing back the previous one. The ORAM itself stays
+// sequential: values are pending from the moment they are queued until their write is done,
+// and reads take pending values from memory instead of the store. Every read therefore sees
+// the latest value, the same as if each write had completed before the next read, no matter
+// which buckets consecutive batches share. A single writer issues the writes in the order
+// they were queued, so an older write never overwrites a newer one.
+type writePipeline struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string]pendingValue // Values queued but not written yet
+	queued  uint64                  // Sequence number of the last queued write
+	written uint64                  // Sequence number of the last finished write
+	err     error                   // First failed write, the store no longer matches the ORAM after it
+
+	queue chan pipelineWrite // nil while writes are synchronous
+}
+
+type pendingValue struct {
+	value []byte
+	seq   uint64
+}
+
+type pipelineWrite struct {
+	keys   []string
+	values [][]byte
+	seq    uint64
+}
+
+func newWritePipeline() *writePipeline {
+	p := &writePipeline{pending: make(map[string]pendingValue)}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// start makes writes asynchronous. At most depth writes are in flight while the ORAM moves
+// on, queueing another one waits for the oldest to finish.
+func (p *writePipeline) start(depth int, write func(keys []string, values [][]byte) error) {
+	if depth <= 0 || p.queue != nil {
+		return
+	}
+	p.queue = make(chan pipelineWrite, depth-1) // The writer holds one more
+	go func() {
+		for w := range p.queue {
+			err := write(w.keys, w.values)
+
+			p.mu.Lock()
+			if err != nil && p.err == nil {
+				p.err = err
+			}
+			for _, key := range w.keys {
+				// A later write of the same key is still pending
+				if p.pending[key].seq == w.seq {
+					delete(p.pending, key)
+				}
+			}
+			p.written = w.seq
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		}
+	}()
+}
+
+func (p *writePipeline) active() bool {
+	return p.queue != nil
+}
+
+// enqueue queues the plaintext values for writing.
+func (p *writePipeline) enqueue(keys []string, values [][]byte) error {
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return p.err
+	}
+	p.queued++
+	seq := p.queued
+	for i, key := range keys {
+		p.pending[key] = pendingValue{value: values[i], seq: seq}
+	}
+	p.mu.Unlock()
+
+	p.queue <- pipelineWrite{keys: keys, values: values, seq: seq}
+	return nil
+}
+
+// lookup returns copies of the pending values of keys, nil for keys that are not pending.
+func (p *writePipeline) lookup(keys []string) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if pending, ok := p.pending[key]; ok {
+			values[i] = append([]byte(nil), pending.value...)
+		}
+	}
+	return values, nil
+}
+
+// barrier returns a function that waits until every write queued so far is in the store.
+func (p *writePipeline) barrier() func() error {
+	p.mu.Lock()
+	seq := p.queued
+	p.mu.Unlock()
+	return func() error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for p.written < seq && p.err == nil {
+			p.cond.Wait()
+		}
+		return p.err
+	}
+}
+
+// drain waits until every queued write is in the store.
+func (p *writePipeline) drain() error {
+	return p.barrier()()
+}
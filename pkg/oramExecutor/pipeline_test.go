@@ -0,0 +1,245 @@
+package oramexecutor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	executor "github.com/project/ObliSql/api/oramExecutor"
+)
+
+// slowStore delays writes and counts reads issued while a write is in progress.
+type slowStore struct {
+	*MemoryStore
+	delay    time.Duration
+	writing  atomic.Int32
+	overlaps atomic.Int64
+}
+
+func (s *slowStore) Set(keys []string, values [][]byte) error {
+	s.writing.Add(1)
+	defer s.writing.Add(-1)
+	time.Sleep(s.delay)
+	return s.MemoryStore.Set(keys, values)
+}
+
+func (s *slowStore) Get(keys []string) ([][]byte, error) {
+	if s.writing.Load() > 0 {
+		s.overlaps.Add(1)
+	}
+	return s.MemoryStore.Get(keys)
+}
+
+func newPipelinedBackend(t *testing.T, backend string, store BucketStore, positionCutoff, depth int) (Backend, *TreeStore) {
+	t.Helper()
+	client := NewTreeStore(store, testKey, 0, false)
+	client.Pipeline(depth)
+	o, err := newBackend(client, testConfig(backend, positionCutoff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, client
+}
+
+func TestPipelinedBatchesMatchMap(t *testing.T) {
+	for _, backend := range []string{BackendPath, BackendRing} {
+		for _, cutoff := range []int{0, 16} {
+			t.Run(fmt.Sprintf("%s/cutoff=%d", backend, cutoff), func(t *testing.T) {
+				store := &slowStore{MemoryStore: NewMemoryStore(), delay: 200 * time.Microsecond}
+				o, client := newPipelinedBackend(t, backend, store, cutoff, 2)
+
+				// Few keys, so consecutive batches keep accessing the same blocks
+				expected := make(map[string]string)
+				for b := 0; b < 150; b++ {
+					requests := make([]Request, 8)
+					for i := range requests {
+						requests[i] = Request{Key: fmt.Sprintf("K%d", GetRandomInt(6))}
+						if GetRandomInt(2) == 0 {
+							requests[i].Value = fmt.Sprintf("V%d-%d", b, i)
+						}
+					}
+					values, err := o.Batching(requests, len(requests))
+					if err != nil {
+						t.Fatalf("batch %d: %v", b, err)
+					}
+					for i, req := range requests {
+						if req.Value != "" {
+							expected[req.Key] = req.Value
+						}
+						want, ok := expected[req.Key]
+						if !ok {
+							want = "-1"
+						}
+						if values[i] != want {
+							t.Fatalf("batch %d request %d (%s): got %q, want %q", b, i, req.Key, values[i], want)
+						}
+					}
+				}
+
+				if err := client.drainWrites(); err != nil {
+					t.Fatal(err)
+				}
+				if store.overlaps.Load() == 0 {
+					t.Error("no read overlapped a write")
+				}
+				// Once drained, the store alone holds the state
+				runRandomBatches(t, o, expected, 20, 8)
+			})
+		}
+	}
+}
+
+type historyOp struct {
+	key, value string
+	write      bool
+	inv, resp  int64
+}
+
+// checkRegisterHistory checks a history of reads and writes of unique values against the
+// anomalies a linearizable register cannot show: reading a value before it was written,
+// reading a value that was overwritten before the read started, and a read returning an
+// older value than a read that finished before it started.
+func checkRegisterHistory(t *testing.T, history []historyOp) {
+	t.Helper()
+	writes := make(map[string]historyOp)
+	for _, op := range history {
+		if op.write {
+			writes[op.key+"="+op.value] = op
+		}
+	}
+	// source returns the write a read observed, ok is false for the initial value
+	source := func(r historyOp) (historyOp, bool) {
+		if r.value == "-1" {
+			return historyOp{}, false
+		}
+		w, found := writes[r.key+"="+r.value]
+		if !found {
+			t.Fatalf("read of %s returned %q, which was never written", r.key, r.value)
+		}
+		return w, true
+	}
+
+	for _, r := range history {
+		if r.write {
+			continue
+		}
+		w, ok := source(r)
+		if ok && r.resp < w.inv {
+			t.Errorf("read of %s returned %q before it was written", r.key, r.value)
+		}
+		for _, other := range history {
+			if !other.write || other.key != r.key || other.value == r.value || other.resp > r.inv {
+				continue
+			}
+			// other finished before the read started, the read must not observe anything older
+			if !ok || w.resp < other.inv {
+				t.Errorf("read of %s returned %q although %q was written after it and before the read", r.key, r.value, other.value)
+			}
+		}
+		for _, earlier := range history {
+			if earlier.write || earlier.key != r.key || earlier.resp > r.inv || earlier.value == r.value {
+				continue
+			}
+			ew, eok := source(earlier)
+			if eok && (!ok || w.resp < ew.inv) {
+				t.Errorf("read of %s returned %q after an earlier read returned the newer %q", r.key, r.value, earlier.value)
+			}
+		}
+	}
+}
+
+func TestPipelinedExecutorLinearizable(t *testing.T) {
+	for _, depth := range []int{0, 3} {
+		t.Run(fmt.Sprintf("depth=%d", depth), func(t *testing.T) {
+			store := &slowStore{MemoryStore: NewMemoryStore(), delay: 300 * time.Microsecond}
+			o, client := newPipelinedBackend(t, BackendPath, store, 0, depth)
+			e := newMyOram(o, client, 4, time.Millisecond, "")
+			go e.processBatches()
+
+			const clients, opsPerClient = 8, 40
+			var clock atomic.Int64
+			histories := make([][]historyOp, clients)
+			var wg sync.WaitGroup
+			for c := 0; c < clients; c++ {
+				wg.Add(1)
+				go func(c int) {
+					defer wg.Done()
+					for i := 0; i < opsPerClient; i++ {
+						op := historyOp{key: fmt.Sprintf("K%d", GetRandomInt(3))}
+						if GetRandomInt(2) == 0 {
+							op.write = true
+							op.value = fmt.Sprintf("c%d-%d", c, i)
+						}
+						op.inv = clock.Add(1)
+						resp, err := e.ExecuteBatch(context.Background(), &executor.RequestBatchORAM{
+							RequestId: int64(c),
+							Keys:      []string{op.key},
+							Values:    []string{op.value},
+						})
+						op.resp = clock.Add(1)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if op.write && resp.Values[0] != op.value {
+							t.Errorf("write of %q returned %q", op.value, resp.Values[0])
+						}
+						op.value = resp.Values[0]
+						histories[c] = append(histories[c], op)
+					}
+				}(c)
+			}
+			wg.Wait()
+
+			var history []historyOp
+			for _, h := range histories {
+				history = append(history, h...)
+			}
+			checkRegisterHistory(t, history)
+			if depth > 0 && store.overlaps.Load() == 0 {
+				t.Error("no read overlapped a write")
+			}
+		})
+	}
+}
+
+func TestPipelinedExecutorMultiKey(t *testing.T) {
+	// Requests of 10 keys span three batches of 4, whose replies are sent by separate goroutines
+	store := &slowStore{MemoryStore: NewMemoryStore(), delay: 300 * time.Microsecond}
+	o, client := newPipelinedBackend(t, BackendPath, store, 0, 3)
+	e := newMyOram(o, client, 4, time.Millisecond, "")
+	go e.processBatches()
+
+	const clients, keys = 4, 10
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			req := &executor.RequestBatchORAM{RequestId: int64(c), Keys: make([]string, keys), Values: make([]string, keys)}
+			for i := range req.Keys {
+				req.Keys[i] = fmt.Sprintf("c%d-K%d", c, i)
+				req.Values[i] = fmt.Sprintf("c%d-V%d", c, i)
+			}
+			for round := 0; round < 10; round++ {
+				resp, err := e.ExecuteBatch(context.Background(), req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for i, key := range req.Keys {
+					if resp.Keys[i] != key || resp.Values[i] != fmt.Sprintf("c%d-V%d", c, i) {
+						t.Errorf("reply %d to %s is %s = %q", i, key, resp.Keys[i], resp.Values[i])
+						return
+					}
+				}
+				// Read the keys back
+				req.Values = make([]string, keys)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,284 @@
+package oramexecutor
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Position blocks of a recursive position map have 2^positionsPerBlockLog slots. A key hashes
+// to a block and a first slot in it, and takes the first slot holding its fingerprint or
+// empty from there. Each level has 2^positionShrinkLog times fewer blocks than the level
+// above has leaves, so blocks are about a quarter full and practically never fill up.
+const (
+	positionsPerBlockLog = 5
+	positionShrinkLog    = 3
+	positionSlotSize     = 12 // Fingerprint (8) | leaf (4), little endian
+	positionBlockKeyLen  = 8  // Block index in fixed width hex
+)
+
+// positionValueSize is the length of a position block value, hex encoded so it survives
+// the JSON of checkpoints. positionBlockSize is the encoded size of every position block,
+// inner trees pad their blocks to it whatever the block size of the data tree.
+const (
+	positionValueSize = 2 * positionSlotSize << positionsPerBlockLog
+	positionBlockSize = blockOverhead + positionBlockKeyLen + positionValueSize
+)
+
+// positionMap tracks the leaf every key is mapped to.
+type positionMap interface {
+	// access returns the current leaf of every key and whether the key was mapped, and maps
+	// each key to newLeaves[i]. Unmapped keys are only added when insert[i] is set.
+	access(keys []string, newLeaves []int, insert []bool) ([]int, []bool, error)
+	// cachedLeaf returns the leaf of key if it is held in memory. Otherwise the leaf stored
+	// with the block is authoritative.
+	cachedLeaf(key string) (int, bool)
+	// save and restore move the map to and from a checkpoint.
+	save(state *oramState)
+	restore(state oramState) error
+	reset()
+	size() int
+}
+
+// oramState is the trusted state of an ORAM. Positions holds the state of the ORAM storing
+// the position map when it is recursive, in which case Keymap is empty. Evictions and
+// Accesses are the eviction schedule of Ring ORAM.
+type oramState struct {
+	Keymap    map[string]int `json:",omitempty"`
+	StashMap  map[string]Block
+	Positions *oramState `json:",omitempty"`
+	Evictions int64      `json:",omitempty"`
+	Accesses  int        `json:",omitempty"`
+	Root      []byte     `json:",omitempty"` // Trusted Merkle root of an integrity protected tree
+}
+
+// newPositionMap keeps the position map of a tree with 2^logCapacity leaves in memory if it
+// has at most cutoff entries, and otherwise stores it in a smaller ORAM under its own store
+// key prefix, recursing until a level fits under the cutoff. A cutoff of 0 disables recursion.
+func newPositionMap(client *TreeStore, logCapacity, z, stashSize, cutoff, level int) positionMap {
+	if cutoff <= 0 || 1<<logCapacity <= cutoff || logCapacity <= positionShrinkLog {
+		return newMemoryPositionMap(nil)
+	}
+	innerLog := logCapacity - positionShrinkLog
+	inner := &ORAM{
+		Store:       client.withPrefix(fmt.Sprintf("pos%d:bucket:", level), positionBlockSize),
+		LogCapacity: innerLog,
+		Z:           z,
+		StashSize:   stashSize,
+		StashMap:    make(map[string]Block),
+	}
+	inner.posMap = newPositionMap(client, innerLog, z, stashSize, cutoff, level+1)
+	return &recursivePositionMap{oram: inner, blocks: 1 << innerLog}
+}
+
+// positionLevels returns the number of ORAM trees the position map is spread over.
+func positionLevels(p positionMap) int {
+	if r, ok := p.(*recursivePositionMap); ok {
+		return 1 + positionLevels(r.oram.posMap)
+	}
+	return 0
+}
+
+// memoryPositionMap is the plain in-memory position map.
+type memoryPositionMap struct {
+	leaves map[string]int
+}
+
+func newMemoryPositionMap(leaves map[string]int) *memoryPositionMap {
+	if leaves == nil {
+		leaves = make(map[string]int)
+	}
+	return &memoryPositionMap{leaves: leaves}
+}
+
+func (m *memoryPositionMap) access(keys []string, newLeaves []int, insert []bool) ([]int, []bool, error) {
+	oldLeaves := make([]int, len(keys))
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		oldLeaves[i], found[i] = m.leaves[key]
+		if found[i] || insert[i] {
+			m.leaves[key] = newLeaves[i]
+		}
+	}
+	return oldLeaves, found, nil
+}
+
+func (m *memoryPositionMap) cachedLeaf(key string) (int, bool) {
+	leaf, ok := m.leaves[key]
+	return leaf, ok
+}
+
+func (m *memoryPositionMap) save(state *oramState) {
+	state.Keymap = m.leaves
+}
+
+func (m *memoryPositionMap) restore(state oramState) error {
+	if state.Positions != nil {
+		return fmt.Errorf("checkpoint has a recursive position map, start with a matching position map cutoff")
+	}
+	m.leaves = state.Keymap
+	if m.leaves == nil {
+		m.leaves = make(map[string]int)
+	}
+	return nil
+}
+
+func (m *memoryPositionMap) reset() {
+	m.leaves = make(map[string]int)
+}
+
+func (m *memoryPositionMap) size() int {
+	return len(m.leaves)
+}
+
+// recursivePositionMap hashes keys into fixed size blocks of an inner ORAM, see
+// positionsPerBlockLog. Slots hold a fingerprint of the key instead of the key itself, so
+// every block encodes to the same length however many keys it maps.
+type recursivePositionMap struct {
+	oram   *ORAM
+	blocks int
+}
+
+// slotOf returns the block of key, its fingerprint, never 0 which marks an empty slot, and
+// the first slot to probe.
+func (r *recursivePositionMap) slotOf(key string) (string, uint64, int) {
+	x := keyFingerprint(key)
+	blockKey := fmt.Sprintf("%0*x", positionBlockKeyLen, x%uint64(r.blocks))
+	return blockKey, x, int(x>>32) & (1<<positionsPerBlockLog - 1)
+}
+
+// positionSlot is one entry of a position block, an empty slot has fingerprint 0.
+type positionSlot struct {
+	fingerprint uint64
+	leaf        int
+}
+
+func decodePositionBlock(value string) ([]positionSlot, error) {
+	if len(value) != positionValueSize {
+		return nil, fmt.Errorf("position block of %d bytes, expected %d", len(value), positionValueSize)
+	}
+	data, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]positionSlot, 1<<positionsPerBlockLog)
+	for i := range slots {
+		slots[i].fingerprint = binary.LittleEndian.Uint64(data[i*positionSlotSize:])
+		slots[i].leaf = int(binary.LittleEndian.Uint32(data[i*positionSlotSize+8:]))
+	}
+	return slots, nil
+}
+
+func encodePositionBlock(slots []positionSlot) string {
+	buf := make([]byte, positionSlotSize<<positionsPerBlockLog)
+	for i, slot := range slots {
+		binary.LittleEndian.PutUint64(buf[i*positionSlotSize:], slot.fingerprint)
+		binary.LittleEndian.PutUint32(buf[i*positionSlotSize+8:], uint32(slot.leaf))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// findSlot returns the slot of fingerprint in slots, or the empty slot it would take, probing
+// from start. It returns -1 if the fingerprint is absent and the block is full.
+func findSlot(slots []positionSlot, fingerprint uint64, start int) int {
+	for i := 0; i < len(slots); i++ {
+		slot := (start + i) % len(slots)
+		if slots[slot].fingerprint == fingerprint || slots[slot].fingerprint == 0 {
+			return slot
+		}
+	}
+	return -1
+}
+
+func (r *recursivePositionMap) access(keys []string, newLeaves []int, insert []bool) ([]int, []bool, error) {
+	blockOf := make([]string, len(keys))
+	fingerprints := make([]uint64, len(keys))
+	starts := make([]int, len(keys))
+	seen := make(map[string]struct{})
+	var blockKeys []string
+	for i, key := range keys {
+		blockOf[i], fingerprints[i], starts[i] = r.slotOf(key)
+		if _, ok := seen[blockOf[i]]; !ok {
+			seen[blockOf[i]] = struct{}{}
+			blockKeys = append(blockKeys, blockOf[i])
+		}
+	}
+	create := make([]bool, len(blockKeys))
+	for i := range create {
+		create[i] = true
+	}
+
+	oldLeaves := make([]int, len(keys))
+	found := make([]bool, len(keys))
+	var accessErr error
+	// Keys sharing a block cost a random path each, so the inner ORAM always reads one path per key
+	err := r.oram.accessKeys(blockKeys, create, len(keys)-len(blockKeys), func() {
+		positions := make(map[string][]positionSlot, len(blockKeys))
+		for _, blockKey := range blockKeys {
+			slots := make([]positionSlot, 1<<positionsPerBlockLog)
+			if block, ok := r.oram.StashMap[blockKey]; ok {
+				var err error
+				if slots, err = decodePositionBlock(block.Value); err != nil {
+					accessErr = fmt.Errorf("corrupt position block %s: %w", blockKey, err)
+					return
+				}
+			}
+			positions[blockKey] = slots
+		}
+		// The decoded slots are copies, so a failed insert leaves every position block as it was
+		for i := range keys {
+			slots := positions[blockOf[i]]
+			slot := findSlot(slots, fingerprints[i], starts[i])
+			if slot < 0 {
+				if insert[i] {
+					accessErr = fmt.Errorf("position block %s is full", blockOf[i])
+					return
+				}
+				continue
+			}
+			if slots[slot].fingerprint == fingerprints[i] {
+				oldLeaves[i], found[i] = slots[slot].leaf, true
+			}
+			if found[i] || insert[i] {
+				slots[slot] = positionSlot{fingerprint: fingerprints[i], leaf: newLeaves[i]}
+			}
+		}
+		for blockKey, slots := range positions {
+			r.oram.StashMap[blockKey] = Block{Key: blockKey, Value: encodePositionBlock(slots)}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if accessErr != nil {
+		return nil, nil, accessErr
+	}
+	return oldLeaves, found, nil
+}
+
+func (r *recursivePositionMap) cachedLeaf(key string) (int, bool) {
+	return 0, false
+}
+
+func (r *recursivePositionMap) save(state *oramState) {
+	inner := r.oram.state()
+	state.Positions = &inner
+}
+
+func (r *recursivePositionMap) restore(state oramState) error {
+	if state.Positions == nil {
+		return fmt.Errorf("checkpoint has an in-memory position map, start with a matching position map cutoff")
+	}
+	return r.oram.restore(*state.Positions)
+}
+
+// reset forgets every position. Blocks left in the trees become unreachable.
+func (r *recursivePositionMap) reset() {
+	r.oram.StashMap = make(map[string]Block)
+	r.oram.posMap.reset()
+}
+
+// size is the number of position blocks held in the stash and the position map below.
+func (r *recursivePositionMap) size() int {
+	return len(r.oram.StashMap) + r.oram.posMap.size()
+}
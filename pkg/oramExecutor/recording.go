@@ -0,0 +1,67 @@
+package oramexecutor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	accesstrace "github.com/project/ObliSql/pkg/accessTrace"
+)
+
+// recordingStore passes every access through to a BucketStore and remembers the storage
+// keys read and written, so they can be recorded with the batch that caused them.
+type recordingStore struct {
+	BucketStore
+	mu     sync.Mutex
+	reads  []string
+	writes []string
+}
+
+func (s *recordingStore) Get(keys []string) ([][]byte, error) {
+	s.mu.Lock()
+	s.reads = append(s.reads, keys...)
+	s.mu.Unlock()
+	return s.BucketStore.Get(keys)
+}
+
+func (s *recordingStore) Set(keys []string, values [][]byte) error {
+	s.mu.Lock()
+	s.writes = append(s.writes, keys...)
+	s.mu.Unlock()
+	return s.BucketStore.Set(keys, values)
+}
+
+// take returns the keys accessed since the last call.
+func (s *recordingStore) take() (reads, writes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reads, writes = s.reads, s.writes
+	s.reads, s.writes = nil, nil
+	return reads, writes
+}
+
+// recordBatch appends a batch started at start to the access trace. Requested keys are
+// hashed, dummy accesses and the batcher's fake requests are counted as fake. The storage
+// accesses are those since the previous batch, so they include the eviction while idle and,
+// with pipelined writes, write-backs queued by earlier batches.
+func (e *MyOram) recordBatch(batch []*KVPair, start time.Time) {
+	b := &accesstrace.Batch{
+		Time:     start,
+		Executor: e.traceExecutor,
+		ID:       e.traceID,
+		Keys:     make([]string, len(batch)),
+		Duration: time.Since(start),
+	}
+	e.traceID++
+	for i, op := range batch {
+		if op.Key == dummyKey || op.Key == accesstrace.FakeKey {
+			b.Fake++
+			continue
+		}
+		b.Keys[i] = accesstrace.KeyID(op.Key)
+	}
+	b.Reads, b.Writes = e.recorder.take()
+	if err := e.trace.Record(b); err != nil {
+		fmt.Printf("Access trace error: %v\n", err)
+	}
+}
@@ -0,0 +1,68 @@
+package oramexecutor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	executor "github.com/project/ObliSql/api/oramExecutor"
+	accesstrace "github.com/project/ObliSql/pkg/accessTrace"
+)
+
+func TestAccessTrace(t *testing.T) {
+	dir := t.TempDir()
+	tracefile := filepath.Join(dir, "trace.txt")
+	if err := os.WriteFile(tracefile, []byte("SET K1 V1\nSET K2 V2\nSET K3 V3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "access.jsonl")
+	w, err := accesstrace.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := NewORAM(Config{
+		BackendConfig: testConfig(BackendPath, 0),
+		Tracefile:     tracefile,
+		BatchSize:     4,
+		BatchTimeout:  time.Millisecond,
+		AccessTrace:   w,
+		TraceExecutor: 2,
+	}, NewMemoryStore(), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One batch: a real key, a fake request from the batcher and two dummy accesses
+	resp, err := e.ExecuteBatch(context.Background(), &executor.RequestBatchORAM{
+		RequestId: 1,
+		Keys:      []string{"K1", accesstrace.FakeKey},
+		Values:    []string{"", ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Values[0] != "V1" {
+		t.Fatalf("K1 = %q", resp.Values[0])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	batches, err := accesstrace.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batches) != 1 {
+		t.Fatalf("recorded %d batches, want 1 without the loading traffic", len(batches))
+	}
+	b := batches[0]
+	if b.Executor != 2 || len(b.Keys) != 4 || b.Fake != 3 || b.Keys[0] != accesstrace.KeyID("K1") {
+		t.Errorf("recorded %+v", b)
+	}
+	// Every access reads and writes back a whole path
+	if levels := testConfig(BackendPath, 0).LogCapacity + 1; len(b.Reads) < levels || len(b.Writes) < levels {
+		t.Errorf("recorded %d reads and %d writes, want at least a path of %d buckets", len(b.Reads), len(b.Writes), levels)
+	}
+}
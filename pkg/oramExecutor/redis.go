@@ -2,19 +2,21 @@ package oramexecutor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"github.com/redis/go-redis/v9"
 )
 
-type RedisClient struct {
-	Client        *redis.Client
-	EncryptionKey []byte
-	Ctx           context.Context
+// checkpointEpochKey holds the epoch of the last ORAM checkpoint whose bucket state Redis saved.
+const checkpointEpochKey = "oram:checkpoint_epoch"
+
+// RedisStore is a BucketStore on a Redis server, using MGET and MSET for bulk access.
+type RedisStore struct {
+	Client *redis.Client
+	Ctx    context.Context
 }
 
-func NewRedisClient(redisAddr string, encryptionKey []byte) (*RedisClient, error) {
+func NewRedisStore(redisAddr string) (*RedisStore, error) {
 	ctx := context.Background()
 	client := redis.NewClient(&redis.Options{
 		Addr: redisAddr,
@@ -24,131 +26,68 @@ func NewRedisClient(redisAddr string, encryptionKey []byte) (*RedisClient, error
 		return nil, err
 	}
 
-	return &RedisClient{
-		Client:        client,
-		EncryptionKey: encryptionKey,
-		Ctx:           ctx,
+	return &RedisStore{
+		Client: client,
+		Ctx:    ctx,
 	}, nil
 }
 
-func (r *RedisClient) FlushDB() error {
-	return r.Client.FlushDB(r.Ctx).Err()
-}
-
-func (r *RedisClient) FlushData() {
-	ctx := context.Background()
-	r.Client.FlushAll(ctx)
-}
-
-func (r *RedisClient) WriteBucketsToDb(requests []BucketRequest) error {
-	// Prepare data for MSET
-	msetArgs := make([]interface{}, 0, len(requests)*2)
-
-	for _, req := range requests {
-		data, err := json.Marshal(req.Bucket)
-		if err != nil {
-			return err
-		}
-
-		encryptedData, err := Encrypt(data, r.EncryptionKey)
-		if err != nil {
-			return err
-		}
-
-		key := fmt.Sprintf("bucket:%d", req.BucketId)
-		msetArgs = append(msetArgs, key, encryptedData)
-	}
-
-	// Use MSET to set multiple key-value pairs at once
-	_, err := r.Client.MSet(r.Ctx, msetArgs...).Result() // Use MSet method directly
-	return err
-}
-
-func (r *RedisClient) ReadBucketsFromDb(indices map[int]struct{}) (map[int]Bucket, error) {
-	// Convert map keys to slice for MGET
-	keys := make([]string, 0, len(indices))
-	for index := range indices {
-		keys = append(keys, fmt.Sprintf("bucket:%d", index))
+func (r *RedisStore) Get(keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, nil
 	}
-
-	// Perform MGET operation
 	data, err := r.Client.MGet(r.Ctx, keys...).Result()
 	if err != nil {
 		return nil, err
 	}
-
-	// Initialize a map to store the retrieved buckets
-	buckets := make(map[int]Bucket, len(indices))
-
-	// Iterate over the retrieved data
+	values := make([][]byte, len(keys))
 	for i, raw := range data {
 		if raw == nil {
 			continue
 		}
-		encryptedData, ok := raw.(string)
+		value, ok := raw.(string)
 		if !ok {
 			return nil, fmt.Errorf("unexpected data type: %T", raw)
 		}
-
-		// Decrypt the data
-		decryptedData, err := Decrypt([]byte(encryptedData), r.EncryptionKey)
-		if err != nil {
-			return nil, err
-		}
-
-		// Unmarshal the decrypted data into a bucket
-		var bucket1 Bucket
-		err = json.Unmarshal(decryptedData, &bucket1)
-		if err != nil {
-			return nil, err
-		}
-
-		// Map the bucket to the corresponding index
-		var index int
-		fmt.Sscanf(keys[i], "bucket:%d", &index)
-		buckets[index] = bucket1
+		values[i] = []byte(value)
 	}
-
-	return buckets, nil
+	return values, nil
 }
 
-func (r *RedisClient) WriteBucketToDb(index int, bucket Bucket) error {
-	data, err := json.Marshal(bucket)
-	if err != nil {
-		return err
+func (r *RedisStore) Set(keys []string, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
 	}
-
-	encryptedData, err := Encrypt(data, r.EncryptionKey)
-	if err != nil {
-		return err
+	msetArgs := make([]interface{}, 0, len(keys)*2)
+	for i, key := range keys {
+		msetArgs = append(msetArgs, key, values[i])
 	}
-
-	key := fmt.Sprintf("bucket:%d", index)
-	err = r.Client.Set(r.Ctx, key, encryptedData, 0).Err()
-	return err
+	// Use MSET to set multiple key-value pairs at once
+	return r.Client.MSet(r.Ctx, msetArgs...).Err()
 }
 
-func (r *RedisClient) ReadBucketFromDb(index int) (Bucket, error) {
-	key := fmt.Sprintf("bucket:%d", index)
-	data, err := r.Client.Get(r.Ctx, key).Bytes()
-	if err != nil {
-		return Bucket{}, err
-	}
+func (r *RedisStore) Flush() error {
+	return r.Client.FlushDB(r.Ctx).Err()
+}
 
-	decryptedData, err := Decrypt(data, r.EncryptionKey)
-	if err != nil {
-		return Bucket{}, err
+// SaveCheckpointEpoch records the checkpoint epoch next to the buckets and synchronously
+// saves the dataset, so the dump on disk matches the checkpoint file with the same epoch.
+func (r *RedisStore) SaveCheckpointEpoch(epoch int64) error {
+	if err := r.Client.Set(r.Ctx, checkpointEpochKey, epoch, 0).Err(); err != nil {
+		return err
 	}
+	return r.Client.Save(r.Ctx).Err()
+}
 
-	var bucket1 Bucket
-	err = json.Unmarshal(decryptedData, &bucket1)
-	if err != nil {
-		return Bucket{}, err
+// CheckpointEpoch returns the epoch of the last saved checkpoint, or 0 if there is none.
+func (r *RedisStore) CheckpointEpoch() (int64, error) {
+	epoch, err := r.Client.Get(r.Ctx, checkpointEpochKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
 	}
-
-	return bucket1, nil
+	return epoch, err
 }
 
-func (r *RedisClient) Close() error {
+func (r *RedisStore) Close() error {
 	return r.Client.Close()
 }
@@ -0,0 +1,492 @@
+package oramexecutor
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	ringMetaPrefix = "ring:meta:"
+	ringSlotPrefix = "ring:slot:"
+)
+
+// ringMeta is the metadata Ring ORAM keeps per bucket, encrypted next to its slots. It
+// records the fingerprint of the key in every slot (0 for dummies), which slots have not
+// been read since the bucket was last written, and how many accesses read from the bucket since.
+type ringMeta struct {
+	Keys  []uint64
+	Valid []bool
+	Count int
+}
+
+// slotFingerprint is the fingerprint metadata records for a slot holding key.
+func slotFingerprint(key string) uint64 {
+	if key == "-1" {
+		return 0
+	}
+	return keyFingerprint(key)
+}
+
+// RingORAM is Ring ORAM over a BucketStore. Every bucket holds up to Z real blocks among Z+S slots
+// in a random order. An access reads a single slot from each bucket on the path, the slot
+// of the requested block or an unread dummy, and every A accesses a path chosen in reverse
+// lexicographic order is evicted. Buckets whose dummies run out are reshuffled early.
+type RingORAM struct {
+	LogCapacity int // height of the tree
+	Z           int // Real blocks per bucket
+	S           int // Dummy slots per bucket, the number of reads a bucket serves between rewrites
+	A           int // Accesses between evictions
+	StashSize   int // Maximum number of blocks the stash can hold
+	Store       *TreeStore
+	StashMap    map[string]Block
+	posMap      positionMap
+	metrics     stashMetrics
+
+	evictions int64 // Evictions so far, selects the next eviction path
+	accesses  int   // Accesses since the last eviction
+
+	checkpointEpoch int64 // Epoch of the last checkpoint written or recovered
+}
+
+// ringRound collects the metadata, slot reads and bucket rewrites of one round trip.
+type ringRound struct {
+	metas   map[int]*ringMeta
+	reads   map[int][]int    // Slots read from each bucket
+	rewrite map[int]struct{} // Buckets written back with a fresh permutation
+}
+
+func newRingORAM(client *TreeStore, config BackendConfig) (*RingORAM, error) {
+	z, s, a := config.Z, config.RingDummies, config.RingEvictRate
+	if z < 1 || s < 1 || a < 1 {
+		return nil, fmt.Errorf("ring ORAM needs Z, S and A of at least 1, got %d, %d, %d", z, s, a)
+	}
+	return &RingORAM{
+		LogCapacity: config.LogCapacity,
+		Z:           z,
+		S:           s,
+		A:           a,
+		StashSize:   config.StashSize,
+		Store:       client,
+		StashMap:    make(map[string]Block),
+		posMap:      newPositionMap(client, config.LogCapacity, z, config.StashSize, config.PositionCutoff, 1),
+	}, nil
+}
+
+func newRingRound() *ringRound {
+	return &ringRound{
+		metas:   make(map[int]*ringMeta),
+		reads:   make(map[int][]int),
+		rewrite: make(map[int]struct{}),
+	}
+}
+
+func (o *RingORAM) bucketForLevelLeaf(level, leaf int) int {
+	return ((leaf + (1 << (o.LogCapacity))) >> (o.LogCapacity - level)) - 1
+}
+
+// pathBuckets returns the buckets from the root to leaf.
+func (o *RingORAM) pathBuckets(leaf int) []int {
+	buckets := make([]int, 0, o.LogCapacity+1)
+	for level := 0; level <= o.LogCapacity; level++ {
+		buckets = append(buckets, o.bucketForLevelLeaf(level, leaf))
+	}
+	return buckets
+}
+
+// nextEvictionLeaf returns the next eviction path in reverse lexicographic order, which
+// spreads consecutive evictions evenly over the tree.
+func (o *RingORAM) nextEvictionLeaf() int {
+	g := int(o.evictions % (1 << o.LogCapacity))
+	o.evictions++
+	leaf := 0
+	for i := 0; i < o.LogCapacity; i++ {
+		leaf = leaf<<1 | (g>>i)&1
+	}
+	return leaf
+}
+
+func (o *RingORAM) leafOf(key string, block Block) int {
+	if leaf, ok := o.posMap.cachedLeaf(key); ok {
+		return leaf
+	}
+	return block.Leaf
+}
+
+// emptyMeta describes a bucket that was never written, all its slots are unread dummies.
+func (o *RingORAM) emptyMeta() *ringMeta {
+	meta := &ringMeta{
+		Keys:  make([]uint64, o.Z+o.S),
+		Valid: make([]bool, o.Z+o.S),
+	}
+	for i := range meta.Valid {
+		meta.Valid[i] = true
+	}
+	return meta
+}
+
+func ringSlotKey(bucket, slot int) string {
+	return fmt.Sprintf("%s%d:%d", ringSlotPrefix, bucket, slot)
+}
+
+// loadMetas fetches the metadata of the buckets not yet in the round.
+func (o *RingORAM) loadMetas(r *ringRound, buckets map[int]struct{}) error {
+	var order []int
+	var keys []string
+	for bucket := range buckets {
+		if _, ok := r.metas[bucket]; !ok {
+			order = append(order, bucket)
+			keys = append(keys, fmt.Sprintf("%s%d", ringMetaPrefix, bucket))
+		}
+	}
+	data, err := o.Store.readValues(keys)
+	if err != nil {
+		return fmt.Errorf("failed to read bucket metadata: %w", err)
+	}
+	for i, raw := range data {
+		meta := o.emptyMeta()
+		if raw != nil {
+			if meta, err = decodeRingMeta(raw); err != nil {
+				return fmt.Errorf("corrupt metadata for bucket %d: %w", order[i], err)
+			}
+		}
+		r.metas[order[i]] = meta
+	}
+	return nil
+}
+
+// readSlot reads the slot of key from bucket, or a random unread dummy if the bucket does
+// not hold key, and marks it as read.
+func (o *RingORAM) readSlot(r *ringRound, bucket int, key string) {
+	meta := r.metas[bucket]
+	offset := -1
+	var dummies []int
+	var fingerprint uint64
+	if key != "" {
+		fingerprint = keyFingerprint(key)
+	}
+	for i, k := range meta.Keys {
+		if !meta.Valid[i] {
+			continue
+		}
+		if key != "" && k == fingerprint {
+			offset = i
+			break
+		}
+		if k == 0 {
+			dummies = append(dummies, i)
+		}
+	}
+	if offset < 0 {
+		if len(dummies) == 0 {
+			// Only reachable if the metadata is inconsistent, rewriting the bucket repairs it
+			o.readBucket(r, bucket)
+			return
+		}
+		offset = dummies[GetRandomInt(len(dummies))]
+	}
+	meta.Valid[offset] = false
+	meta.Count++
+	r.reads[bucket] = append(r.reads[bucket], offset)
+}
+
+// readBucket reads every unread real block of bucket, topped up with unread dummies to Z
+// slots so the number of real blocks stays hidden, and schedules the bucket for a rewrite.
+func (o *RingORAM) readBucket(r *ringRound, bucket int) {
+	if _, ok := r.rewrite[bucket]; ok {
+		return
+	}
+	meta := r.metas[bucket]
+	var slots, dummies []int
+	for i, k := range meta.Keys {
+		if !meta.Valid[i] {
+			continue
+		}
+		if k == 0 {
+			dummies = append(dummies, i)
+		} else {
+			slots = append(slots, i)
+		}
+	}
+	for len(slots) < o.Z && len(dummies) > 0 {
+		j := GetRandomInt(len(dummies))
+		slots = append(slots, dummies[j])
+		dummies[j] = dummies[len(dummies)-1]
+		dummies = dummies[:len(dummies)-1]
+	}
+	for _, slot := range slots {
+		meta.Valid[slot] = false
+	}
+	r.reads[bucket] = append(r.reads[bucket], slots...)
+	r.rewrite[bucket] = struct{}{}
+}
+
+// fetch reads the chosen slots and moves the real blocks in them to the stash.
+func (o *RingORAM) fetch(r *ringRound) error {
+	var keys []string
+	for bucket, slots := range r.reads {
+		for _, slot := range slots {
+			keys = append(keys, ringSlotKey(bucket, slot))
+		}
+	}
+	data, err := o.Store.readValues(keys)
+	if err != nil {
+		return fmt.Errorf("failed to read slots: %w", err)
+	}
+	for i, raw := range data {
+		if raw == nil {
+			continue // Slot of a bucket that was never written
+		}
+		block, err := o.Store.decodeBlock(raw)
+		if err != nil {
+			return fmt.Errorf("corrupt slot %s: %w", keys[i], err)
+		}
+		if block.Key != "-1" {
+			o.StashMap[block.Key] = block
+		}
+	}
+	return nil
+}
+
+// writeBack fills the rewritten buckets with stash blocks, deepest bucket first, and writes
+// them with a fresh permutation together with the metadata of every bucket in the round.
+func (o *RingORAM) writeBack(r *ringRound) error {
+	placed := make(map[int][]Block, len(r.rewrite))
+	for key, block := range o.StashMap {
+		leaf := o.leafOf(key, block)
+		for level := o.LogCapacity; level >= 0; level-- {
+			bucket := o.bucketForLevelLeaf(level, leaf)
+			if _, ok := r.rewrite[bucket]; !ok {
+				continue
+			}
+			if len(placed[bucket]) < o.Z {
+				placed[bucket] = append(placed[bucket], block)
+				delete(o.StashMap, key)
+				break
+			}
+		}
+	}
+
+	var keys []string
+	var values [][]byte
+	for bucket := range r.rewrite {
+		slots := make([]Block, o.Z+o.S)
+		for i := range slots {
+			slots[i] = Block{Key: "-1", Value: ""}
+		}
+		perm := randomPermutation(o.Z + o.S)
+		for i, block := range placed[bucket] {
+			slots[perm[i]] = block
+		}
+
+		meta := r.metas[bucket]
+		meta.Count = 0
+		for i, block := range slots {
+			meta.Keys[i] = slotFingerprint(block.Key)
+			meta.Valid[i] = true
+			data, err := o.Store.encodeBlock(block)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, ringSlotKey(bucket, i))
+			values = append(values, data)
+		}
+	}
+	for bucket, meta := range r.metas {
+		keys = append(keys, fmt.Sprintf("%s%d", ringMetaPrefix, bucket))
+		values = append(values, encodeRingMeta(meta))
+	}
+
+	if err := o.Store.writeValues(keys, values); err != nil {
+		return fmt.Errorf("failed to write buckets: %w", err)
+	}
+	return nil
+}
+
+// randomPermutation returns a uniformly random permutation of 0..n-1.
+func randomPermutation(n int) []int {
+	perm := make([]int, n)
+	for i := range perm {
+		perm[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j := GetRandomInt(i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	return perm
+}
+
+func (o *RingORAM) Batching(requests []Request, batchSize int) ([]string, error) {
+	if len(requests) > batchSize {
+		return nil, errors.New("batch size exceeded")
+	}
+
+	// Refuse the batch before touching any state if the stash cannot be brought under its bound
+	if err := o.enforceStashBound(); err != nil {
+		return nil, err
+	}
+
+	keys, insert := uniqueKeys(requests)
+	numLeaves := 1 << o.LogCapacity
+	newLeaves := make([]int, len(keys))
+	for i := range keys {
+		newLeaves[i] = GetRandomInt(numLeaves)
+	}
+	previousLeaves, found, err := o.posMap.access(keys, newLeaves, insert)
+	if err != nil {
+		return nil, err
+	}
+
+	// One path per request: the old leaf of known keys, random paths for new and repeated keys
+	leaves := make([]int, len(requests))
+	wanted := make([]string, len(requests))
+	for i := range requests {
+		leaves[i] = GetRandomInt(numLeaves)
+		if i < len(keys) {
+			wanted[i] = keys[i]
+			if found[i] {
+				leaves[i] = previousLeaves[i]
+			}
+		}
+	}
+
+	// Evictions that fall due with this batch share its round trip
+	var evictLeaves []int
+	for o.accesses += len(requests); o.accesses >= o.A; o.accesses -= o.A {
+		evictLeaves = append(evictLeaves, o.nextEvictionLeaf())
+	}
+
+	r := newRingRound()
+	uses := make(map[int]int)
+	buckets := make(map[int]struct{})
+	for _, leaf := range leaves {
+		for _, bucket := range o.pathBuckets(leaf) {
+			uses[bucket]++
+			buckets[bucket] = struct{}{}
+		}
+	}
+	for _, leaf := range evictLeaves {
+		for _, bucket := range o.pathBuckets(leaf) {
+			buckets[bucket] = struct{}{}
+		}
+	}
+	if err := o.loadMetas(r, buckets); err != nil {
+		return nil, err
+	}
+
+	// Buckets without enough unread dummies for every path through them are reshuffled early
+	for bucket, n := range uses {
+		if r.metas[bucket].Count+n > o.S {
+			o.readBucket(r, bucket)
+		}
+	}
+	for i, leaf := range leaves {
+		for _, bucket := range o.pathBuckets(leaf) {
+			if _, ok := r.rewrite[bucket]; !ok {
+				o.readSlot(r, bucket, wanted[i])
+			}
+		}
+	}
+	for _, leaf := range evictLeaves {
+		for _, bucket := range o.pathBuckets(leaf) {
+			o.readBucket(r, bucket)
+		}
+	}
+	if err := o.fetch(r); err != nil {
+		return nil, err
+	}
+
+	values := serveRequests(o.StashMap, requests)
+
+	// Blocks carry their new leaf so writeBack can place them without consulting the position map
+	for i, key := range keys {
+		if block, ok := o.StashMap[key]; ok {
+			block.Leaf = newLeaves[i]
+			o.StashMap[key] = block
+		}
+	}
+
+	if err := o.writeBack(r); err != nil {
+		return nil, err
+	}
+	o.metrics.record(len(o.StashMap))
+	return values, nil
+}
+
+// evict runs evictionPaths extra evictions outside the regular schedule.
+func (o *RingORAM) evict() error {
+	r := newRingRound()
+	buckets := make(map[int]struct{})
+	for i := 0; i < evictionPaths; i++ {
+		for _, bucket := range o.pathBuckets(o.nextEvictionLeaf()) {
+			buckets[bucket] = struct{}{}
+		}
+	}
+	if err := o.loadMetas(r, buckets); err != nil {
+		return err
+	}
+	for bucket := range buckets {
+		o.readBucket(r, bucket)
+	}
+	if err := o.fetch(r); err != nil {
+		return err
+	}
+	if err := o.writeBack(r); err != nil {
+		return err
+	}
+	o.metrics.evictionRounds.Add(1)
+	return nil
+}
+
+func (o *RingORAM) evictIdle() (bool, error) {
+	more, err := evictStep(func() int { return len(o.StashMap) }, o.StashSize, o.evict, &o.metrics)
+	if err != nil || more {
+		return more, err
+	}
+	if r, ok := o.posMap.(*recursivePositionMap); ok {
+		return r.oram.evictIdle()
+	}
+	return false, nil
+}
+
+func (o *RingORAM) enforceStashBound() error {
+	return enforceStashBound(func() int { return len(o.StashMap) }, o.StashSize, o.evict, &o.metrics)
+}
+
+// StashStats returns a snapshot of the stash metrics. Safe to call from any goroutine.
+func (o *RingORAM) StashStats() StashStats {
+	return o.metrics.stats(o.StashSize)
+}
+
+func (o *RingORAM) writeCheckpoint(dir string) error {
+	epoch := o.checkpointEpoch + 1
+	state := oramState{StashMap: o.StashMap, Evictions: o.evictions, Accesses: o.accesses}
+	o.posMap.save(&state)
+	if err := saveCheckpoint(o.Store, dir, epoch, state); err != nil {
+		return err
+	}
+	o.checkpointEpoch = epoch
+	return nil
+}
+
+func (o *RingORAM) recoverCheckpoint(dir string) (bool, error) {
+	cp, found, err := loadCheckpoint(o.Store, dir)
+	if err != nil || !found {
+		return false, err
+	}
+	if err := o.posMap.restore(cp.oramState); err != nil {
+		return false, err
+	}
+	o.StashMap = cp.StashMap
+	if o.StashMap == nil {
+		o.StashMap = make(map[string]Block)
+	}
+	o.evictions = cp.Evictions
+	o.accesses = cp.Accesses
+	o.checkpointEpoch = cp.Epoch
+	o.metrics.record(len(o.StashMap))
+	return true, nil
+}
+
+func (o *RingORAM) lastCheckpoint() int64 {
+	return o.checkpointEpoch
+}
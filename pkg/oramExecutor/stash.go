@@ -0,0 +1,153 @@
+package oramexecutor
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	evictionPaths     = 16 // Random paths read and written back per eviction round
+	maxEvictionRounds = 32 // Eviction rounds attempted before giving up, per batch or idle period
+)
+
+// StashOverflowError is returned when the stash holds more blocks than ORAM.StashSize
+// and eviction could not bring it back under the bound.
+type StashOverflowError struct {
+	Size  int
+	Limit int
+}
+
+func (e *StashOverflowError) Error() string {
+	return fmt.Sprintf("stash overflow: %d blocks in stash, limit is %d", e.Size, e.Limit)
+}
+
+// StashStats reports stash occupancy and eviction activity.
+type StashStats struct {
+	Occupancy      int64
+	Peak           int64
+	Limit          int64
+	EvictionRounds int64
+	Overflows      int64
+}
+
+type stashMetrics struct {
+	occupancy      atomic.Int64
+	peak           atomic.Int64
+	evictionRounds atomic.Int64
+	overflows      atomic.Int64
+}
+
+// recordStash updates the occupancy metrics. Only the batch goroutine touches StashMap,
+// the metrics are what other goroutines read.
+func (o *ORAM) recordStash() {
+	o.metrics.record(len(o.StashMap))
+}
+
+func (m *stashMetrics) record(stashLen int) {
+	size := int64(stashLen)
+	m.occupancy.Store(size)
+	if size > m.peak.Load() {
+		m.peak.Store(size)
+	}
+}
+
+func (m *stashMetrics) stats(limit int) StashStats {
+	return StashStats{
+		Occupancy:      m.occupancy.Load(),
+		Peak:           m.peak.Load(),
+		Limit:          int64(limit),
+		EvictionRounds: m.evictionRounds.Load(),
+		Overflows:      m.overflows.Load(),
+	}
+}
+
+// evict performs one dummy access: read random paths into the stash and write them
+// back, which pushes stash blocks whose leaves share those paths into the tree.
+func (o *ORAM) evict() error {
+	leaves := make([]int, 0, evictionPaths)
+	seen := make(map[int]struct{}, evictionPaths)
+	for len(leaves) < evictionPaths && len(leaves) < 1<<o.LogCapacity {
+		leaf := GetRandomInt(1 << o.LogCapacity)
+		if _, ok := seen[leaf]; ok {
+			continue
+		}
+		seen[leaf] = struct{}{}
+		leaves = append(leaves, leaf)
+	}
+
+	bucketIndices, err := o.ReadPaths(leaves)
+	if err != nil {
+		return err
+	}
+	if err := o.WritePaths(map[string]int{}, bucketIndices); err != nil {
+		return err
+	}
+	o.metrics.evictionRounds.Add(1)
+	return nil
+}
+
+// evictIdle runs one eviction round if the stash is above the eviction threshold, or else
+// one of the recursive position map, and reports whether more rounds are needed.
+func (o *ORAM) evictIdle() (bool, error) {
+	more, err := evictStep(func() int { return len(o.StashMap) }, o.StashSize, o.evict, &o.metrics)
+	if err != nil || more {
+		return more, err
+	}
+	if r, ok := o.posMap.(*recursivePositionMap); ok {
+		return r.oram.evictIdle()
+	}
+	return false, nil
+}
+
+// enforceStashBound evicts if the stash is above StashSize and returns a StashOverflowError
+// if it is still above it. It is checked before a batch touches any state, so a rejected
+// batch leaves the position map and stash unchanged.
+func (o *ORAM) enforceStashBound() error {
+	return enforceStashBound(func() int { return len(o.StashMap) }, o.StashSize, o.evict, &o.metrics)
+}
+
+// evictionThreshold is the stash size above which the executor evicts while it is idle.
+func evictionThreshold(limit int) int {
+	return limit * 3 / 4
+}
+
+// evictStep calls evict once if stashLen is above the eviction threshold of limit and
+// reports whether it still is. A limit of 0 disables eviction. The executor calls it
+// between batches while no request is waiting, one round at a time, so eviction does not
+// delay the batches. A round reads and rewrites the same tree, stash and position map as
+// batches, so it runs on the batch goroutine instead of alongside it.
+func evictStep(stashLen func() int, limit int, evict func() error, m *stashMetrics) (bool, error) {
+	if limit <= 0 || stashLen() <= evictionThreshold(limit) {
+		m.record(stashLen())
+		return false, nil
+	}
+	if err := evict(); err != nil {
+		return false, err
+	}
+	m.record(stashLen())
+	return stashLen() > evictionThreshold(limit), nil
+}
+
+// enforceStashBound calls evict while stashLen is above limit, at most maxEvictionRounds
+// times. This only happens when the executor had no idle time to evict in, and delays the
+// batch about to start.
+func enforceStashBound(stashLen func() int, limit int, evict func() error, m *stashMetrics) error {
+	if limit > 0 {
+		for round := 0; round < maxEvictionRounds && stashLen() > limit; round++ {
+			if err := evict(); err != nil {
+				return err
+			}
+		}
+	}
+	m.record(stashLen())
+	if limit > 0 && stashLen() > limit {
+		m.overflows.Add(1)
+		return &StashOverflowError{Size: stashLen(), Limit: limit}
+	}
+	return nil
+}
+
+// StashStats returns a snapshot of the stash metrics. Safe to call from any goroutine.
+func (o *ORAM) StashStats() StashStats {
+	return o.metrics.stats(o.StashSize)
+}
@@ -0,0 +1,158 @@
+package oramexecutor
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEvictStep(t *testing.T) {
+	var m stashMetrics
+	stash, rounds := 10, 0
+	evict := func() error {
+		rounds++
+		stash--
+		return nil
+	}
+	// One round per step, until the stash is back at three quarters of the limit
+	for more := true; more; {
+		var err error
+		if more, err = evictStep(func() int { return stash }, 8, evict, &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stash != 6 || rounds != 4 {
+		t.Errorf("stash %d after %d rounds, want 6 after 4", stash, rounds)
+	}
+	if stats := m.stats(8); stats.Occupancy != 6 || stats.Overflows != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	// A limit of 0 disables eviction
+	rounds = 0
+	if more, err := evictStep(func() int { return 100 }, 0, evict, &m); more || err != nil || rounds != 0 {
+		t.Errorf("evictStep() without limit = %t, %v after %d rounds", more, err, rounds)
+	}
+	if stats := m.stats(0); stats.Occupancy != 100 || stats.Peak != 100 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestEnforceStashBound(t *testing.T) {
+	var m stashMetrics
+	stash, rounds := 10, 0
+	evict := func() error {
+		rounds++
+		stash--
+		return nil
+	}
+	// Before a batch, eviction only brings the stash back under its bound
+	if err := enforceStashBound(func() int { return stash }, 8, evict, &m); err != nil {
+		t.Fatal(err)
+	}
+	if stash != 8 || rounds != 2 {
+		t.Errorf("stash %d after %d rounds, want 8 after 2", stash, rounds)
+	}
+}
+
+func TestEnforceStashBoundOverflow(t *testing.T) {
+	var m stashMetrics
+	rounds := 0
+	stuck := func() error { rounds++; return nil } // Eviction that places nothing
+	err := enforceStashBound(func() int { return 12 }, 8, stuck, &m)
+
+	var overflow *StashOverflowError
+	if !errors.As(err, &overflow) || overflow.Size != 12 || overflow.Limit != 8 {
+		t.Fatalf("enforceStashBound() = %v, want a StashOverflowError of 12 over 8", err)
+	}
+	if rounds != maxEvictionRounds {
+		t.Errorf("gave up after %d eviction rounds, want %d", rounds, maxEvictionRounds)
+	}
+	if stats := m.stats(8); stats.Overflows != 1 || stats.Occupancy != 12 || stats.Limit != 8 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	failed := errors.New("store down")
+	if err := enforceStashBound(func() int { return 12 }, 8, func() error { return failed }, &m); err != failed {
+		t.Errorf("enforceStashBound() = %v, want the eviction error", err)
+	}
+}
+
+// TestStashOverflow fills ORAMs with more blocks than their trees hold, so the stash must
+// overflow, and checks the batch is refused with a StashOverflowError.
+func TestStashOverflow(t *testing.T) {
+	for _, backend := range []string{BackendPath, BackendRing} {
+		t.Run(backend, func(t *testing.T) {
+			// 2^3 leaves with one block per bucket hold at most 15 blocks
+			const stashSize = 8
+			config := BackendConfig{Backend: backend, LogCapacity: 3, Z: 1, StashSize: stashSize, RingDummies: 1, RingEvictRate: 1}
+			o, err := newBackend(NewTreeStore(NewMemoryStore(), testKey, 0, false), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var overflow *StashOverflowError
+			for b := 0; b < 20 && overflow == nil; b++ {
+				requests := make([]Request, 5)
+				for i := range requests {
+					requests[i] = Request{Key: fmt.Sprintf("K%d-%d", b, i), Value: "V"}
+				}
+				_, err = o.Batching(requests, len(requests))
+				if err != nil && !errors.As(err, &overflow) {
+					t.Fatalf("batch %d: %v", b, err)
+				}
+			}
+			if overflow == nil {
+				t.Fatal("100 blocks in a tree of 15 never overflowed the stash")
+			}
+			if overflow.Limit != stashSize || overflow.Size <= stashSize {
+				t.Errorf("overflow = %+v", overflow)
+			}
+			stats := o.StashStats()
+			if stats.Overflows != 1 || stats.EvictionRounds == 0 || stats.Peak < int64(overflow.Size) || stats.Limit != stashSize {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+// TestIdleEviction checks batches leave the stash above the eviction threshold and the
+// executor drains it once no request is waiting.
+func TestIdleEviction(t *testing.T) {
+	for _, backend := range []string{BackendPath, BackendRing} {
+		t.Run(backend, func(t *testing.T) {
+			// 2^3 leaves with one block per bucket hold at most 15 blocks
+			const stashSize = 12
+			config := BackendConfig{Backend: backend, LogCapacity: 3, Z: 1, StashSize: stashSize, RingDummies: 1, RingEvictRate: 1}
+			client := NewTreeStore(NewMemoryStore(), testKey, 0, false)
+			o, err := newBackend(client, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for b := 0; o.StashStats().Occupancy <= int64(evictionThreshold(stashSize)); b++ {
+				if b == 100 {
+					t.Fatal("stash never grew above the eviction threshold")
+				}
+				if _, err := o.Batching([]Request{{Key: fmt.Sprintf("K%d", b), Value: "V"}}, 1); err != nil {
+					t.Fatalf("batch %d: %v", b, err)
+				}
+			}
+			if rounds := o.StashStats().EvictionRounds; rounds != 0 {
+				t.Errorf("batches ran %d eviction rounds under the stash bound", rounds)
+			}
+
+			e := newMyOram(o, client, 1, time.Millisecond, "")
+			batch := make(chan []*KVPair)
+			go func() { batch <- e.nextBatch() }()
+			time.Sleep(50 * time.Millisecond)
+			stats := o.StashStats()
+			if stats.EvictionRounds == 0 || stats.EvictionRounds > maxEvictionRounds {
+				t.Errorf("idle executor ran %d eviction rounds", stats.EvictionRounds)
+			}
+			e.oramExecutorChannel <- &KVPair{Key: "K0"}
+			if ops := <-batch; len(ops) != 1 || ops[0].Key != "K0" {
+				t.Errorf("batch after idle eviction = %v", ops)
+			}
+		})
+	}
+}
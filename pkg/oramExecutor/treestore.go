@@ -0,0 +1,297 @@
+package oramexecutor
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// TreeStore encrypts and encodes the buckets of one ORAM tree on a BucketStore. Trees
+// sharing a store, such as the levels of a recursive position map, use different key prefixes.
+type TreeStore struct {
+	Store     BucketStore
+	Keys      *KeyRing
+	keyPrefix string // Prefix of bucket keys, each ORAM tree sharing the store has its own
+	blockSize int    // Bytes every encoded block is padded to, 0 disables padding
+	io        *ioCounters
+	writes    *writePipeline // Shared by every tree on the store
+
+	integrity bool             // Verify buckets against a Merkle tree rooted in root
+	root      []byte           // Trusted digest of the root bucket, nil while it was never written
+	verified  map[int][][]byte // Child digests of the buckets verified by the last read
+}
+
+// IOStats reports the encrypted bytes and values moved between the executor and its store.
+type IOStats struct {
+	ValuesRead    int64
+	BytesRead     int64
+	ValuesWritten int64
+	BytesWritten  int64
+	StaleKeyReads int64 // Values read that were encrypted with an older key version
+}
+
+type ioCounters struct {
+	valuesRead    atomic.Int64
+	bytesRead     atomic.Int64
+	valuesWritten atomic.Int64
+	bytesWritten  atomic.Int64
+}
+
+// NewTreeStore creates the store of a tree. With integrity set, every path read is verified
+// against a Merkle tree over the buckets, so a store that modifies or rolls back buckets is detected.
+func NewTreeStore(store BucketStore, keys *KeyRing, blockSize int, integrity bool) *TreeStore {
+	return &TreeStore{
+		Store:     store,
+		Keys:      keys,
+		keyPrefix: "bucket:",
+		blockSize: blockSize,
+		io:        &ioCounters{},
+		writes:    newWritePipeline(),
+		integrity: integrity,
+	}
+}
+
+// withPrefix returns a tree store on the same store that keeps buckets under keyPrefix and
+// pads blocks to blockSize.
+func (r *TreeStore) withPrefix(keyPrefix string, blockSize int) *TreeStore {
+	return &TreeStore{
+		Store:     r.Store,
+		Keys:      r.Keys,
+		keyPrefix: keyPrefix,
+		blockSize: blockSize,
+		io:        r.io,
+		writes:    r.writes,
+		integrity: r.integrity,
+	}
+}
+
+func (r *TreeStore) bucketKey(index int) string {
+	return fmt.Sprintf("%s%d", r.keyPrefix, index)
+}
+
+// IOStats returns the traffic of this tree and every tree derived from it with withPrefix.
+func (r *TreeStore) IOStats() IOStats {
+	return IOStats{
+		ValuesRead:    r.io.valuesRead.Load(),
+		BytesRead:     r.io.bytesRead.Load(),
+		ValuesWritten: r.io.valuesWritten.Load(),
+		BytesWritten:  r.io.bytesWritten.Load(),
+		StaleKeyReads: r.Keys.StaleReads(),
+	}
+}
+
+// Pipeline writes values behind the ORAM from now on, with at most depth writes in flight.
+// A depth of 0 keeps writes synchronous.
+func (r *TreeStore) Pipeline(depth int) {
+	r.writes.start(depth, r.storeValues)
+}
+
+// writeBarrier returns a function that waits until every value written so far is in the store.
+func (r *TreeStore) writeBarrier() func() error {
+	return r.writes.barrier()
+}
+
+// drainWrites waits until every value written so far is in the store.
+func (r *TreeStore) drainWrites() error {
+	return r.writes.drain()
+}
+
+// writeValues stores values under keys in one bulk write, or queues the write if writes are
+// pipelined.
+func (r *TreeStore) writeValues(keys []string, values [][]byte) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if r.writes.active() {
+		return r.writes.enqueue(keys, values)
+	}
+	return r.storeValues(keys, values)
+}
+
+// storeValues encrypts values with the current key and stores them under keys, which
+// re-encrypts values read with an older key version.
+func (r *TreeStore) storeValues(keys []string, values [][]byte) error {
+	encrypted := make([][]byte, len(values))
+	for i, value := range values {
+		encryptedData, err := r.Keys.Encrypt(value)
+		if err != nil {
+			return err
+		}
+		r.io.bytesWritten.Add(int64(len(encryptedData)))
+		encrypted[i] = encryptedData
+	}
+	r.io.valuesWritten.Add(int64(len(keys)))
+	return r.Store.Set(keys, encrypted)
+}
+
+// readValues fetches and decrypts keys in one bulk read. Values still waiting in the write
+// pipeline are taken from it. Missing keys are returned as nil.
+func (r *TreeStore) readValues(keys []string) ([][]byte, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	values := make([][]byte, len(keys))
+	fetch := keys
+	var fetchIndex []int // Position in keys of every fetched key, nil if all are fetched
+	if r.writes.active() {
+		var err error
+		if values, err = r.writes.lookup(keys); err != nil {
+			return nil, err
+		}
+		fetch = make([]string, 0, len(keys))
+		fetchIndex = make([]int, 0, len(keys))
+		for i, key := range keys {
+			if values[i] == nil {
+				fetch = append(fetch, key)
+				fetchIndex = append(fetchIndex, i)
+			}
+		}
+		if len(fetch) == 0 {
+			return values, nil
+		}
+	}
+
+	data, err := r.Store.Get(fetch)
+	if err != nil {
+		return nil, err
+	}
+	for j, encryptedData := range data {
+		if encryptedData == nil {
+			continue
+		}
+		i := j
+		if fetchIndex != nil {
+			i = fetchIndex[j]
+		}
+		r.io.bytesRead.Add(int64(len(encryptedData)))
+		values[i], err = r.Keys.Decrypt(encryptedData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	r.io.valuesRead.Add(int64(len(fetch)))
+	return values, nil
+}
+
+// encodeBlock encodes a single block, such as a Ring ORAM slot, as a one block bucket.
+func (r *TreeStore) encodeBlock(block Block) ([]byte, error) {
+	return encodeBucket(Bucket{Blocks: []Block{block}}, r.blockSize)
+}
+
+func (r *TreeStore) decodeBlock(data []byte) (Block, error) {
+	bucket, err := decodeBucket(data)
+	if err != nil {
+		return Block{}, err
+	}
+	if len(bucket.Blocks) != 1 {
+		return Block{}, fmt.Errorf("expected a single block, got %d", len(bucket.Blocks))
+	}
+	return bucket.Blocks[0], nil
+}
+
+func (r *TreeStore) FlushDB() error {
+	if err := r.drainWrites(); err != nil {
+		return err
+	}
+	r.root = nil
+	return r.Store.Flush()
+}
+
+func (r *TreeStore) WriteBucketsToDb(requests []BucketRequest) error {
+	var hashed map[int][]byte
+	if r.integrity {
+		var err error
+		if hashed, err = r.hashBuckets(requests); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, 0, len(requests))
+	values := make([][]byte, 0, len(requests))
+	for _, req := range requests {
+		data, ok := hashed[req.BucketId]
+		if !ok {
+			var err error
+			if data, err = encodeBucket(req.Bucket, r.blockSize); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, r.bucketKey(req.BucketId))
+		values = append(values, data)
+	}
+	if err := r.writeValues(keys, values); err != nil {
+		return err
+	}
+	if data, ok := hashed[0]; ok {
+		r.root = bucketDigest(data)
+	}
+	return nil
+}
+
+func (r *TreeStore) ReadBucketsFromDb(indices map[int]struct{}) (map[int]Bucket, error) {
+	order := make([]int, 0, len(indices))
+	keys := make([]string, 0, len(indices))
+	for index := range indices {
+		order = append(order, index)
+		keys = append(keys, r.bucketKey(index))
+	}
+
+	data, err := r.readValues(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets that were never written are left out, they hold only dummies
+	buckets := make(map[int]Bucket, len(indices))
+	for i, decryptedData := range data {
+		if decryptedData == nil {
+			continue
+		}
+		bucket1, err := decodeBucket(decryptedData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode bucket %d: %w", order[i], err)
+		}
+		buckets[order[i]] = bucket1
+	}
+
+	if r.integrity {
+		if err := r.verifyBuckets(order, data, buckets); err != nil {
+			return nil, err
+		}
+	}
+	return buckets, nil
+}
+
+func (r *TreeStore) WriteBucketToDb(index int, bucket Bucket) error {
+	return r.WriteBucketsToDb([]BucketRequest{{BucketId: index, Bucket: bucket}})
+}
+
+func (r *TreeStore) ReadBucketFromDb(index int) (Bucket, error) {
+	buckets, err := r.ReadBucketsFromDb(map[int]struct{}{index: {}})
+	if err != nil {
+		return Bucket{}, err
+	}
+	bucket, ok := buckets[index]
+	if !ok {
+		return Bucket{}, fmt.Errorf("bucket %d not found", index)
+	}
+	return bucket, nil
+}
+
+// SaveCheckpointEpoch waits for pending writes, so the saved store matches the checkpoint.
+func (r *TreeStore) SaveCheckpointEpoch(epoch int64) error {
+	if err := r.drainWrites(); err != nil {
+		return err
+	}
+	return r.Store.SaveCheckpointEpoch(epoch)
+}
+
+func (r *TreeStore) CheckpointEpoch() (int64, error) {
+	return r.Store.CheckpointEpoch()
+}
+
+func (r *TreeStore) Close() error {
+	if err := r.drainWrites(); err != nil {
+		return err
+	}
+	return r.Store.Close()
+}
@@ -0,0 +1,75 @@
+// Package partition spreads keys over executors by consistent hashing. The batcher routes
+// requests with it and generateParts splits tracefiles with it, so every key is loaded into
+// the executor that is asked for it.
+package partition
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Strategies a table config uses to place the tables it does not list. The batcher and
+// generateParts both read them from the same config, so they must agree on the names.
+const (
+	StrategyHash = "hash" // The whole table goes to the partition its name hashes to
+	StrategyKey  = "key"  // Every key goes to its partition on a Ring
+)
+
+// VirtualNodes is the number of points each partition takes on the ring. More points even
+// out the share of keys each partition gets.
+const VirtualNodes = 256
+
+// Ring maps keys to partitions. Adding a partition only moves the keys that the new
+// partition takes over, about 1/N of them, instead of reshuffling all of them.
+type Ring struct {
+	points     []uint64 // Sorted
+	partitions []uint32 // Owner of each point
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV spreads similar strings poorly over the high bits, finish with a mixer
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// NewRing returns a ring over the partitions 0 to n-1.
+func NewRing(n uint32) *Ring {
+	type point struct {
+		hash      uint64
+		partition uint32
+	}
+	points := make([]point, 0, int(n)*VirtualNodes)
+	for p := uint32(0); p < n; p++ {
+		for v := 0; v < VirtualNodes; v++ {
+			points = append(points, point{hash(fmt.Sprintf("partition-%d-%d", p, v)), p})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	r := &Ring{points: make([]uint64, len(points)), partitions: make([]uint32, len(points))}
+	for i, pt := range points {
+		r.points[i], r.partitions[i] = pt.hash, pt.partition
+	}
+	return r
+}
+
+// Locate returns the partition of key: the owner of the first point at or after its hash.
+func (r *Ring) Locate(key string) uint32 {
+	if len(r.points) == 0 {
+		return 0
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.partitions[i]
+}
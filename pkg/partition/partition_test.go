@@ -0,0 +1,57 @@
+package partition
+
+import (
+	"fmt"
+	"testing"
+)
+
+func reviewKeys() []string {
+	var keys []string
+	for pk := 0; pk < 20000; pk++ {
+		keys = append(keys, fmt.Sprintf("review/rating/%d", pk))
+	}
+	return keys
+}
+
+func TestSpread(t *testing.T) {
+	const n = 4
+	r := NewRing(n)
+	counts := make([]int, n)
+	keys := reviewKeys()
+	for _, key := range keys {
+		counts[r.Locate(key)]++
+	}
+	// One table spreads over every partition, each within a fifth of its fair share
+	for p, count := range counts {
+		if fair := len(keys) / n; count < fair*4/5 || count > fair*6/5 {
+			t.Errorf("partition %d got %d of %d keys: %v", p, count, len(keys), counts)
+		}
+	}
+	if NewRing(n).Locate("review/rating/7") != r.Locate("review/rating/7") {
+		t.Error("rings over the same partitions disagree")
+	}
+}
+
+func TestAddPartition(t *testing.T) {
+	before, after := NewRing(4), NewRing(5)
+	moved := 0
+	keys := reviewKeys()
+	for _, key := range keys {
+		if p := after.Locate(key); p != before.Locate(key) {
+			moved++
+			if p != 4 {
+				t.Fatalf("%s moved between old partitions, %d -> %d", key, before.Locate(key), p)
+			}
+		}
+	}
+	// The new partition takes about a fifth of the keys
+	if moved < len(keys)/8 || moved > len(keys)/4 {
+		t.Errorf("%d of %d keys moved", moved, len(keys))
+	}
+}
+
+func TestSinglePartition(t *testing.T) {
+	if p := NewRing(1).Locate("review/rating/7"); p != 0 {
+		t.Errorf("Locate() = %d with one partition", p)
+	}
+}
@@ -0,0 +1,87 @@
+// Package querytrace records the queries a resolver receives to a JSONL trace, one entry per
+// line, so the benchmark can replay them later.
+package querytrace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/project/ObliSql/api/resolver"
+)
+
+// Entry is one line of a trace: a query and the time it arrived.
+type Entry struct {
+	Time  time.Time             `json:"time"`
+	Query *resolver.ParsedQuery `json:"query"`
+}
+
+// Writer appends entries to a trace file. It is safe for concurrent use.
+type Writer struct {
+	mu      sync.Mutex
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+// Create creates or truncates the trace file at path.
+func Create(path string) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating query trace: %w", err)
+	}
+	buf := bufio.NewWriter(file)
+	return &Writer{file: file, buf: buf, encoder: json.NewEncoder(buf)}, nil
+}
+
+// Record appends q with the current time.
+func (w *Writer) Record(q *resolver.ParsedQuery) error {
+	entry := Entry{Time: time.Now(), Query: q}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.encoder.Encode(&entry)
+}
+
+// Close flushes the buffered entries and closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// Read returns the entries of the trace at path in file order. Blank lines are skipped.
+func Read(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening query trace: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024) // Join and range queries can be long
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if entry.Query == nil {
+			return nil, fmt.Errorf("%s:%d: entry has no query", path, line)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading query trace: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,57 @@
+package querytrace
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/project/ObliSql/api/resolver"
+)
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Record(&resolver.ParsedQuery{ClientId: "1", QueryType: "select", TableName: "users", SearchCol: []string{"u_id"}, SearchVal: []string{"7"}})
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 100 {
+		t.Fatalf("read %d entries, want 100", len(entries))
+	}
+	for _, e := range entries {
+		if e.Time.IsZero() || e.Query.TableName != "users" || e.Query.SearchVal[0] != "7" {
+			t.Fatalf("entry = %v", e)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"malformed": `{"time": "2024-01-01T00:00:00Z", "query": {` + "\n",
+		"no query":  `{"time": "2024-01-01T00:00:00Z"}` + "\n",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(text), 0644)
+		if _, err := Read(path); err == nil {
+			t.Errorf("Read() of %s trace succeeded", name)
+		}
+	}
+}
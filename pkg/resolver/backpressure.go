@@ -0,0 +1,38 @@
+package resolver
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Backoff between retries of a request the batcher turned away
+const (
+	minBatcherBackoff = 5 * time.Millisecond
+	maxBatcherBackoff = time.Second
+)
+
+// retryExhausted is a client interceptor for the batcher connections. A batcher in
+// constant-rate mode rejects requests with ResourceExhausted while its queues are full; the
+// request is retried with jittered exponential backoff until it is admitted or ctx ends, so
+// the load is held back in the resolver.
+func (r *myResolver) retryExhausted(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	backoff := minBatcherBackoff
+	for {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if status.Code(err) != codes.ResourceExhausted {
+			return err
+		}
+		r.BatcherRetries.Add(1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff)))):
+		}
+		backoff = min(2*backoff, maxBatcherBackoff)
+	}
+}
@@ -0,0 +1,41 @@
+package resolver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryExhausted(t *testing.T) {
+	r := &myResolver{}
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if calls <= 2 {
+			return status.Error(codes.ResourceExhausted, "queue of executor 0 is full")
+		}
+		return nil
+	}
+	if err := r.retryExhausted(context.Background(), "AddKeys", nil, nil, nil, invoker); err != nil || calls != 3 || r.BatcherRetries.Load() != 2 {
+		t.Errorf("retryExhausted() = %v after %d calls and %d retries", err, calls, r.BatcherRetries.Load())
+	}
+
+	// Other errors are returned at once, and retries stop with the context
+	failed := status.Error(codes.Unavailable, "down")
+	if err := r.retryExhausted(context.Background(), "AddKeys", nil, nil, nil, func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+		return failed
+	}); err != failed {
+		t.Errorf("retryExhausted() = %v, want %v", err, failed)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.retryExhausted(ctx, "AddKeys", nil, nil, nil, func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+		return status.Error(codes.ResourceExhausted, "full")
+	}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("retryExhausted() after the deadline = %v", err)
+	}
+}
@@ -124,7 +124,7 @@ func (c *myResolver) doBDB3Join(q *resolver.ParsedQuery, localRequestID int64) (
 		log.Fatal().Msgf("Failed to get batch Client!")
 	}
 
-	resp, err := conn.AddKeys(ctx, &indexReqKeys)
+	resp, err := c.fetchPadded(ctx, conn, tableName, &indexReqKeys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch index value: %w", err)
 	}
@@ -175,7 +175,7 @@ func (c *myResolver) doBDB3Join(q *resolver.ParsedQuery, localRequestID int64) (
 
 	}
 
-	valResp, err := conn.AddKeys(ctx, &valReq)
+	valResp, err := c.fetchPadded(ctx, conn, "uservisits", &valReq)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch DestURL value: %w", err)
 	}
@@ -192,7 +192,7 @@ func (c *myResolver) doBDB3Join(q *resolver.ParsedQuery, localRequestID int64) (
 		indexReqKeysRanking.Values = append(indexReqKeysRanking.Values, "")
 	}
 
-	rankingResp, err := conn.AddKeys(ctx, &indexReqKeysRanking)
+	rankingResp, err := c.fetchPadded(ctx, conn, "rankings", &indexReqKeysRanking)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch pageURL value: %w", err)
 	}
@@ -249,7 +249,7 @@ func (c *myResolver) doBDB3Join(q *resolver.ParsedQuery, localRequestID int64) (
 		RequestId: localRequestID,
 	}
 
-	valueResp, err := conn.AddKeys(ctx, &fetchKeys)
+	valueResp, err := c.fetchPadded(ctx, conn, strings.Split(q.TableName, ",")[0], &fetchKeys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch pageURL value: %w", err)
 	}
@@ -0,0 +1,119 @@
+package resolver_test
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// fixtureIndexes are the indexed columns of the tables in ../../metaData/metadata.txt.
+var fixtureIndexes = map[string][]string{
+	"review": {"u_id", "a_id", "i_id", "creation_date"},
+	"item":   {"i_id"},
+	"trust":  {"source_u_id", "target_u_id"},
+}
+
+// fixtureDefaults fill the columns a fixture row does not set. They match none of the
+// values the test cases search for.
+var fixtureDefaults = map[string]map[string]string{
+	"review": {"a_id": "0", "u_id": "0", "i_id": "0", "rating": "0", "rank": "nan", "comment": "c", "creation_date": "2000-01-01"},
+	"item":   {"i_id": "0", "title": "t", "description": "d", "creation_date": "2000-01-01"},
+	"trust":  {"source_u_id": "0", "target_u_id": "0", "trust": "1", "creation_date": "2000-01-01"},
+}
+
+// dateRangeRatings are the rows getTestCases finds created on 2024-01-01 and 2024-01-02.
+var dateRangeRatings = map[int]string{
+	300437: "0", 124087: "4", 145780: "3", 147457: "2", 154879: "0", 185100: "4", 195868: "3", 203074: "0",
+	236339: "2", 256539: "1", 263145: "2", 3411: "1", 336823: "4", 347458: "2", 383961: "0", 384928: "4",
+	390208: "1", 393277: "4", 407118: "3", 413404: "1", 416382: "1", 105882: "2", 79308: "4", 7067: "1",
+	66063: "3", 36146: "3", 20766: "0", 60311: "0", 43608: "4", 272803: "1", 302235: "1", 319654: "0",
+	340246: "0", 347300: "3", 34183: "1", 31949: "1", 28586: "3", 20824: "4", 405462: "4", 5991: "4",
+	415664: "3", 1405: "1", 296759: "2", 277655: "4", 117706: "2", 46349: "0", 261164: "2", 259617: "2",
+	51371: "2", 250809: "4", 55455: "4", 203650: "4", 62447: "3", 194254: "4", 165239: "1", 163231: "0",
+	73818: "1", 108729: "2",
+}
+
+// fixtureRows returns the rows getTestCases expects, by table and primary key.
+func fixtureRows() map[string]map[int]map[string]string {
+	rows := map[string]map[int]map[string]string{
+		"review": {
+			// Simple Select
+			33499:  {"u_id": "3091", "rating": "1"},
+			136891: {"u_id": "3091", "rating": "4"},
+			375821: {"u_id": "3091", "rating": "2"},
+			// Select using two filters with index (AND)
+			693: {"a_id": "38", "u_id": "49", "i_id": "84395", "rating": "4", "rank": "nan", "comment": "?''yvUFjt9", "creation_date": "2029-09-16"},
+			// Select with Order by
+			81174: {"u_id": "3462", "rating": "0", "creation_date": "2021-10-10"},
+			50600: {"u_id": "3462", "rating": "2", "creation_date": "2022-10-04"},
+			// Range
+			216197: {"u_id": "11238", "rating": "2"},
+			151874: {"u_id": "11238", "rating": "2"},
+			300221: {"u_id": "11239", "rating": "0"},
+			303630: {"u_id": "11240", "rating": "3"},
+			54868:  {"u_id": "11240", "rating": "3"},
+			// Cross Join
+			287: {"i_id": "18", "rating": "3"},
+			// Join with two search filters, the reviewer is trusted by user 649
+			224: {"i_id": "11", "u_id": "7001", "rating": "4"},
+			225: {"i_id": "11", "u_id": "7002", "rating": "1"},
+		},
+		"item": {
+			500: {"i_id": "500", "title": "B3_Pz_t9te"},
+			18:  {"i_id": "18", "title": "|@vA?X!3bK"},
+		},
+		"trust": {
+			0: {"source_u_id": "649", "target_u_id": "7001"},
+			1: {"source_u_id": "650", "target_u_id": "7002"},
+		},
+	}
+	// Aggregates: ten reviews of item 17 rating 18 in total, two of item 15 rating 7
+	for i, rating := range []string{"3", "2", "1", "2", "2", "1", "2", "2", "1", "2"} {
+		rows["review"][500000+i] = map[string]string{"i_id": "17", "rating": rating}
+	}
+	rows["review"][500100] = map[string]string{"i_id": "15", "rating": "3"}
+	rows["review"][500101] = map[string]string{"i_id": "15", "rating": "4"}
+	// Date Range
+	day := 0
+	for pk, rating := range dateRangeRatings {
+		rows["review"][pk] = map[string]string{"rating": rating, "creation_date": fmt.Sprintf("2024-01-0%d", day%2+1)}
+		day++
+	}
+	return rows
+}
+
+// fixtureData returns the keys and values of the fixture rows and their indexes, laid out
+// like the generated datasets: table/col/pk for columns and table/col_index/value holding
+// the comma separated primary keys for indexes.
+func fixtureData() ([]string, []string) {
+	var keys, values []string
+	for table, tableRows := range fixtureRows() {
+		pks := make([]int, 0, len(tableRows))
+		for pk := range tableRows {
+			pks = append(pks, pk)
+		}
+		sort.Ints(pks)
+
+		index := make(map[string][]string)
+		for _, pk := range pks {
+			for col, value := range fixtureDefaults[table] {
+				if v, ok := tableRows[pk][col]; ok {
+					value = v
+				}
+				keys = append(keys, fmt.Sprintf("%s/%s/%d", table, col, pk))
+				values = append(values, value)
+				for _, indexed := range fixtureIndexes[table] {
+					if indexed == col {
+						indexKey := fmt.Sprintf("%s/%s_index/%s", table, col, value)
+						index[indexKey] = append(index[indexKey], fmt.Sprint(pk))
+					}
+				}
+			}
+		}
+		for indexKey, pks := range index {
+			keys = append(keys, indexKey)
+			values = append(values, strings.Join(pks, ","))
+		}
+	}
+	return keys, values
+}
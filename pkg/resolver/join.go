@@ -360,11 +360,7 @@ func (c *myResolver) indexFilterAndJoin(tableName string, searchMap *map[string]
 	if err != nil {
 		log.Fatal().Msgf("Failed to get Batch Client!")
 	}
-	resp, err := conn.AddKeys(context.Background(), &lbReq)
-	if err != nil {
-		log.Fatal().Msgf("Failed to fetch index keys from load balancer!: %s \n", err)
-	}
-
+	resp, err := c.fetchPadded(context.Background(), conn, strings.Split(tableName, ",")[0], &lbReq)
 	if err != nil {
 		log.Fatal().Msgf("Failed to fetch index keys from load balancer!: %s \n", err)
 	}
@@ -456,11 +452,11 @@ func (c *myResolver) indexFilterAndJoin(tableName string, searchMap *map[string]
 			log.Fatal().Msgf("Failed to get Batch Client!")
 		}
 
-		resp, err := conn.AddKeys(context.Background(), &lbReq1)
-		c.JoinFetchKeys.Add(int64(len(resp.Keys)))
+		resp, err := c.fetchPadded(context.Background(), conn, strings.Split(tableName, ",")[0], &lbReq1)
 		if err != nil {
 			log.Fatal().Msgf("Failed to fetch index keys from load balancer!: %s \n", err)
 		}
+		c.JoinFetchKeys.Add(int64(len(resp.Keys)))
 		span.AddEvent("Default - Fetched Join Columns")
 		span.SetAttributes(
 			attribute.Int("joinKeys", len(lbReq.Keys)),
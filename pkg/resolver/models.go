@@ -7,6 +7,7 @@ import (
 	blobloom "github.com/greatroar/blobloom"
 	loadBalancer "github.com/project/ObliSql/api/loadbalancer"
 	"github.com/project/ObliSql/api/resolver"
+	querytrace "github.com/project/ObliSql/pkg/queryTrace"
 	"go.opentelemetry.io/otel/trace"
 )
 
@@ -30,8 +31,14 @@ type myResolver struct {
 	joinRequests       atomic.Int64
 	Created            atomic.Int64
 	Inserted           atomic.Int64
+	PaddingKeys        atomic.Int64
+	PaddedRequests     atomic.Int64
+	BatcherRetries     atomic.Int64 // Batcher requests retried because its queues were full
 	UseBloom           bool
 	JoinBloomOptimized bool
+	VolumePadding      string
+	VolumeBound        int
+	QueryTrace         *querytrace.Writer // Logs every incoming query when set
 }
 
 type parsedQuery struct {
@@ -62,4 +69,7 @@ type MetaData struct {
 	PkStart        int                   `json:"pkStart"`
 	TableName      string                `json:"tableName"`
 	ColTypes       map[string]string     `json:"colTypes"`
+	ColSizes       map[string]int        `json:"colSizes,omitempty"`
+	VolumePadding  string                `json:"volumePadding,omitempty"`
+	VolumeBound    int                   `json:"volumeBound,omitempty"`
 }
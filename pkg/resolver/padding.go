@@ -0,0 +1,187 @@
+package resolver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/rand"
+
+	loadbalancer "github.com/project/ObliSql/api/loadbalancer"
+)
+
+// Volume hiding modes. A table can override the resolver wide mode through
+// the "volumePadding" field in its metadata entry.
+const (
+	PadModeNone  = "none"
+	PadModePow2  = "pow2"
+	PadModeBound = "bound"
+)
+
+// paddingFor returns the volume hiding mode and bound that apply to tableName.
+func (c *myResolver) paddingFor(tableName string) (string, int) {
+	mode, bound := c.VolumePadding, c.VolumeBound
+	if meta, ok := c.metaData[tableName]; ok && meta.VolumePadding != "" {
+		mode = meta.VolumePadding
+		if meta.VolumeBound > 0 {
+			bound = meta.VolumeBound
+		}
+	}
+	if mode == "" {
+		mode = PadModeNone
+	}
+	return mode, bound
+}
+
+func (c *myResolver) paddingEnabled(tableName string) bool {
+	mode, _ := c.paddingFor(tableName)
+	return mode != PadModeNone
+}
+
+// paddedSize returns the number of keys a request of n real keys is padded to.
+func paddedSize(mode string, bound int, n int) int {
+	switch mode {
+	case PadModePow2:
+		size := 1
+		for size < n {
+			size <<= 1
+		}
+		return size
+	case PadModeBound:
+		if bound <= 0 {
+			return n
+		}
+		if n <= bound {
+			return bound
+		}
+		//Larger results are rounded up to a multiple of the bound.
+		return ((n + bound - 1) / bound) * bound
+	default:
+		return n
+	}
+}
+
+// paddingKey creates a key under tableName shaped like the real keys of a fetch: it takes the
+// column of like, a real key of the request, or a random column of the table when there is
+// none, and a random primary key of the table. Executors see an ordinary row or index
+// fetch, and the response is stripped. Keys in taken are not reused.
+func (c *myResolver) paddingKey(tableName string, like string, taken map[string]bool) string {
+	meta := c.metaData[tableName]
+	column := ""
+	if parts := strings.Split(like, "/"); len(parts) == 3 {
+		column = parts[1]
+	} else if len(meta.ColNames) > 0 {
+		column = meta.ColNames[rand.Intn(len(meta.ColNames))]
+	}
+	for {
+		var pk uint64
+		if meta.PkEnd > meta.PkStart {
+			pk = uint64(meta.PkStart) + uint64(rand.Int63n(int64(meta.PkEnd-meta.PkStart+1)))
+		} else {
+			pk = rand.Uint64()
+		}
+		key := fmt.Sprintf("%s/%s/%d", tableName, column, pk)
+		if !taken[key] {
+			taken[key] = true
+			return key
+		}
+	}
+}
+
+// padRequest appends dummy GETs to lbReq according to the volume hiding mode of every table
+// it reads, so each table's executor sees a padded number of keys. tableName is padded
+// when the request has no keys at all. It returns the number of real keys so the padding
+// can be stripped from the response.
+func (c *myResolver) padRequest(tableName string, lbReq *loadbalancer.LoadBalanceRequest) int {
+	realKeys := len(lbReq.Keys)
+	counts := make(map[string]int)
+	like := make(map[string]string) // A real key of every table
+	taken := make(map[string]bool)
+	var tables []string // In order of first appearance, so padding is deterministic
+	for _, key := range lbReq.Keys {
+		table, _, _ := strings.Cut(key, "/")
+		if _, ok := counts[table]; !ok {
+			tables = append(tables, table)
+			like[table] = key
+		}
+		counts[table]++
+		taken[key] = true
+	}
+	if realKeys == 0 && tableName != "" {
+		tables = append(tables, tableName)
+	}
+
+	padded := false
+	for _, table := range tables {
+		mode, bound := c.paddingFor(table)
+		if mode == PadModeNone {
+			continue
+		}
+		target := paddedSize(mode, bound, counts[table])
+		for i := counts[table]; i < target; i++ {
+			lbReq.Keys = append(lbReq.Keys, c.paddingKey(table, like[table], taken))
+			lbReq.Values = append(lbReq.Values, "")
+		}
+		padded = true
+	}
+	if padded {
+		c.PaddingKeys.Add(int64(len(lbReq.Keys) - realKeys))
+		c.PaddedRequests.Add(1)
+	}
+	return realKeys
+}
+
+// stripPadding drops the responses for dummy keys. The batcher returns keys in
+// request order so the real responses are always the first realKeys entries.
+func stripPadding(resp *loadbalancer.LoadBalanceResponse, realKeys int) {
+	if len(resp.Keys) > realKeys {
+		resp.Keys = resp.Keys[:realKeys]
+	}
+	if len(resp.Values) > realKeys {
+		resp.Values = resp.Values[:realKeys]
+	}
+}
+
+// fetchPadded sends lbReq padded for volume hiding and returns the response to its real keys,
+// with value padding removed.
+func (c *myResolver) fetchPadded(ctx context.Context, conn loadbalancer.LoadBalancerClient, tableName string, lbReq *loadbalancer.LoadBalanceRequest) (*loadbalancer.LoadBalanceResponse, error) {
+	realKeys := c.padRequest(tableName, lbReq)
+	resp, err := conn.AddKeys(ctx, lbReq)
+	if err != nil {
+		return nil, err
+	}
+	stripPadding(resp, realKeys)
+	unpadResponse(resp)
+	return resp, nil
+}
+
+// checkVolumePadding rejects volume hiding settings that cannot pad, such as the bound mode
+// without a positive bound, for the resolver and for every table.
+func (c *myResolver) checkVolumePadding() error {
+	check := func(where, mode string, bound int) error {
+		switch mode {
+		case "", PadModeNone, PadModePow2:
+			return nil
+		case PadModeBound:
+			if bound <= 0 {
+				return fmt.Errorf("%s: volume hiding mode bound needs a positive bound, got %d", where, bound)
+			}
+			return nil
+		default:
+			return fmt.Errorf("%s: unknown volume hiding mode %q", where, mode)
+		}
+	}
+	if err := check("resolver", c.VolumePadding, c.VolumeBound); err != nil {
+		return err
+	}
+	for tableName, meta := range c.metaData {
+		if meta.VolumePadding == "" {
+			continue
+		}
+		mode, bound := c.paddingFor(tableName)
+		if err := check("table "+tableName, mode, bound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	loadbalancer "github.com/project/ObliSql/api/loadbalancer"
+)
+
+func TestPaddedSize(t *testing.T) {
+	tests := []struct {
+		mode  string
+		bound int
+		n     int
+		want  int
+	}{
+		{PadModeNone, 0, 5, 5},
+		{PadModePow2, 0, 0, 1},
+		{PadModePow2, 0, 1, 1},
+		{PadModePow2, 0, 5, 8},
+		{PadModePow2, 0, 8, 8},
+		{PadModePow2, 0, 9, 16},
+		{PadModeBound, 10, 0, 10},
+		{PadModeBound, 10, 3, 10},
+		{PadModeBound, 10, 10, 10},
+		{PadModeBound, 10, 11, 20},
+		{PadModeBound, 10, 25, 30},
+		{PadModeBound, 0, 7, 7},
+	}
+	for _, tt := range tests {
+		if got := paddedSize(tt.mode, tt.bound, tt.n); got != tt.want {
+			t.Errorf("paddedSize(%q, %d, %d) = %d, want %d", tt.mode, tt.bound, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPadRequest(t *testing.T) {
+	r := &myResolver{
+		VolumePadding: PadModePow2,
+		metaData: map[string]MetaData{
+			"users":  {VolumePadding: PadModeBound, VolumeBound: 4},
+			"review": {VolumePadding: PadModeNone},
+		},
+	}
+	keys := func(table string, n int) []string {
+		var keys []string
+		for i := 0; i < n; i++ {
+			keys = append(keys, fmt.Sprintf("%s/col/%d", table, i))
+		}
+		return keys
+	}
+	tests := []struct {
+		name      string
+		tableName string
+		keys      []string
+		want      map[string]int // Keys per table after padding
+	}{
+		{"resolver mode", "item", keys("item", 3), map[string]int{"item": 4}},
+		{"table bound", "users", keys("users", 3), map[string]int{"users": 4}},
+		{"over the bound", "users", keys("users", 5), map[string]int{"users": 8}},
+		{"padding off", "review", keys("review", 3), map[string]int{"review": 3}},
+		{"per table", "item,users", append(keys("item", 5), keys("users", 1)...), map[string]int{"item": 8, "users": 4}},
+		{"empty request", "users", nil, map[string]int{"users": 4}},
+		{"empty request without table", "", nil, map[string]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &loadbalancer.LoadBalanceRequest{Keys: append([]string(nil), tt.keys...), Values: make([]string, len(tt.keys))}
+			if realKeys := r.padRequest(tt.tableName, req); realKeys != len(tt.keys) {
+				t.Errorf("padRequest() = %d, want %d", realKeys, len(tt.keys))
+			}
+			if len(req.Values) != len(req.Keys) {
+				t.Errorf("%d values for %d keys", len(req.Values), len(req.Keys))
+			}
+			for i, key := range tt.keys {
+				if req.Keys[i] != key {
+					t.Fatalf("real key %d = %s, want %s", i, req.Keys[i], key)
+				}
+			}
+			got := make(map[string]int)
+			for _, key := range req.Keys {
+				table, _, _ := strings.Cut(key, "/")
+				got[table]++
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("keys per table = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaddingKeysLookReal(t *testing.T) {
+	r := &myResolver{
+		VolumePadding: PadModeBound,
+		VolumeBound:   64,
+		metaData:      map[string]MetaData{"users": {PkStart: 10, PkEnd: 99, ColNames: []string{"name", "age"}}},
+	}
+	tests := []struct {
+		name    string
+		keys    []string
+		columns []string // Columns a pad key may use
+	}{
+		{"row fetch", []string{"users/name/12"}, []string{"name"}},
+		{"index fetch", []string{"users/age_index/30"}, []string{"age_index"}},
+		{"empty request", nil, []string{"name", "age"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &loadbalancer.LoadBalanceRequest{Keys: append([]string(nil), tt.keys...), Values: make([]string, len(tt.keys))}
+			r.padRequest("users", req)
+			seen := make(map[string]bool)
+			for _, key := range req.Keys {
+				if seen[key] {
+					t.Errorf("key %s sent twice", key)
+				}
+				seen[key] = true
+				parts := strings.Split(key, "/")
+				if len(parts) != 3 || parts[0] != "users" || !slices.Contains(tt.columns, parts[1]) {
+					t.Fatalf("pad key %s is not a key of users on %v", key, tt.columns)
+				}
+				if pk, err := strconv.Atoi(parts[2]); err != nil || pk < 10 || pk > 99 {
+					t.Errorf("pad key %s is outside the primary keys of users", key)
+				}
+			}
+		})
+	}
+}
+
+func TestStripPadding(t *testing.T) {
+	tests := []struct {
+		keys     []string
+		realKeys int
+		want     []string
+	}{
+		{[]string{"a", "b", "pad", "pad"}, 2, []string{"a", "b"}},
+		{[]string{"a", "b"}, 2, []string{"a", "b"}},
+		{[]string{"pad"}, 0, []string{}},
+	}
+	for _, tt := range tests {
+		resp := &loadbalancer.LoadBalanceResponse{Keys: tt.keys, Values: tt.keys}
+		stripPadding(resp, tt.realKeys)
+		if fmt.Sprint(resp.Keys) != fmt.Sprint(tt.want) || fmt.Sprint(resp.Values) != fmt.Sprint(tt.want) {
+			t.Errorf("stripPadding(%v, %d) = %v, %v, want %v", tt.keys, tt.realKeys, resp.Keys, resp.Values, tt.want)
+		}
+	}
+}
+
+func TestCheckVolumePadding(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     *myResolver
+		valid bool
+	}{
+		{"none", &myResolver{VolumePadding: PadModeNone}, true},
+		{"bound", &myResolver{VolumePadding: PadModeBound, VolumeBound: 8}, true},
+		{"bound without bound", &myResolver{VolumePadding: PadModeBound}, false},
+		{"unknown mode", &myResolver{VolumePadding: "pow3"}, false},
+		{"table bound without bound", &myResolver{metaData: map[string]MetaData{"users": {VolumePadding: PadModeBound}}}, false},
+		{"table bound from resolver", &myResolver{VolumeBound: 8, metaData: map[string]MetaData{"users": {VolumePadding: PadModeBound}}}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.r.checkVolumePadding(); (err == nil) != tt.valid {
+			t.Errorf("%s: checkVolumePadding() = %v", tt.name, err)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,6 +28,11 @@ import (
 
 func (c *myResolver) ExecuteQuery(ctx context.Context, q *resolver.ParsedQuery) (*resolver.QueryResponse, error) {
 	requestID := c.localRequestID.Add(1)
+	if c.QueryTrace != nil {
+		if err := c.QueryTrace.Record(q); err != nil {
+			log.Error().Msgf("Failed to record query %d: %v", requestID, err)
+		}
+	}
 	clientId, errConv := strconv.Atoi(q.ClientId)
 	if errConv != nil {
 		return nil, fmt.Errorf("error converting clientId to integer: %w", errConv)
@@ -100,7 +106,10 @@ func (r *myResolver) InitDB(ctx context.Context, filePath string) bool {
 			if op == "SET" {
 				totalKeys++
 				key := parts[1]
-				value := parts[2]
+				value, err := r.padValue(key, parts[2])
+				if err != nil {
+					log.Fatal().Msgf("Failed to pad value during load: %s", err)
+				}
 				req.Keys = append(req.Keys, key)
 				req.Values = append(req.Values, value)
 			}
@@ -124,30 +133,35 @@ func (r *myResolver) InitDB(ctx context.Context, filePath string) bool {
 }
 
 func (r *myResolver) readMetaData(filePath string) {
-	//MetaData
-	file, err := os.Open(filePath)
+	data, err := ReadMetaData(filePath)
 	if err != nil {
-		log.Fatal().Msgf("Error opening metadata file: %s\n", err)
+		log.Fatal().Msgf("%s\n", err)
 		return
 	}
+	// for table, meta := range data {
+	// 	fmt.Printf("Table: %s, MetaData: %+v\n", table, meta)
+	// }
+	r.metaData = data
+}
+
+// ReadMetaData reads the table metadata file at filePath, keyed by table name.
+func ReadMetaData(filePath string) (map[string]MetaData, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening metadata file: %w", err)
+	}
 	defer file.Close()
 
 	byteValue, err := io.ReadAll(file)
 	if err != nil {
-		log.Fatal().Msgf("Error reading metadata file: %s\n", err)
-		return
+		return nil, fmt.Errorf("error reading metadata file: %w", err)
 	}
 
 	var data map[string]MetaData
-	err = json.Unmarshal(byteValue, &data)
-	if err != nil {
-		log.Fatal().Msgf("Error unmarshaling JSON: %s\n", err)
-		return
+	if err := json.Unmarshal(byteValue, &data); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata: %w", err)
 	}
-	// for table, meta := range data {
-	// 	fmt.Printf("Table: %s, MetaData: %+v\n", table, meta)
-	// }
-	r.metaData = data
+	return data, nil
 }
 
 // func (r *myResolver) readJSONToMap(filePath string) {
@@ -304,6 +318,7 @@ func (r *myResolver) connectToBatchers(lbHosts []string, lbPorts []string) {
 
 		lbAddr := lbHosts[i] + ":" + lbPorts[i]
 		conn, err := grpc.NewClient(lbAddr, grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithUnaryInterceptor(r.retryExhausted),
 			grpc.WithDefaultCallOptions(
 				grpc.MaxCallRecvMsgSize(600*1024*1024),
 				grpc.MaxCallSendMsgSize(600*1024*1024)))
@@ -346,7 +361,7 @@ func (r *myResolver) GetBatchClient() (loadBalancer.LoadBalancerClient, error) {
 	return r.connPool[randomKey], nil
 }
 
-func NewResolver(ctx context.Context, lbAddr []string, lbPort []string, traceLocation string, metaDataLoc string, joinMapLoc string, tracer trace.Tracer, useBloom bool, JoinBloomOptimized bool) *myResolver {
+func NewResolver(ctx context.Context, lbAddr []string, lbPort []string, traceLocation string, metaDataLoc string, joinMapLoc string, tracer trace.Tracer, useBloom bool, JoinBloomOptimized bool, volumePadding string, volumeBound int) *myResolver {
 
 	// Seed the random generator (ideally, do this once in an init function)
 	rand.Seed(uint64(time.Now().UnixNano()))
@@ -368,22 +383,32 @@ func NewResolver(ctx context.Context, lbAddr []string, lbPort []string, traceLoc
 		joinRequests:    atomic.Int64{},
 		Created:         atomic.Int64{},
 		Inserted:        atomic.Int64{},
+		PaddingKeys:     atomic.Int64{},
+		PaddedRequests:  atomic.Int64{},
 	}
 	service.UseBloom = useBloom
 	service.JoinBloomOptimized = JoinBloomOptimized
+	service.VolumePadding = volumePadding
+	service.VolumeBound = volumeBound
 
 	fmt.Printf("Using Bloom Filter? %t\n", service.UseBloom)
 	fmt.Printf("Using Optimized Bloom Join? %t\n", service.JoinBloomOptimized)
+	fmt.Printf("Volume Hiding Mode: %s (bound %d)\n", service.VolumePadding, service.VolumeBound)
 
 	service.connectToBatchers(lbAddr, lbPort)
 
 	service.readMetaData(metaDataLoc)
+	if err := service.checkVolumePadding(); err != nil {
+		log.Fatal().Msgf("Invalid volume hiding settings: %v", err)
+	}
 	// service.readJoinMap(joinMapLoc)
 	// service.InitDB(ctx, traceLocation) //Initialize the DB
 
-	service.readJoinFilters("../../metaData/JoinMaps/pairList/pairs_review_trust.json", "review,trust")           //PK,FK
-	service.readJoinFilters("../../metaData/JoinMaps/pairList/pairs_item_review.json", "review,item")             //PK,FK
-	service.readJoinFilters("../../metaData/JoinMaps/pairList/pairs_pageURL_destURL.json", "rankings,uservisits") //PK,FK
+	// The pair lists live next to the join map
+	pairList := filepath.Join(filepath.Dir(joinMapLoc), "pairList")
+	service.readJoinFilters(filepath.Join(pairList, "pairs_review_trust.json"), "review,trust")           //PK,FK
+	service.readJoinFilters(filepath.Join(pairList, "pairs_item_review.json"), "review,item")             //PK,FK
+	service.readJoinFilters(filepath.Join(pairList, "pairs_pageURL_destURL.json"), "rankings,uservisits") //PK,FK
 	// service.readJSONToMap("../../metaData/partitionMap/max_chunk_sizes.json")
 
 	if service.UseBloom {
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/project/ObliSql/api/resolver"
+	testharness "github.com/project/ObliSql/pkg/testHarness"
 	"golang.org/x/exp/rand"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
@@ -1015,17 +1016,52 @@ func getTestCases() []TestCase {
 	return testCases
 }
 
-func TestSelectSequential(t *testing.T) {
-	fmt.Println("-------------------------------")
-	fmt.Println("Testing Sequential Select")
+// stack is the in-process stack the tests run against when no resolver is given.
+var (
+	stack     *testharness.Stack
+	stackErr  error
+	stackOnce sync.Once
+)
+
+// newResolverClient connects to the resolver at $OBLISQL_RESOLVER, such as localhost:9900 for
+// a deployed stack loaded with the Epinions Scale-1 dataset. Without it, the tests boot a
+// resolver, a batcher and an in-memory executor in this process, loaded with the fixture rows
+// the test cases expect, or with the tracefile at $OBLISQL_TRACEFILE if it is set.
+func newResolverClient(t *testing.T) resolver.ResolverClient {
+	t.Helper()
+	if addr := os.Getenv("OBLISQL_RESOLVER"); addr != "" {
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(644000*300), grpc.MaxCallSendMsgSize(644000*300)))
+		if err != nil {
+			t.Fatalf("Failed to open connection to Resolver: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return resolver.NewResolverClient(conn)
+	}
 
-	resolver_addr := "localhost:9900"
-	conn, err := grpc.NewClient(resolver_addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(644000*300), grpc.MaxCallSendMsgSize(644000*300)))
+	// A single stack for every test, reloaded so updates of one test do not leak into the next
+	stackOnce.Do(func() {
+		stack, stackErr = testharness.Start("../../metaData/metadata.txt", 800, 5)
+	})
+	if stackErr != nil {
+		t.Fatalf("Failed to start in-process stack: %v", stackErr)
+	}
+	var err error
+	if tracefile := os.Getenv("OBLISQL_TRACEFILE"); tracefile != "" {
+		err = stack.LoadTrace(tracefile)
+	} else {
+		err = stack.Load(fixtureData())
+	}
 	if err != nil {
-		log.Fatalf("Failed to open connection to Resolver")
+		t.Fatalf("Failed to load the dataset: %v", err)
 	}
+	return stack.Client
+}
+
+func TestSelectSequential(t *testing.T) {
+	fmt.Println("-------------------------------")
+	fmt.Println("Testing Sequential Select")
 
-	resolverClient := resolver.NewResolverClient(conn)
+	resolverClient := newResolverClient(t)
 	testcases := getTestCases()
 
 	for _, tc := range testcases {
@@ -1059,13 +1095,7 @@ func TestSelectSequential(t *testing.T) {
 
 func TestUpdate(t *testing.T) {
 
-	resolver_addr := "localhost:9900"
-	conn, err := grpc.NewClient(resolver_addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(644000*300), grpc.MaxCallSendMsgSize(644000*300)))
-	if err != nil {
-		log.Fatalf("Failed to open connection to Resolver")
-	}
-
-	resolverClient := resolver.NewResolverClient(conn)
+	resolverClient := newResolverClient(t)
 
 	numUpdates := 5
 
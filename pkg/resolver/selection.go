@@ -195,7 +195,7 @@ func (c *myResolver) constructRangeIndexDate(searchCol, searchValueStart, search
 func (c *myResolver) constructRequestAndFetch(pkList []string, requestID int64, q *resolver.ParsedQuery) ([]string, []string, error) {
 	ctx := context.Background()
 
-	if len(pkList) == 0 {
+	if len(pkList) == 0 && !c.paddingEnabled(q.TableName) {
 		return []string{}, []string{}, nil
 	}
 
@@ -222,7 +222,7 @@ func (c *myResolver) constructRequestAndFetch(pkList []string, requestID int64,
 	if err != nil {
 		log.Fatal().Msgf("Failed to get Batch Client!")
 	}
-	valueRes, err := conn.AddKeys(ctx, &valReq)
+	valueRes, err := c.fetchPadded(ctx, conn, q.TableName, &valReq)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to fetch values: %w", err)
 	}
@@ -267,7 +267,7 @@ func (c *myResolver) getFullColumn(tableName, colName string, localRequestID int
 		log.Fatal().Msgf("Failed to get Batch Client!")
 	}
 
-	fullCol, err := conn.AddKeys(ctx, &req)
+	fullCol, err := c.fetchPadded(ctx, conn, tableName, &req)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch full column: %w", err)
@@ -403,17 +403,17 @@ func (c *myResolver) filterPkUsingIndex(q *resolver.ParsedQuery, localRequestID
 	c.SelectIndexKeys.Add(int64(len(indexReqKeys.Keys)))
 	// log.Debug().Msgf(strconv.Itoa(len(indexReqKeys.Keys)))
 
-	if len(indexReqKeys.Keys) == 0 {
+	if len(indexReqKeys.Keys) == 0 && !c.paddingEnabled(q.TableName) {
 		//Filter resulted in no valid finds
 		return nil, nil
 	}
-
 	conn, err := c.GetBatchClient()
 	if err != nil {
 		log.Fatal().Msgf("Failed to get batch Client!")
 	}
 
-	resp, err := conn.AddKeys(ctx, &indexReqKeys)
+	//Pad the index lookup so the number of index keys does not reveal the range size.
+	resp, err := c.fetchPadded(ctx, conn, q.TableName, &indexReqKeys)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch index value: %w", err)
 	}
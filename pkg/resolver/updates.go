@@ -30,8 +30,12 @@ func (c *myResolver) constructRequestAndUpdate(pkList []string, requestID int64,
 	for _, pk := range pkList {
 		for ind, col := range updateCols {
 			keyVal := fmt.Sprintf("%s/%s/%s", q.TableName, col, pk)
+			newVal, err := c.padValue(keyVal, q.UpdateVal[ind])
+			if err != nil {
+				return nil, nil, err
+			}
 			valReq.Keys = append(valReq.Keys, keyVal)
-			valReq.Values = append(valReq.Values, q.UpdateVal[ind])
+			valReq.Values = append(valReq.Values, newVal)
 		}
 	}
 
@@ -40,7 +44,7 @@ func (c *myResolver) constructRequestAndUpdate(pkList []string, requestID int64,
 		log.Fatal().Msgf("Failed to get Batch Client!")
 	}
 
-	valueRes, err := conn.AddKeys(ctx, &valReq)
+	valueRes, err := c.fetchPadded(ctx, conn, q.TableName, &valReq)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to update values: %w", err)
 	}
@@ -261,7 +261,7 @@ func (c *myResolver) simpleFetch(keys []string, val []string, reqId int64) ([]st
 		log.Fatal().Msgf("Failed to get Batch Client!")
 	}
 
-	resp, err := conn.AddKeys(ctx, &indexReqKeys)
+	resp, err := c.fetchPadded(ctx, conn, "", &indexReqKeys)
 	if err != nil {
 		log.Fatal().Msgf("Failed to fetch from load balancer! %s \n", err)
 	}
@@ -0,0 +1,61 @@
+package resolver
+
+import (
+	"fmt"
+	"strings"
+
+	loadbalancer "github.com/project/ObliSql/api/loadbalancer"
+)
+
+// ValuePadChar fills values up to their declared column size. It is a control
+// character so it never appears in the datasets and survives the space
+// separated tracefile format.
+const ValuePadChar = "\x1f"
+
+// PadValue pads value to size bytes. Values that already exceed size are rejected
+// because storing them would reveal the overflow through the ciphertext length.
+// Values ending in ValuePadChar are rejected too, UnpadValue could not tell them apart
+// from padding.
+func PadValue(value string, size int) (string, error) {
+	if strings.HasSuffix(value, ValuePadChar) {
+		return "", fmt.Errorf("value ends in the padding character %q", ValuePadChar)
+	}
+	if size <= 0 {
+		return value, nil
+	}
+	if len(value) > size {
+		return "", fmt.Errorf("value of length %d exceeds declared column size %d", len(value), size)
+	}
+	return value + strings.Repeat(ValuePadChar, size-len(value)), nil
+}
+
+// UnpadValue removes the padding added by PadValue. Unpadded values are returned as is.
+func UnpadValue(value string) string {
+	return strings.TrimRight(value, ValuePadChar)
+}
+
+// ColumnSize returns the padded size declared for the column a key belongs to.
+// Keys are of the form table/column/pk or table/column_index/value, so index
+// entries can be given their own size in colSizes.
+func ColumnSize(metaData map[string]MetaData, key string) int {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 2 {
+		return 0
+	}
+	return metaData[parts[0]].ColSizes[parts[1]]
+}
+
+func (c *myResolver) padValue(key, value string) (string, error) {
+	padded, err := PadValue(value, ColumnSize(c.metaData, key))
+	if err != nil {
+		return "", fmt.Errorf("cannot pad %s: %w", key, err)
+	}
+	return padded, nil
+}
+
+// unpadResponse strips value padding from everything the batcher returned.
+func unpadResponse(resp *loadbalancer.LoadBalanceResponse) {
+	for i, v := range resp.Values {
+		resp.Values[i] = UnpadValue(v)
+	}
+}
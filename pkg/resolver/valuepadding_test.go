@@ -0,0 +1,74 @@
+package resolver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPadValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		size  int
+	}{
+		{"short", "abc", 8},
+		{"empty", "", 4},
+		{"exactly the size", "abcd", 4},
+		{"pad character inside", "a\x1fb", 8},
+		{"no size", "abc", 0},
+	}
+	for _, tt := range tests {
+		padded, err := PadValue(tt.value, tt.size)
+		if err != nil {
+			t.Fatalf("%s: PadValue() = %v", tt.name, err)
+		}
+		if tt.size > 0 && len(padded) != tt.size {
+			t.Errorf("%s: padded to %d bytes, want %d", tt.name, len(padded), tt.size)
+		}
+		if got := UnpadValue(padded); got != tt.value {
+			t.Errorf("%s: UnpadValue(PadValue(%q)) = %q", tt.name, tt.value, got)
+		}
+	}
+}
+
+func TestPadValueRejects(t *testing.T) {
+	if _, err := PadValue("abcde", 4); err == nil {
+		t.Error("PadValue() accepted a value over the column size")
+	}
+	// UnpadValue strips every trailing pad character, so these would not round trip
+	for _, size := range []int{0, 8} {
+		if _, err := PadValue("abc\x1f", size); err == nil {
+			t.Errorf("PadValue() with size %d accepted a value ending in the pad character", size)
+		}
+	}
+}
+
+func TestColumnSize(t *testing.T) {
+	metaData := map[string]MetaData{
+		"users": {ColSizes: map[string]int{"name": 16, "name_index": 8}},
+	}
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"users/name/1", 16},
+		{"users/name_index/bob", 8},
+		{"users/age/1", 0},
+		{"items/name/1", 0},
+		{"users", 0},
+	}
+	for _, tt := range tests {
+		if got := ColumnSize(metaData, tt.key); got != tt.want {
+			t.Errorf("ColumnSize(%s) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+
+	r := &myResolver{metaData: metaData}
+	padded, err := r.padValue("users/name/1", "bob")
+	if err != nil || len(padded) != 16 || UnpadValue(padded) != "bob" {
+		t.Errorf("padValue() = %q, %v", padded, err)
+	}
+	if _, err := r.padValue("users/name/1", strings.Repeat("x", 17)); err == nil {
+		t.Error("padValue() accepted a value over the column size")
+	}
+}
@@ -0,0 +1,155 @@
+package testharness
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	loadBalancer "github.com/project/ObliSql/api/loadbalancer"
+	resolverAPI "github.com/project/ObliSql/api/resolver"
+	batcher "github.com/project/ObliSql/pkg/batchManager"
+	memoryexecutor "github.com/project/ObliSql/pkg/memoryExecutor"
+	"github.com/project/ObliSql/pkg/resolver"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var stackCount atomic.Int32
+
+// Stack is a resolver, a batcher and an in-memory executor running in the current process,
+// so queries can be tested end to end with go test alone. The resolver and the batcher talk
+// over gRPC on loopback ports the same as when deployed, only the executor is in-process.
+type Stack struct {
+	// Addr is the address of the resolver
+	Addr string
+	// Client is a client of the resolver at Addr
+	Client resolverAPI.ResolverClient
+	// Executor holds the data, it can be inspected or reloaded between queries
+	Executor *memoryexecutor.Executor
+
+	metaData map[string]resolver.MetaData
+	conn     *grpc.ClientConn
+	servers  []*grpc.Server
+	cancel   context.CancelFunc
+	dir      string
+}
+
+// Start boots a stack using the table metadata at metaDataLoc. R is the number of requests
+// per batch and waitTime the batcher queue wait in milliseconds. The executor starts empty,
+// fill it with Load or LoadTrace.
+func Start(metaDataLoc string, R int, waitTime int) (*Stack, error) {
+	metaData, err := resolver.ReadMetaData(metaDataLoc)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "oblisql-stack")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Stack{metaData: metaData, cancel: cancel, dir: dir}
+	if err := s.start(ctx, metaDataLoc, R, waitTime); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Stack) start(ctx context.Context, metaDataLoc string, R int, waitTime int) error {
+	// Every table goes to the single executor
+	configPath := filepath.Join(s.dir, "table_config.json")
+	config := `{"tables": [], "total_partitions": 1, "default_partitioning": "hash"}`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		return err
+	}
+	// The resolver needs the join pair lists, none of the joins have matching rows
+	joinMapLoc := filepath.Join(s.dir, "JoinMaps", "join_map.json")
+	pairList := filepath.Join(s.dir, "JoinMaps", "pairList")
+	if err := os.MkdirAll(pairList, 0700); err != nil {
+		return err
+	}
+	for _, name := range []string{"pairs_review_trust.json", "pairs_item_review.json", "pairs_pageURL_destURL.json"} {
+		if err := os.WriteFile(filepath.Join(pairList, name), []byte("[]"), 0600); err != nil {
+			return err
+		}
+	}
+
+	tracer := otel.Tracer("")
+
+	// The executor address only names the in-memory store, nothing listens on it. Each
+	// stack gets its own so stacks in one process do not share data.
+	executorHost, executorPort := "memory", int(stackCount.Add(1))
+	s.Executor = memoryexecutor.Store(executorHost, executorPort)
+
+	batcherLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	batchService := batcher.NewBatcher(ctx, R, 1, waitTime, memoryexecutor.ExecutorType, nil, executorHost, strconv.Itoa(executorPort), 1, false, false, nil, nil, tracer, configPath)
+	batcherServer := grpc.NewServer()
+	loadBalancer.RegisterLoadBalancerServer(batcherServer, batchService)
+	s.servers = append(s.servers, batcherServer)
+	go batcherServer.Serve(batcherLis)
+
+	resolverLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	batcherHost, batcherPort, _ := net.SplitHostPort(batcherLis.Addr().String())
+	resolverService := resolver.NewResolver(ctx, []string{batcherHost}, []string{batcherPort}, "", metaDataLoc, joinMapLoc, tracer, false, false, "none", 0)
+	resolverServer := grpc.NewServer()
+	resolverAPI.RegisterResolverServer(resolverServer, resolverService)
+	s.servers = append(s.servers, resolverServer)
+	go resolverServer.Serve(resolverLis)
+
+	s.Addr = resolverLis.Addr().String()
+	s.conn, err = grpc.NewClient(s.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	s.Client = resolverAPI.NewResolverClient(s.conn)
+	return nil
+}
+
+// Load replaces the data with keys and values, padding the values to their declared column
+// sizes the same as the resolver does when it initializes the database.
+func (s *Stack) Load(keys []string, values []string) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("got %d keys but %d values", len(keys), len(values))
+	}
+	padded := make([]string, len(values))
+	for i, value := range values {
+		var err error
+		if padded[i], err = resolver.PadValue(value, resolver.ColumnSize(s.metaData, keys[i])); err != nil {
+			return fmt.Errorf("cannot pad %s: %w", keys[i], err)
+		}
+	}
+	return s.Executor.InitDB(keys, padded)
+}
+
+// LoadTrace replaces the data with the SET lines of a tracefile.
+func (s *Stack) LoadTrace(tracefile string) error {
+	keys, values, err := memoryexecutor.ReadTrace(tracefile)
+	if err != nil {
+		return err
+	}
+	return s.Load(keys, values)
+}
+
+// Close stops the stack, along with the coordinator and workers of its batcher.
+func (s *Stack) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	for _, server := range s.servers {
+		server.Stop()
+	}
+	s.cancel()
+	os.RemoveAll(s.dir)
+}
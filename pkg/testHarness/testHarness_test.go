@@ -0,0 +1,151 @@
+package testharness
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	resolverAPI "github.com/project/ObliSql/api/resolver"
+)
+
+// reviewRows is a small review table, indexed below the same way as the generated datasets.
+var reviewRows = []map[string]string{
+	{"a_id": "10", "u_id": "812", "i_id": "7", "rating": "2", "rank": "nan", "comment": "first", "creation_date": "2020-12-15"},
+	{"a_id": "10", "u_id": "812", "i_id": "8", "rating": "4", "rank": "nan", "comment": "second", "creation_date": "2021-01-02"},
+	{"a_id": "11", "u_id": "900", "i_id": "7", "rating": "0", "rank": "nan", "comment": "third", "creation_date": "2021-03-04"},
+}
+
+func reviewData() ([]string, []string) {
+	var keys, values []string
+	index := make(map[string]string)
+	var indexKeys []string
+	for pk, row := range reviewRows {
+		for col, value := range row {
+			keys = append(keys, fmt.Sprintf("review/%s/%d", col, pk))
+			values = append(values, value)
+		}
+		for _, col := range []string{"u_id", "a_id", "i_id", "creation_date"} {
+			indexKey := fmt.Sprintf("review/%s_index/%s", col, row[col])
+			if _, ok := index[indexKey]; !ok {
+				indexKeys = append(indexKeys, indexKey)
+				index[indexKey] = fmt.Sprint(pk)
+			} else {
+				index[indexKey] += fmt.Sprintf(",%d", pk)
+			}
+		}
+	}
+	for _, key := range indexKeys {
+		keys = append(keys, key)
+		values = append(values, index[key])
+	}
+	return keys, values
+}
+
+// stack is shared by the tests, which load the data they need into it
+var stack *Stack
+
+func TestMain(m *testing.M) {
+	var err error
+	stack, err = Start("../../metaData/metadata.txt", 10, 5)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Start() error = %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	stack.Close()
+	os.Exit(code)
+}
+
+// reviewStack returns the shared stack loaded with the review rows.
+func reviewStack(t *testing.T) *Stack {
+	t.Helper()
+	if err := stack.Load(reviewData()); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return stack
+}
+
+func query(t *testing.T, s *Stack, q *resolverAPI.ParsedQuery) map[string]string {
+	t.Helper()
+	q.ClientId = "1"
+	resp, err := s.Client.ExecuteQuery(context.Background(), q)
+	if err != nil {
+		t.Fatalf("ExecuteQuery(%s) error = %v", q.QueryType, err)
+	}
+	result := make(map[string]string, len(resp.Keys))
+	for i, key := range resp.Keys {
+		result[key] = resp.Values[i]
+	}
+	return result
+}
+
+func TestPointSelect(t *testing.T) {
+	s := reviewStack(t)
+
+	got := query(t, s, &resolverAPI.ParsedQuery{
+		QueryType:  "select",
+		TableName:  "review",
+		ColToGet:   []string{"rating"},
+		SearchCol:  []string{"u_id"},
+		SearchVal:  []string{"812"},
+		SearchType: []string{"point"},
+	})
+	want := map[string]string{"review/rating/0": "2", "review/rating/1": "4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("select rating where u_id = 812 = %v, want %v", got, want)
+	}
+}
+
+func TestSelectTwoFilters(t *testing.T) {
+	s := reviewStack(t)
+
+	got := query(t, s, &resolverAPI.ParsedQuery{
+		QueryType:  "select",
+		TableName:  "review",
+		ColToGet:   []string{"*"},
+		SearchCol:  []string{"a_id", "i_id"},
+		SearchVal:  []string{"10", "7"},
+		SearchType: []string{"point", "point"},
+	})
+	want := make(map[string]string)
+	for col, value := range reviewRows[0] {
+		want["review/"+col+"/0"] = value
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("select * where a_id = 10 and i_id = 7 = %v, want %v", got, want)
+	}
+}
+
+func TestUpdateThenSelect(t *testing.T) {
+	s := reviewStack(t)
+
+	for _, comment := range []string{"updated", "updated again"} {
+		query(t, s, &resolverAPI.ParsedQuery{
+			QueryType:  "update",
+			TableName:  "review",
+			ColToGet:   []string{"comment"},
+			SearchCol:  []string{"a_id", "i_id"},
+			SearchVal:  []string{"10", "7"},
+			SearchType: []string{"point", "point"},
+			UpdateVal:  []string{comment},
+		})
+		if value, _ := s.Executor.Get("review/comment/0"); value != comment {
+			t.Errorf("stored comment after update = %q, want %q", value, comment)
+		}
+
+		got := query(t, s, &resolverAPI.ParsedQuery{
+			QueryType:  "select",
+			TableName:  "review",
+			ColToGet:   []string{"comment"},
+			SearchCol:  []string{"u_id"},
+			SearchVal:  []string{"812"},
+			SearchType: []string{"point"},
+		})
+		want := map[string]string{"review/comment/0": comment, "review/comment/1": "second"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("select comment after update = %v, want %v", got, want)
+		}
+	}
+}